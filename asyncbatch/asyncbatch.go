@@ -1,116 +1,716 @@
 package asyncbatch
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
 	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
-	"unsafe"
 
 	"github.com/kaichao/gopkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+var (
+	// ErrClosed is returned by Add and the other methods that need a running
+	// processor once Shutdown has been called or the WithContext context is
+	// done.
+	ErrClosed = errors.New("batch processor is closed")
+
+	// ErrChannelFull is returned, possibly wrapped, by Add, AddPriority and
+	// AddFlushMarker when the queue has no room for the task.
+	ErrChannelFull = errors.New("task channel is full")
+
+	// ErrMemoryLimit is returned, possibly wrapped, by Add and its variants
+	// when accepting the task would take the estimated size of the unprocessed
+	// tasks over the WithMemoryLimit budget.
+	ErrMemoryLimit = errors.New("task memory limit reached")
+)
+
+// config holds the settings applied by options. It is not generic, so the
+// same Option works for every task type; T-typed settings are stored as any
+// and checked by the constructor.
+type config struct {
+	maxSize          int // Initial batch limits; at runtime they live in BatchProcessor.limits
+	upperRatio       float64
+	lowerRatio       float64
+	fixedWait        time.Duration
+	underfilledWait  time.Duration
+	flushOnEmpty     bool // WithFlushOnEmpty: flush instead of waiting once the queue is empty
+	manualStart      bool // WithManualStart: workers are launched by Start, not the constructor
+	numWorkers       int
+	adaptiveMin      time.Duration // Adaptive wait bounds; zero when adaptive wait is off
+	adaptiveMax      time.Duration
+	maxBatchesPerSec float64
+	errorHandler     any                            // WithErrorHandler's func([]T, error), checked at construction
+	gracePeriod      time.Duration                  // Delay after Shutdown before ctx is cancelled
+	maxAttempts      int                            // Attempts per batch for error-returning workers; 0 or 1 = no retry
+	retryBackoff     time.Duration                  // Delay between attempts
+	retryExponential bool                           // WithBatchRetry: double the delay, abort on Shutdown
+	parentCtx        context.Context                // WithContext; its cancellation triggers Shutdown
+	sizeObserver     func(size int)                 // Called with the size of every batch before the worker
+	batchObserver    func(size int, trigger string) // Like sizeObserver, also told why the batch was flushed
+	highWait         time.Duration                  // Time to gather more high-priority tasks
+	highStreakLimit  int                            // Max consecutive high-priority batches; 0 = unlimited
+	drainTimeout     time.Duration                  // WithDrainTimeout; 0 = Shutdown waits indefinitely
+	partitioner      any                            // WithPartitioner's func(T) string or WithIntPartitioner's func(T) int, checked at construction
+	finalHandler     any                            // WithFinalBatchHandler's func([]T), checked at construction
+	queueSize        int                            // Task queue capacity (per partition); 0 = derived from maxSize
+	coalescer        any                            // WithCoalesce's func(T) string, checked at construction
+	memoryLimit      int                            // WithMemoryLimit's budget in bytes; 0 = unlimited
+	memorySizer      any                            // WithMemoryLimit's func(T) int, checked at construction
+
+	// WithTracer's function, wrapped around each worker call
+	tracer func(ctx context.Context, batchSize int) (context.Context, func())
+}
+
+// defaultConfig returns the settings used when no option overrides them.
+func defaultConfig() config {
+	return config{
+		maxSize:         1000,
+		upperRatio:      0.5,
+		lowerRatio:      0.1,
+		fixedWait:       5 * time.Millisecond,
+		underfilledWait: 20 * time.Millisecond,
+		numWorkers:      1,
+		gracePeriod:     5 * time.Second,
+		highWait:        time.Millisecond,
+	}
+}
+
 // BatchProcessor is a generic batch processor for asynchronous task processing.
 type BatchProcessor[T any] struct {
-	maxSize         int
-	upperRatio      float64
-	lowerRatio      float64
-	fixedWait       time.Duration
-	underfilledWait time.Duration
-	numWorkers      int
-	worker          func([]T)
-	tasks           chan T
-	closed          bool
-	stop            chan struct{}
-	wg              sync.WaitGroup
-	closeOnce       sync.Once
+	config                                     // Settings from the options; numWorkers changes with ScaleWorkers
+	limits         atomic.Pointer[batchLimits] // Current maxSize and ratios, replaced by the Set* methods
+	limitsMu       sync.Mutex                  // Serializes the Set* methods
+	limiter        *rateLimiter
+	worker         func([]T)
+	ctxWorker      func(context.Context, []T) error // Set by NewBatchProcessorCtx instead of worker
+	onBatchError   func([]T, error)                 // Typed errorHandler; nil logs the errors
+	partitionOf    func(T) int                      // Partition index from the partitioner; nil = workers share tasks
+	partitions     []*workerHandle[T]               // Workers by partition index when partitionOf is set
+	onFinalBatch   func([]T)                        // Typed finalHandler; nil = shutdown batches go to the worker
+	coalesceKey    func(T) string                   // Typed coalescer; nil = no coalescing
+	sizeOf         func(T) int                      // Typed memorySizer; nil = no memory limit
+	ctx            context.Context                  // Lifetime context passed to ctxWorker
+	cancel         context.CancelFunc
+	tasks          chan item[T]
+	highTasks      chan T          // High-priority tasks, drained before tasks
+	batches        chan handoff[T] // Hand-off from batch formation to processing
+	closed         bool
+	started        bool          // Worker goroutines launched, by the constructor or Start
+	sendMu         sync.RWMutex  // Held for reading while sending to the queues, for writing while closing them
+	yield          chan struct{} // Closed by DumpState to make blocked AddWait calls release sendMu; guarded by sendMu
+	dumpMu         sync.Mutex    // Serializes DumpState
+	stop           chan struct{}
+	wg             sync.WaitGroup           // Batch formation goroutines
+	processWG      sync.WaitGroup           // Processing goroutines
+	scaleMu        sync.Mutex               // Guards numWorkers, workers, nextWorkerID, closed and started
+	workers        map[int]*workerHandle[T] // Running workers by id
+	nextWorkerID   int
+	busyMu         sync.Mutex
+	busy           map[int][]T   // Worker id (-1: final batch handler) -> batch inside the worker function
+	done           chan struct{} // Closed when Shutdown completes
+	abandon        chan struct{} // Closed when ShutdownCtx or ShutdownWithin gives up; ends rate limit waits
+	abandonOnce    sync.Once
+	batchesFlushed atomic.Int64 // Batches handed to processing
+	tasksFlushed   atomic.Int64 // Tasks in those batches
+	tasksProcessed atomic.Int64 // Tasks whose worker function call has returned
+	tasksAdded     atomic.Int64 // Tasks accepted by Add and its variants
+	tasksDropped   atomic.Int64 // Tasks rejected because a queue was full
+	tasksCoalesced atomic.Int64 // Tasks replaced in their batch by a later task with the same key
+	memoryUsed     atomic.Int64 // Estimated bytes of accepted tasks not yet processed (WithMemoryLimit)
+	underfilled    atomic.Int64 // Batches flushed below LowerThreshold when underfilledWait expired
+	closeOnce      sync.Once
+}
+
+// Stats is a point-in-time snapshot of a BatchProcessor, for monitoring.
+// The fields are read independently, so they may be slightly inconsistent
+// with each other while tasks are being added.
+type Stats struct {
+	QueuedTasks         int     // Tasks (and flush markers) waiting in the queues, not yet in a batch
+	Capacity            int     // Capacity of the normal task queue (TasksCap)
+	InFlightBatches     int     // Batches currently inside the worker function
+	TotalTasksAdded     int64   // Tasks accepted by Add, AddWait and AddPriority
+	TotalTasksDropped   int64   // Tasks rejected with a "channel is full" or "memory limit" error
+	TotalBatchesFlushed int64   // Batches handed to processing since creation
+	TotalTasksProcessed int64   // Tasks in batches the worker function has returned from
+	TotalTasksCoalesced int64   // Tasks replaced by a later task with the same WithCoalesce key
+	AvgBatchSize        float64 // Mean size of the flushed batches, 0 before the first
+	UnderfilledFlushes  int64   // Batches flushed below LowerThreshold after underfilledWait
+	CurrentWorkers      int     // Current number of workers (NumWorkers)
+	MemoryUsage         int64   // Estimated bytes of unprocessed tasks (MemoryUsage); 0 without WithMemoryLimit
+}
+
+// batchLimits is a consistent set of the batch size settings that can change
+// at runtime.
+type batchLimits struct {
+	maxSize    int
+	upperRatio float64
+	lowerRatio float64
+}
+
+// workerHandle holds the channels of a running worker.
+type workerHandle[T any] struct {
+	quit    chan struct{}      // Closed to retire the worker
+	flush   chan chan struct{} // Flush requests; the worker closes the reply once its batch is handed off
+	tasks   chan item[T]       // Queue the worker takes tasks from: shared, or its own partition
+	batches chan handoff[T]    // Hand-off to processing: shared, or its own processing goroutine
+	keys    map[string]int     // WithCoalesce: position of each key in the batch being formed
+
+	// WithAdaptiveWait state; lastArrival and gapEWMA are used by the batch
+	// formation goroutine only, wait is also read by EffectiveWait
+	lastArrival time.Time
+	gapEWMA     float64 // Moving average of the time between tasks, in nanoseconds
+	wait        atomic.Int64
+}
+
+// handoff is a batch passed from batch formation to processing, with the
+// trigger that flushed it.
+type handoff[T any] struct {
+	tasks   []T
+	trigger string
+}
+
+// item is an entry of the task queue: either a task or a flush marker.
+type item[T any] struct {
+	task  T
+	flush bool
 }
 
 // Option configures BatchProcessor.
-type Option func(*BatchProcessor[any])
+type Option func(*config)
 
 // WithMaxSize sets the maximum batch size.
 func WithMaxSize(size int) Option {
-	return func(bp *BatchProcessor[any]) {
+	return func(c *config) {
 		if size > 0 {
-			bp.maxSize = size
+			c.maxSize = size
 		}
 	}
 }
 
 // WithUpperRatio sets the upper ratio for continuous processing.
 func WithUpperRatio(ratio float64) Option {
-	return func(bp *BatchProcessor[any]) {
+	return func(c *config) {
 		if ratio > 0 && ratio <= 1 {
-			bp.upperRatio = ratio
+			c.upperRatio = ratio
 		}
 	}
 }
 
 // WithLowerRatio sets the lower ratio for underfilled waiting.
 func WithLowerRatio(ratio float64) Option {
-	return func(bp *BatchProcessor[any]) {
+	return func(c *config) {
 		if ratio > 0 && ratio <= 1 {
-			bp.lowerRatio = ratio
+			c.lowerRatio = ratio
 		}
 	}
 }
 
 // WithFixedWait sets the fixed wait time for initial task check.
 func WithFixedWait(duration time.Duration) Option {
-	return func(bp *BatchProcessor[any]) {
+	return func(c *config) {
 		if duration > 0 {
-			bp.fixedWait = duration
+			c.fixedWait = duration
 		}
 	}
 }
 
 // WithUnderfilledWait sets the wait time for underfilled batches.
 func WithUnderfilledWait(duration time.Duration) Option {
-	return func(bp *BatchProcessor[any]) {
+	return func(c *config) {
 		if duration > 0 {
-			bp.underfilledWait = duration
+			c.underfilledWait = duration
 		}
 	}
 }
 
-// WithNumWorkers sets the number of parallel workers (max 8).
-func WithNumWorkers(n int) Option {
-	return func(bp *BatchProcessor[any]) {
+// WithFlushOnEmpty, when enabled, makes a worker flush its batch as soon as
+// the task queue is empty instead of waiting fixedWait for more tasks, for
+// latency-sensitive workloads: a lone task is processed at once rather than
+// after the wait. Under sustained load the queue is rarely empty, so batches
+// still fill up to UpperThreshold; while the worker function is busy, one
+// flushed batch waits for it and later tasks accumulate in the queue, to be
+// batched together once it is free. Batches flushed this
+// way are reported to WithBatchObserver as TriggerQueueEmpty. With several
+// workers the queue is shared, so batches tend to be smaller.
+func WithFlushOnEmpty(enabled bool) Option {
+	return func(c *config) {
+		c.flushOnEmpty = enabled
+	}
+}
+
+// WithAdaptiveWait replaces the fixed wait with one that adapts to the task
+// arrival rate, between min and max. Each worker keeps an exponential moving
+// average of the time between the tasks it receives and waits twice that long,
+// clamped to [min, max]: the wait shrinks toward min during bursts and grows
+// toward max when tasks are sparse or stop arriving. The batch fill ratio is
+// not used: full batches of slowly arriving tasks still get a long wait, as
+// the arrival rate alone decides. max must be below the underfilled wait.
+// Without this option the fixed wait is used.
+func WithAdaptiveWait(min, max time.Duration) Option {
+	return func(c *config) {
+		c.adaptiveMin = min
+		c.adaptiveMax = max
+	}
+}
+
+// WithHighPriorityWait sets how long a worker gathers further high-priority
+// tasks after the first one before flushing them (default 1ms).
+func WithHighPriorityWait(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.highWait = d
+		}
+	}
+}
+
+// WithPriorityFairness bounds starvation of normal tasks: after n consecutive
+// high-priority batches, a worker takes at least one normal task (or waits for
+// its timer) before serving the high-priority queue again. The default 0 means
+// strict priority, under which a steady stream of high-priority tasks can
+// starve normal ones indefinitely.
+func WithPriorityFairness(n int) Option {
+	return func(c *config) {
 		if n > 0 {
-			// Ensure assignment to correct field
-			bp.numWorkers = n
+			c.highStreakLimit = n
+		}
+	}
+}
+
+// WithNumWorkers sets the number of parallel workers. The valid range is 1-8;
+// any other value makes NewBatchProcessor return an error.
+func WithNumWorkers(n int) Option {
+	return func(c *config) {
+		c.numWorkers = n
+	}
+}
+
+// WithQueueSize sets the capacity of the task queue, which by default is
+// maxSize * numWorkers * 2 (with a partitioner, of each partition's queue,
+// by default 2*maxSize). A larger queue absorbs longer bursts before Add
+// fails, a smaller one bounds the memory held by queued tasks. n must be at
+// least the initial maxSize, or the constructor returns an error. The
+// capacity is fixed for the processor's lifetime (ScaleWorkers and
+// SetMaxSize do not change it).
+func WithQueueSize(n int) Option {
+	return func(c *config) {
+		c.queueSize = n
+	}
+}
+
+// WithMaxBatchesPerSecond caps the rate at which batches are handed to the
+// worker, across all workers. Batches over the cap are delayed, not dropped,
+// unless ShutdownCtx or ShutdownWithin gives up first: batches still waiting
+// then go to the WithErrorHandler function instead of the worker.
+func WithMaxBatchesPerSecond(r float64) Option {
+	return func(c *config) {
+		if r > 0 {
+			c.maxBatchesPerSec = r
+		}
+	}
+}
+
+// WithTracer registers fn to wrap every call of the worker function in a
+// tracing span: fn is called on the processing goroutine right before the
+// worker with the processor's context and the batch size, and the function it
+// returns is called once the batch is done, after retries and error handling,
+// so the span covers the whole processing time. The context fn returns (e.g.
+// one carrying the new span) is passed to NewBatchProcessorCtx, E and Ack
+// workers, making their own spans children of the batch span. The processor's
+// context carries the values of the WithContext context, so a span found
+// there becomes the parent. Batches of WithFinalBatchHandler are not traced.
+// A nil fn is ignored.
+func WithTracer(fn func(ctx context.Context, batchSize int) (context.Context, func())) Option {
+	return func(c *config) {
+		c.tracer = fn
+	}
+}
+
+// WithBatchSizeObserver registers fn to be called with the size of every
+// batch, from the processing goroutine right before the batch is passed to the
+// worker function. It can feed a histogram of batch sizes for tuning ratios and
+// waits. fn must be safe for concurrent use when there are several workers, and
+// should be fast since it delays the worker. A nil fn is ignored.
+func WithBatchSizeObserver(fn func(size int)) Option {
+	return func(c *config) {
+		c.sizeObserver = fn
+	}
+}
+
+// Triggers passed to a WithBatchObserver function, telling why a batch was
+// flushed.
+const (
+	TriggerFull               = "full"                // Reached maxSize
+	TriggerUpperRatio         = "upper-ratio"         // Reached UpperThreshold, below maxSize
+	TriggerLowerThreshold     = "lower-threshold"     // At least LowerThreshold tasks when the initial wait expired
+	TriggerUnderfilledTimeout = "underfilled-timeout" // Below LowerThreshold when underfilledWait expired
+	TriggerShutdown           = "shutdown"            // Flushed by Shutdown, or by a worker retired by ScaleWorkers
+	TriggerFlush              = "flush"               // Ended by a flush marker or a Flush call
+	TriggerPriority           = "priority"            // High-priority batch
+	TriggerQueueEmpty         = "queue-empty"         // Queue empty with WithFlushOnEmpty
+)
+
+// WithBatchObserver is like WithBatchSizeObserver, and also tells fn which
+// path flushed the batch (one of the Trigger constants), so a histogram per
+// trigger shows e.g. whether most batches are cut by the upper ratio or
+// leave underfilled after the timeout. It is called from the processing
+// goroutine right before the worker function, after WithBatchSizeObserver's
+// function when both are set; batches of WithFinalBatchHandler are reported
+// as TriggerShutdown before that handler. fn must be safe for concurrent use
+// when there are several workers. A nil fn is ignored.
+func WithBatchObserver(fn func(size int, trigger string)) Option {
+	return func(c *config) {
+		c.batchObserver = fn
+	}
+}
+
+// WithErrorHandler registers fn to receive each batch whose worker function
+// returned an error, together with the unwrapped error, so it can be logged,
+// dead-lettered or retried. It applies to processors created with
+// NewBatchProcessorE, NewBatchProcessorCtx or NewBatchProcessorAck (which
+// passes only the unacknowledged tasks); NewBatchProcessor ignores it. fn is
+// called from the processing goroutine and must be safe for concurrent use
+// when there are several workers. Without a handler the errors are logged. T
+// must match the processor's task type, or the constructor returns an error.
+func WithErrorHandler[T any](fn func(batch []T, err error)) Option {
+	return func(c *config) {
+		c.errorHandler = fn
+	}
+}
+
+// WithRetry makes a processor created with NewBatchProcessorE,
+// NewBatchProcessorCtx or NewBatchProcessorAck call the worker function up to
+// maxAttempts times in total for a batch that fails (for NewBatchProcessorAck,
+// with the tasks not yet acknowledged), waiting backoff between attempts.
+// Only the last error is reported to the error handler. Retries run on the
+// failing worker's processing goroutine, so other workers carry on meanwhile;
+// the wait is cut short once the worker context is cancelled (see
+// WithGracePeriod). maxAttempts below 1 is ignored.
+func WithRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		if maxAttempts >= 1 {
+			c.maxAttempts = maxAttempts
+		}
+		if backoff >= 0 {
+			c.retryBackoff = backoff
+		}
+		c.retryExponential = false
+	}
+}
+
+// WithBatchRetry is like WithRetry with exponential backoff: the wait before
+// the second attempt is backoff and doubles for each further attempt. Pending
+// retries are abandoned as soon as Shutdown is called, and the batch goes to
+// the error handler with its last error. It replaces an earlier WithRetry and
+// vice versa.
+func WithBatchRetry(maxAttempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		WithRetry(maxAttempts, backoff)(c)
+		c.retryExponential = true
+	}
+}
+
+// WithGracePeriod sets how long after Shutdown starts the context passed to
+// the worker function of a processor created with NewBatchProcessorCtx is
+// cancelled (default 5s). Shutdown still waits for the worker functions to
+// return; a cancelled context only asks them to. A zero d cancels it at once.
+func WithGracePeriod(d time.Duration) Option {
+	return func(c *config) {
+		if d >= 0 {
+			c.gracePeriod = d
+		}
+	}
+}
+
+// WithContext ties the processor to ctx, e.g. the context of an errgroup:
+// once ctx is done, Add, AddWait and the other enqueue methods fail with
+// ErrClosed, and Shutdown is called in the background, draining queued
+// tasks as usual. Shutdown runs once however it is triggered. The context
+// passed to a NewBatchProcessorCtx worker carries the values of ctx but is
+// cancelled only by Shutdown's grace period, so draining is not cut short.
+func WithContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.parentCtx = ctx
+	}
+}
+
+// WithManualStart makes the constructor validate the options and allocate the
+// queues without launching the worker goroutines, which Start does later. It
+// suits dependency-injection setups where the worker function captures
+// resources that are not ready when the processor is built. Tasks added before
+// Start wait in the queue (Add fails with ErrChannelFull once it is full) and
+// Flush fails until then. Shutdown without Start launches the workers to
+// process the tasks added meanwhile, so it never loses them.
+func WithManualStart() Option {
+	return func(c *config) {
+		c.manualStart = true
+	}
+}
+
+// WithDrainTimeout bounds Shutdown: it waits at most d for the workers and the
+// final drain, then returns and logs how many accepted tasks were left
+// unprocessed, like ShutdownCtx with a deadline of d. The shutdown carries on
+// in the background; stuck workers keep running. Zero (the default) waits
+// indefinitely.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *config) {
+		if d >= 0 {
+			c.drainTimeout = d
 		}
 	}
 }
 
+// WithPartitioner routes each task to one worker by hashing key(task), so all
+// tasks with the same key go to the same worker, share its batches and are
+// processed in submission order, also across batches: each worker then has
+// its own queue (of 2*maxSize tasks) and its own processing goroutine. A batch
+// may mix keys of the same partition. T must match the processor's task type,
+// or the constructor returns an error. With a partitioner, ScaleWorkers and
+// high-priority tasks are not supported, and AddFlushMarker flushes every
+// worker. Without one, workers share a single queue.
+func WithPartitioner[T any](key func(task T) string) Option {
+	return func(c *config) {
+		c.partitioner = key
+	}
+}
+
+// WithIntPartitioner is like WithPartitioner for integer keys (e.g. user ids),
+// routing each task to worker key(task) % numWorkers without hashing, so the
+// caller controls the assignment exactly; negative keys are mapped into range.
+// Only one of WithPartitioner and WithIntPartitioner applies, the last given.
+func WithIntPartitioner[T any](key func(task T) int) Option {
+	return func(c *config) {
+		c.partitioner = key
+	}
+}
+
+// WithFinalBatchHandler passes the batches formed at Shutdown from the tasks
+// still waiting in the queues to fn instead of the worker function, so they
+// can be told apart from normal batches (e.g. written synchronously or
+// persisted for the next run). Like every batch they hold at most maxSize
+// tasks. fn is called one batch at a time on the shutdown goroutine, after
+// the worker function has returned from every normal batch; the batch being
+// formed by each worker when Shutdown is called still goes to the worker
+// function. T must match the processor's task type, or the constructor
+// returns an error.
+func WithFinalBatchHandler[T any](fn func(batch []T)) Option {
+	return func(c *config) {
+		c.finalHandler = fn
+	}
+}
+
+// WithCoalesce makes batches latest-wins per key: while a batch is being
+// formed, a task whose key(task) matches a task already in the batch replaces
+// it in place, so the worker function sees each key at most once per batch,
+// with its newest value. Suited to high-frequency updates where only the
+// latest state matters (e.g. sensor readings by sensor id). Coalescing only
+// happens within one batch: tasks that land in different batches, or in the
+// batches of different workers, are not merged (use WithPartitioner with the
+// same key to keep a key on one worker). High-priority batches are not
+// coalesced. Replaced tasks count as settled for WaitForIdle and are reported
+// in Stats.TotalTasksCoalesced. T must match the processor's task type, or
+// the constructor returns an error.
+func WithCoalesce[T any](key func(task T) string) Option {
+	return func(c *config) {
+		c.coalescer = key
+	}
+}
+
+// WithMemoryLimit bounds the memory held by queued tasks: size(task)
+// estimates the bytes of a task, and once the accepted tasks that have not
+// been processed yet add up to bytes, Add and its variants reject further
+// tasks with ErrMemoryLimit instead of queueing them. Tasks count from when
+// they are accepted until the worker function returns from their batch (or
+// they are replaced by WithCoalesce), so adding resumes as batches drain. A
+// single task larger than bytes is still accepted when nothing else is held,
+// so it cannot be locked out forever. AddWait does not wait for memory to be
+// freed; it fails with ErrMemoryLimit like Add. size is called again when the
+// task is released and must return the same value for the same task.
+// MemoryUsage reports the current estimate. bytes must be positive and T must
+// match the processor's task type, or the constructor returns an error.
+func WithMemoryLimit[T any](bytes int, size func(task T) int) Option {
+	return func(c *config) {
+		c.memoryLimit = bytes
+		c.memorySizer = size
+	}
+}
+
 // NewBatchProcessor creates and starts a batch processor with the given options.
 func NewBatchProcessor[T any](
 	worker func([]T),
 	opts ...Option,
 ) (*BatchProcessor[T], error) {
-	bp := &BatchProcessor[T]{
-		worker:          worker,
-		maxSize:         1000,
-		upperRatio:      0.5,
-		lowerRatio:      0.1,
-		fixedWait:       5 * time.Millisecond,
-		underfilledWait: 20 * time.Millisecond,
-		numWorkers:      1,
-		stop:            make(chan struct{}),
+	return newBatchProcessor(worker, nil, opts)
+}
+
+// NewBatchProcessorCtx is like NewBatchProcessor for a worker function that
+// takes a context and returns an error. The context lives as long as the
+// processor and is cancelled once the grace period (WithGracePeriod) after
+// Shutdown has passed, or when Shutdown completes, whichever is first. Failing
+// batches go to the WithErrorHandler callback with their errors.
+func NewBatchProcessorCtx[T any](
+	worker func(context.Context, []T) error,
+	opts ...Option,
+) (*BatchProcessor[T], error) {
+	if worker == nil {
+		return nil, errors.E("worker function is required")
 	}
+	return newBatchProcessor(nil, worker, opts)
+}
+
+// NewBatchProcessorE is like NewBatchProcessor for a worker function that
+// returns an error. A failing batch goes to the WithErrorHandler callback
+// with its error, or is logged without one.
+func NewBatchProcessorE[T any](
+	worker func([]T) error,
+	opts ...Option,
+) (*BatchProcessor[T], error) {
+	if worker == nil {
+		return nil, errors.E("worker function is required")
+	}
+	return newBatchProcessor(nil, func(_ context.Context, batch []T) error {
+		return worker(batch)
+	}, opts)
+}
+
+// NewBatchProcessorAck is like NewBatchProcessor for a worker function that
+// acknowledges tasks individually, for at-least-once processing: it returns
+// one flag per task of batch, true for a task it has processed. Tasks not
+// acknowledged (false, or beyond the end of the returned slice) are passed
+// to the worker function again, on their own, as long as WithRetry or
+// WithBatchRetry allows (maxAttempts calls in total per task, with the
+// configured backoff); without either they are not retried. Tasks still
+// unacknowledged after the last attempt go to the WithErrorHandler callback,
+// or are logged.
+//
+// Unacknowledged tasks stay in memory, holding the processing goroutine, until
+// they are acknowledged or the attempts run out; under persistent failures
+// that is up to maxSize tasks per worker, each held for the whole backoff.
+// Delivery is at-least-once within the process only: tasks queued or awaiting
+// a retry are lost if the process crashes, so persist what must survive one
+// (e.g. from the error handler or WithFinalBatchHandler).
+func NewBatchProcessorAck[T any](
+	worker func(batch []T) (processed []bool),
+	opts ...Option,
+) (*BatchProcessor[T], error) {
+	if worker == nil {
+		return nil, errors.E("worker function is required")
+	}
+	return newBatchProcessor(nil, func(_ context.Context, batch []T) error {
+		acks := worker(batch)
+		var unacked []T
+		for i, task := range batch {
+			if i >= len(acks) || !acks[i] {
+				unacked = append(unacked, task)
+			}
+		}
+		if len(unacked) == 0 {
+			return nil
+		}
+		return &unackedError[T]{tasks: unacked, total: len(batch)}
+	}, opts)
+}
 
-	// Type conversion to adapt Option
-	anyBP := (*BatchProcessor[any])(unsafe.Pointer(bp))
+// unackedError reports the tasks of a batch that a NewBatchProcessorAck
+// worker did not acknowledge; callWorker retries only those.
+type unackedError[T any] struct {
+	tasks []T
+	total int
+}
+
+func (e *unackedError[T]) Error() string {
+	return fmt.Sprintf("%d of %d tasks not acknowledged", len(e.tasks), e.total)
+}
+
+// newBatchProcessor creates a batch processor calling either worker or
+// ctxWorker, and starts it unless WithManualStart is given.
+func newBatchProcessor[T any](
+	worker func([]T),
+	ctxWorker func(context.Context, []T) error,
+	opts []Option,
+) (*BatchProcessor[T], error) {
+	cfg := defaultConfig()
 	for _, opt := range opts {
-		opt(anyBP)
+		opt(&cfg)
 	}
 
-	// Keep original validation logic
-	if bp.worker == nil {
+	if worker == nil && ctxWorker == nil {
 		return nil, errors.E("worker function is required")
 	}
+	bp := &BatchProcessor[T]{
+		config:    cfg,
+		worker:    worker,
+		ctxWorker: ctxWorker,
+		stop:      make(chan struct{}),
+		busy:      make(map[int][]T),
+		yield:     make(chan struct{}),
+		workers:   make(map[int]*workerHandle[T]),
+		done:      make(chan struct{}),
+		abandon:   make(chan struct{}),
+	}
+	if bp.errorHandler != nil {
+		fn, ok := bp.errorHandler.(func([]T, error))
+		if !ok {
+			return nil, errors.E("batch error handler does not match the task type",
+				"handler-type", fmt.Sprintf("%T", bp.errorHandler))
+		}
+		bp.onBatchError = fn
+	}
+	n := bp.numWorkers // Partitioned processors cannot scale
+	switch fn := bp.partitioner.(type) {
+	case nil:
+	case func(T) string:
+		bp.partitionOf = func(task T) int {
+			h := fnv.New32a()
+			h.Write([]byte(fn(task)))
+			return int(h.Sum32() % uint32(n))
+		}
+	case func(T) int:
+		bp.partitionOf = func(task T) int {
+			return (fn(task)%n + n) % n
+		}
+	default:
+		return nil, errors.E("partitioner does not match the task type",
+			"partitioner-type", fmt.Sprintf("%T", bp.partitioner))
+	}
+	if bp.finalHandler != nil {
+		fn, ok := bp.finalHandler.(func([]T))
+		if !ok {
+			return nil, errors.E("final batch handler does not match the task type",
+				"handler-type", fmt.Sprintf("%T", bp.finalHandler))
+		}
+		bp.onFinalBatch = fn
+	}
+	if bp.coalescer != nil {
+		fn, ok := bp.coalescer.(func(T) string)
+		if !ok {
+			return nil, errors.E("coalesce key function does not match the task type",
+				"coalescer-type", fmt.Sprintf("%T", bp.coalescer))
+		}
+		bp.coalesceKey = fn
+	}
+	if bp.memorySizer != nil {
+		fn, ok := bp.memorySizer.(func(T) int)
+		if !ok || fn == nil {
+			return nil, errors.E("memory size function does not match the task type",
+				"sizer-type", fmt.Sprintf("%T", bp.memorySizer))
+		}
+		if bp.memoryLimit <= 0 {
+			return nil, errors.E("memory limit must be positive", "memoryLimit", bp.memoryLimit)
+		}
+		bp.sizeOf = fn
+	}
 	if bp.numWorkers < 1 || bp.numWorkers > 8 {
-		return nil, errors.E("numWorkers must be between 1 and 8")
+		return nil, errors.E("numWorkers must be between 1 and 8", "numWorkers", bp.numWorkers)
+	}
+	if bp.queueSize != 0 && bp.queueSize < bp.maxSize {
+		return nil, errors.E("queueSize must be at least maxSize",
+			"queueSize", bp.queueSize, "maxSize", bp.maxSize)
 	}
 	if bp.upperRatio <= 0 || bp.upperRatio > 1 {
 		return nil, errors.E("upperRatio must be between 0 and 1")
@@ -124,19 +724,48 @@ func NewBatchProcessor[T any](
 	if bp.fixedWait >= bp.underfilledWait {
 		return nil, errors.E("fixedWait must be less than underfilledWait")
 	}
+	if bp.adaptiveMin != 0 || bp.adaptiveMax != 0 {
+		if bp.adaptiveMin <= 0 || bp.adaptiveMin > bp.adaptiveMax {
+			return nil, errors.E("adaptive wait requires 0 < min <= max",
+				"min", bp.adaptiveMin, "max", bp.adaptiveMax)
+		}
+		if bp.adaptiveMax >= bp.underfilledWait {
+			return nil, errors.E("adaptive wait max must be less than underfilledWait",
+				"max", bp.adaptiveMax, "underfilledWait", bp.underfilledWait)
+		}
+	}
+
+	bp.limits.Store(&batchLimits{maxSize: bp.maxSize, upperRatio: bp.upperRatio, lowerRatio: bp.lowerRatio})
+
+	if bp.maxBatchesPerSec > 0 {
+		bp.limiter = newRateLimiter(bp.maxBatchesPerSec)
+	}
 
 	bufferSize := bp.maxSize * bp.numWorkers * 2
 	if bufferSize < bp.maxSize*2 {
 		bufferSize = bp.maxSize * 2
 	}
-	bp.tasks = make(chan T, bufferSize)
+	if bp.queueSize > 0 {
+		bufferSize = bp.queueSize
+	}
+	if bp.partitionOf != nil {
+		bufferSize = 0 // Each partition has its own queue
+	}
+	bp.tasks = make(chan item[T], bufferSize)
+	bp.highTasks = make(chan T, bp.maxSize*2)
+	bp.batches = make(chan handoff[T])
+	base := context.Background()
+	if bp.parentCtx != nil {
+		base = context.WithoutCancel(bp.parentCtx)
+	}
+	bp.ctx, bp.cancel = context.WithCancel(base)
 
-	bp.wg.Add(bp.numWorkers)
+	bp.started = !bp.manualStart
 	for i := 0; i < bp.numWorkers; i++ {
-		go func() {
-			defer bp.wg.Done()
-			bp.run()
-		}()
+		bp.startWorker()
+	}
+	if bp.parentCtx != nil {
+		go bp.shutdownOnCancel()
 	}
 
 	return bp, nil
@@ -144,141 +773,1341 @@ func NewBatchProcessor[T any](
 
 // Add adds a task to the processor.
 func (bp *BatchProcessor[T]) Add(task T) error {
-	if bp.closed {
-		return errors.E("batch processor is closed")
+	return bp.enqueue(item[T]{task: task})
+}
+
+// AddPriority adds a task to the normal queue, or with high set to a separate
+// high-priority queue. Workers check the high-priority queue before the normal
+// one and flush high-priority tasks in their own batches after a short wait
+// (WithHighPriorityWait), without waiting for a normal batch to fill. A normal
+// batch that is already being handed to the worker function is not preempted.
+// See WithPriorityFairness for the starvation risk to normal tasks.
+func (bp *BatchProcessor[T]) AddPriority(task T, high bool) error {
+	if !high {
+		return bp.Add(task)
+	}
+	if bp.partitionOf != nil {
+		return errors.E("high-priority tasks are not supported with a partitioner")
+	}
+	bp.sendMu.RLock()
+	defer bp.sendMu.RUnlock()
+	if bp.isStopped() {
+		return ErrClosed
+	}
+	size, err := bp.reserveMemory(task)
+	if err != nil {
+		bp.tasksDropped.Add(1)
+		return err
 	}
 	select {
-	case bp.tasks <- task:
+	case bp.highTasks <- task:
+		bp.tasksAdded.Add(1)
 		return nil
 	default:
-		return errors.E("task channel is full")
+		bp.memoryUsed.Add(-size)
+		bp.tasksDropped.Add(1)
+		return errors.WrapE(ErrChannelFull, "high-priority")
 	}
 }
 
-// Shutdown stops the processor and processes remaining tasks.
-func (bp *BatchProcessor[T]) Shutdown() {
-	bp.closeOnce.Do(func() {
-		bp.closed = true
-		close(bp.stop)
-		bp.wg.Wait() // Wait for all workers to stop
+// AddFlushMarker enqueues an in-band flush marker. The worker goroutine that
+// receives it hands its current batch to the worker function immediately; the
+// marker itself is never passed to the worker function. An empty batch is not
+// flushed.
+//
+// Ordering: with a single worker (the default), every task added before the
+// marker is delivered in a batch that ends no later than the marker, and no
+// task added after it shares that batch. With several workers, tasks are
+// spread across worker goroutines and the marker only flushes the batch of
+// the goroutine that receives it.
+func (bp *BatchProcessor[T]) AddFlushMarker() error {
+	return bp.enqueue(item[T]{flush: true})
+}
 
-		// Process remaining tasks separately, not involving WaitGroup
-		close(bp.tasks)
-		remaining := make([]T, 0, len(bp.tasks))
-		for task := range bp.tasks {
-			remaining = append(remaining, task)
+// AddWait adds a task like Add, but when the task queue is full it blocks
+// until there is room, ctx is done, or Shutdown is called, returning ctx.Err()
+// or ErrClosed respectively. It does not wait for WithMemoryLimit memory.
+func (bp *BatchProcessor[T]) AddWait(ctx context.Context, task T) error {
+	if bp.isStopped() {
+		return ErrClosed
+	}
+	size, err := bp.reserveMemory(task)
+	if err != nil {
+		bp.tasksDropped.Add(1)
+		return err
+	}
+	for {
+		bp.sendMu.RLock()
+		if bp.isStopped() {
+			bp.sendMu.RUnlock()
+			bp.memoryUsed.Add(-size)
+			return ErrClosed
 		}
-		if len(remaining) > 0 {
-			bp.worker(remaining)
+		select {
+		case bp.queueFor(task) <- item[T]{task: task}:
+			bp.sendMu.RUnlock()
+			bp.tasksAdded.Add(1)
+			return nil
+		case <-ctx.Done():
+			bp.sendMu.RUnlock()
+			bp.memoryUsed.Add(-size)
+			return ctx.Err()
+		case <-bp.stop:
+			bp.sendMu.RUnlock()
+			bp.memoryUsed.Add(-size)
+			return ErrClosed
+		case <-bp.yield:
+			// DumpState needs sendMu; retry once it is done
+			bp.sendMu.RUnlock()
 		}
-	})
+	}
 }
 
-func (bp *BatchProcessor[T]) TasksCap() int {
-	return cap(bp.tasks)
+// AddBatch adds tasks in order without blocking until one does not fit,
+// returning how many were accepted: tasks[:accepted] are queued and the rest
+// are not, so they can be resubmitted. If a queue fills up it returns
+// ErrChannelFull (wrapped, with the counts), if the WithMemoryLimit budget is
+// used up ErrMemoryLimit (likewise), or ErrClosed after Shutdown. With a
+// partitioner it stops at the first task whose partition queue is full.
+func (bp *BatchProcessor[T]) AddBatch(tasks []T) (accepted int, err error) {
+	bp.sendMu.RLock()
+	defer bp.sendMu.RUnlock()
+	if bp.isStopped() {
+		return 0, ErrClosed
+	}
+	return bp.sendBatch(tasks)
 }
 
-// run is the internal worker loop for processing batches.
-func (bp *BatchProcessor[T]) run() {
-	batch := make([]T, 0, bp.maxSize)
-	var timer *time.Timer
-	lowerThreshold := int(math.Max(1, math.Floor(float64(bp.maxSize)*bp.lowerRatio)))
-
-	defer func() {
-		if timer != nil {
-			timer.Stop()
+// TryAddBatch adds all of tasks or none: it first checks that the queues have
+// room for every task, returning ErrChannelFull with nothing queued if not
+// (ErrMemoryLimit if they do not fit in the WithMemoryLimit budget), and then
+// adds them like AddBatch. It is best-effort: the room can change
+// between the check and the enqueue, since other goroutines may add tasks
+// meanwhile (workers only ever free room). If they take it, only
+// tasks[:accepted] are queued and ErrChannelFull is returned as from
+// AddBatch, so always check accepted. With a single producer the check holds.
+func (bp *BatchProcessor[T]) TryAddBatch(tasks []T) (accepted int, err error) {
+	bp.sendMu.RLock()
+	defer bp.sendMu.RUnlock()
+	if bp.isStopped() {
+		return 0, ErrClosed
+	}
+	need := make(map[chan item[T]]int)
+	for _, task := range tasks {
+		need[bp.queueFor(task)]++
+	}
+	for q, n := range need {
+		if free := cap(q) - len(q); n > free {
+			bp.tasksDropped.Add(int64(len(tasks)))
+			return 0, errors.WrapE(ErrChannelFull, "", "needed", n, "free", free)
 		}
-	}()
+	}
+	if bp.sizeOf != nil {
+		var size int64
+		for _, task := range tasks {
+			size += int64(bp.sizeOf(task))
+		}
+		if used := bp.memoryUsed.Load(); used > 0 && used+size > int64(bp.memoryLimit) {
+			bp.tasksDropped.Add(int64(len(tasks)))
+			return 0, errors.WrapE(ErrMemoryLimit, "", "used", used, "size", size, "limit", bp.memoryLimit)
+		}
+	}
+	return bp.sendBatch(tasks)
+}
 
-	for {
-		// First check for stop signal
+// sendBatch sends tasks in order without blocking until a queue is full. The
+// caller holds sendMu for reading and has checked that the processor is
+// running.
+func (bp *BatchProcessor[T]) sendBatch(tasks []T) (accepted int, err error) {
+	for _, task := range tasks {
+		size, err := bp.reserveMemory(task)
+		if err != nil {
+			bp.tasksDropped.Add(int64(len(tasks) - accepted))
+			return accepted, errors.WrapE(err, "", "accepted", accepted, "total", len(tasks))
+		}
 		select {
-		case <-bp.stop:
-			bp.flushBatch(batch)
-			return
+		case bp.queueFor(task) <- item[T]{task: task}:
+			bp.tasksAdded.Add(1)
+			accepted++
 		default:
+			bp.memoryUsed.Add(-size)
+			bp.tasksDropped.Add(int64(len(tasks) - accepted))
+			return accepted, errors.WrapE(ErrChannelFull, "", "accepted", accepted, "total", len(tasks))
 		}
+	}
+	return accepted, nil
+}
 
-		// Check thresholds first
-		if shouldFlush := len(batch) >= bp.maxSize || len(batch) >= int(float64(bp.maxSize)*bp.upperRatio); shouldFlush {
-			bp.flushBatch(batch)
-			batch, timer = bp.resetBatchAndTimer(batch, timer)
-			continue
-		}
-
-		// Initialize timer
-		timer = bp.initTimer(timer)
-
-		select {
-		case task, ok := <-bp.tasks:
-			if !ok {
-				bp.flushBatch(batch)
-				return
+// enqueue puts it on the task queue without blocking.
+func (bp *BatchProcessor[T]) enqueue(it item[T]) error {
+	bp.sendMu.RLock()
+	defer bp.sendMu.RUnlock()
+	if bp.isStopped() {
+		return ErrClosed
+	}
+	if it.flush && bp.partitionOf != nil {
+		full := 0
+		for _, w := range bp.partitions {
+			select {
+			case w.tasks <- it:
+			default:
+				full++
 			}
-			batch = append(batch, task)
-
-		case <-timer.C:
-			batch, timer = bp.handleTimerExpired(batch, timer, lowerThreshold)
 		}
+		if full > 0 {
+			return errors.WrapE(ErrChannelFull, "", "full-partitions", full)
+		}
+		return nil
+	}
+	var size int64
+	if !it.flush {
+		var err error
+		if size, err = bp.reserveMemory(it.task); err != nil {
+			bp.tasksDropped.Add(1)
+			return err
+		}
+	}
+	select {
+	case bp.queueFor(it.task) <- it:
+		if !it.flush {
+			bp.tasksAdded.Add(1)
+		}
+		return nil
+	default:
+		if !it.flush {
+			bp.memoryUsed.Add(-size)
+			bp.tasksDropped.Add(1)
+		}
+		return ErrChannelFull
 	}
 }
 
-// Helper function 1: Process batch submission
-func (bp *BatchProcessor[T]) flushBatch(batch []T) {
-	if len(batch) > 0 {
-		bp.worker(batch)
+// queueFor returns the queue task goes to: its partition's queue with
+// WithPartitioner or WithIntPartitioner, the shared queue otherwise.
+func (bp *BatchProcessor[T]) queueFor(task T) chan item[T] {
+	if bp.partitionOf == nil {
+		return bp.tasks
 	}
+	return bp.partitions[bp.partitionOf(task)].tasks
 }
 
-// Helper function 2: Reset batch and timer
-func (bp *BatchProcessor[T]) resetBatchAndTimer(batch []T, timer *time.Timer) ([]T, *time.Timer) {
-	if timer != nil {
-		timer.Stop()
+// isStopped reports whether Shutdown has been called or the WithContext
+// context is done.
+func (bp *BatchProcessor[T]) isStopped() bool {
+	if bp.parentCtx != nil && bp.parentCtx.Err() != nil {
+		return true
+	}
+	select {
+	case <-bp.stop:
+		return true
+	default:
+		return false
 	}
-	return make([]T, 0, bp.maxSize), nil
 }
 
-// Helper function 3: Initialize timer
-func (bp *BatchProcessor[T]) initTimer(timer *time.Timer) *time.Timer {
-	if timer == nil {
-		return time.NewTimer(bp.fixedWait)
+// Start launches the worker goroutines of a processor created with
+// WithManualStart. It returns an error if the processor has already been
+// started (always the case without WithManualStart), or ErrClosed after
+// Shutdown.
+func (bp *BatchProcessor[T]) Start() error {
+	bp.scaleMu.Lock()
+	defer bp.scaleMu.Unlock()
+	if bp.closed {
+		return ErrClosed
 	}
-	timer.Reset(bp.fixedWait)
-	return timer
+	if bp.started {
+		return errors.E("batch processor already started")
+	}
+	bp.launchAll()
+	return nil
 }
 
-// Helper function 4: Handle timer expiration
-func (bp *BatchProcessor[T]) handleTimerExpired(batch []T, timer *time.Timer, lowerThreshold int) ([]T, *time.Timer) {
-	if len(batch) >= lowerThreshold {
-		bp.flushBatch(batch)
-		return bp.resetBatchAndTimer(batch, timer)
+// launchAll launches the goroutines of every worker and marks the processor
+// started. The caller holds scaleMu.
+func (bp *BatchProcessor[T]) launchAll() {
+	bp.started = true
+	for id, w := range bp.workers {
+		bp.launch(id, w)
 	}
+}
 
-	// Start secondary waiting
-	timer.Reset(bp.underfilledWait)
+// shutdownOnCancel shuts the processor down when the WithContext context is
+// done, unless Shutdown completes first.
+func (bp *BatchProcessor[T]) shutdownOnCancel() {
 	select {
-	case task, ok := <-bp.tasks:
-		if !ok {
-			bp.flushBatch(batch)
-			return batch, timer
-		}
-		return append(batch, task), timer
-
-	case <-timer.C:
-		bp.flushBatch(batch)
-		return bp.resetBatchAndTimer(batch, timer)
-
-	case <-bp.stop:
-		bp.flushBatch(batch)
-		return batch, timer
+	case <-bp.parentCtx.Done():
+		bp.Shutdown()
+	case <-bp.done:
+	}
+}
+
+// Shutdown stops the processor and processes remaining tasks. With
+// WithDrainTimeout it returns after at most the drain timeout.
+func (bp *BatchProcessor[T]) Shutdown() {
+	if bp.drainTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), bp.drainTimeout)
+		defer cancel()
+		bp.ShutdownCtx(ctx)
+		return
+	}
+	bp.shutdown()
+}
+
+// shutdown stops the processor, processes remaining tasks and waits for all
+// worker function calls to return. It runs once.
+func (bp *BatchProcessor[T]) shutdown() {
+	bp.closeOnce.Do(func() {
+		bp.scaleMu.Lock()
+		bp.closed = true
+		if !bp.started {
+			bp.launchAll() // Someone has to drain the queues
+		}
+		bp.scaleMu.Unlock()
+		close(bp.stop)
+		if bp.ctxWorker != nil {
+			go bp.cancelAfterGrace()
+		}
+		bp.wg.Wait() // Wait for batch formation to stop
+
+		// Process remaining tasks separately, not involving WaitGroup;
+		// high-priority tasks first. Senders see stop and release sendMu.
+		bp.sendMu.Lock()
+		close(bp.highTasks)
+		close(bp.tasks)
+		for _, w := range bp.partitions {
+			close(w.tasks)
+		}
+		bp.sendMu.Unlock()
+
+		// With WithFinalBatchHandler the remainder is kept for it until
+		// processing is over
+		var final [][]T
+		hand := func(out chan<- handoff[T]) func([]T) {
+			return func(batch []T) {
+				if bp.onFinalBatch == nil {
+					bp.flushBatch(out, batch, TriggerShutdown)
+				} else if len(batch) > 0 {
+					final = append(final, batch)
+				}
+			}
+		}
+
+		maxSize := bp.MaxSize()
+		high := make([]T, 0, min(len(bp.highTasks), maxSize))
+		for task := range bp.highTasks {
+			high = append(high, task)
+			if len(high) >= maxSize {
+				hand(bp.batches)(high)
+				high = make([]T, 0, min(len(bp.highTasks), maxSize))
+			}
+		}
+		hand(bp.batches)(high)
+
+		bp.drainQueue(bp.tasks, hand(bp.batches))
+		close(bp.batches)
+		// Each partition's remainder goes to its own processing goroutine, after its last batch
+		for _, w := range bp.partitions {
+			bp.drainQueue(w.tasks, hand(w.batches))
+			close(w.batches)
+		}
+		bp.processWG.Wait() // Wait for handed-off batches to be processed
+		for _, batch := range final {
+			bp.handleFinal(batch)
+		}
+		bp.cancel()
+		close(bp.done)
+	})
+}
+
+// drainQueue passes the tasks of the closed queue tasks to hand, in batches
+// of at most maxSize that also end at flush markers. A batch may be empty.
+func (bp *BatchProcessor[T]) drainQueue(tasks chan item[T], hand func(batch []T)) {
+	maxSize := bp.MaxSize()
+	remaining := make([]T, 0, min(len(tasks), maxSize))
+	var keys map[string]int
+	if bp.coalesceKey != nil {
+		keys = make(map[string]int)
+	}
+	for it := range tasks {
+		if !it.flush {
+			remaining = bp.appendTask(remaining, keys, it.task)
+			if len(remaining) < maxSize {
+				continue
+			}
+		}
+		hand(remaining)
+		remaining = make([]T, 0, min(len(tasks), maxSize))
+	}
+	hand(remaining)
+}
+
+// handleFinal passes a batch drained at shutdown to the WithFinalBatchHandler
+// function, counting it like a processed batch.
+func (bp *BatchProcessor[T]) handleFinal(batch []T) {
+	bp.batchesFlushed.Add(1)
+	bp.tasksFlushed.Add(int64(len(batch)))
+	bp.observe(len(batch), TriggerShutdown)
+	bp.busyMu.Lock()
+	bp.busy[-1] = batch
+	bp.busyMu.Unlock()
+
+	bp.onFinalBatch(batch)
+
+	bp.busyMu.Lock()
+	delete(bp.busy, -1)
+	bp.busyMu.Unlock()
+	bp.releaseMemory(batch)
+	bp.tasksProcessed.Add(int64(len(batch)))
+}
+
+// ShutdownWithin is like Shutdown but waits at most d for it to complete. If
+// workers are still busy after d (e.g. a hung worker function), it logs the
+// sizes of the batches being processed and returns an error instead of
+// blocking. Goroutines cannot be killed, so abandoned workers may keep running
+// in the background, and tasks still queued are processed if they ever return.
+func (bp *BatchProcessor[T]) ShutdownWithin(d time.Duration) error {
+	go bp.shutdown()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-bp.done:
+		return nil
+	case <-timer.C:
+	}
+	bp.abandonOnce.Do(func() { close(bp.abandon) })
+
+	sizes := bp.busySizes()
+	logrus.Warnf("asyncbatch: shutdown abandoned after %v, %d worker(s) stuck with batch sizes %v",
+		d, len(sizes), sizes)
+	return errors.E("shutdown deadline exceeded", "deadline", d, "stuck-batch-sizes", sizes)
+}
+
+// ShutdownCtx starts the same graceful shutdown as Shutdown and waits until it
+// completes or ctx is done. It returns 0 on completion; otherwise it logs the
+// stuck batch sizes and returns the number of accepted tasks whose worker
+// function call had not returned by then (still queued, in a batch being
+// formed, or in a stuck worker). The shutdown carries on in the background.
+func (bp *BatchProcessor[T]) ShutdownCtx(ctx context.Context) (unprocessed int) {
+	go bp.shutdown()
+
+	select {
+	case <-bp.done:
+		return 0
+	case <-ctx.Done():
+	}
+	select {
+	case <-bp.done: // Completed at the same moment
+		return 0
+	default:
+	}
+	bp.abandonOnce.Do(func() { close(bp.abandon) })
+
+	unprocessed = int(bp.tasksAdded.Load() - bp.settled())
+	sizes := bp.busySizes()
+	logrus.Warnf("asyncbatch: shutdown abandoned (%v), %d task(s) unprocessed, %d worker(s) stuck with batch sizes %v",
+		ctx.Err(), unprocessed, len(sizes), sizes)
+	return unprocessed
+}
+
+// ShutdownTimeout is ShutdownCtx with a deadline of d that reports a timeout
+// as an error carrying the number of unprocessed tasks. No accepted task is
+// lost: if every worker function call returns within d, all queued tasks and
+// in-flight batches have been processed when it returns nil; after a timeout
+// the shutdown still completes in the background once the workers return.
+func (bp *BatchProcessor[T]) ShutdownTimeout(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if n := bp.ShutdownCtx(ctx); n > 0 {
+		return errors.E("shutdown timed out", "timeout", d, "unprocessed", n)
+	}
+	return nil
+}
+
+// busySizes returns the sizes of the batches inside worker functions, sorted.
+func (bp *BatchProcessor[T]) busySizes() []int {
+	bp.busyMu.Lock()
+	sizes := make([]int, 0, len(bp.busy))
+	for _, batch := range bp.busy {
+		sizes = append(sizes, len(batch))
+	}
+	bp.busyMu.Unlock()
+	sort.Ints(sizes)
+	return sizes
+}
+
+// cancelAfterGrace cancels the worker context once the grace period has passed,
+// unless Shutdown completes first.
+func (bp *BatchProcessor[T]) cancelAfterGrace() {
+	timer := time.NewTimer(bp.gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		bp.cancel()
+	case <-bp.done:
+	}
+}
+
+// TasksCap returns the capacity of the normal task queue, summed over the
+// partition queues with WithPartitioner.
+func (bp *BatchProcessor[T]) TasksCap() int {
+	n := cap(bp.tasks)
+	for _, w := range bp.partitions {
+		n += cap(w.tasks)
+	}
+	return n
+}
+
+// QueueDepth returns the number of entries waiting in the normal task queue
+// (summed over the partition queues, flush markers included), the counterpart
+// of TasksCap. Producers can use it to throttle themselves before Add fails,
+// e.g. by slowing down once QueueDepth exceeds a fraction of TasksCap. Tasks
+// already taken into a forming batch and high-priority tasks are not counted.
+func (bp *BatchProcessor[T]) QueueDepth() int {
+	n := len(bp.tasks)
+	for _, w := range bp.partitions {
+		n += len(w.tasks)
+	}
+	return n
+}
+
+// MemoryUsage returns the estimated bytes, by the WithMemoryLimit size
+// function, of the tasks accepted but not yet processed: queued, in a forming
+// batch or inside the worker function. It is always 0 without WithMemoryLimit.
+func (bp *BatchProcessor[T]) MemoryUsage() int64 {
+	return bp.memoryUsed.Load()
+}
+
+// DumpState returns copies of the tasks waiting in the queues (high-priority
+// ones first, flush markers left out) and of the batches currently inside the
+// worker function, ordered by worker, for diagnosing a stuck processor. Tasks
+// in a batch that is still being formed, or waiting to be handed to a busy
+// worker function, are in neither.
+//
+// Channels cannot be inspected in place, so DumpState takes the queued tasks
+// out and puts them back while holding off Add and its variants; AddWait calls
+// blocked on a full queue step aside meanwhile. Workers keep consuming, so a
+// task received by a worker during the dump may be processed ahead of queued
+// tasks that were taken out. A worker function that modifies its batch in
+// place races with the copy of it. After Shutdown starts, pending is nil.
+func (bp *BatchProcessor[T]) DumpState() (pending []T, inFlight [][]T) {
+	bp.dumpMu.Lock()
+	defer bp.dumpMu.Unlock()
+
+	close(bp.yield)
+	bp.sendMu.Lock()
+	bp.yield = make(chan struct{})
+	select {
+	case <-bp.stop: // Shutdown closes and drains the queues itself
+	default:
+		// Non-blocking receives: workers may empty a queue meanwhile
+		var high []T
+	high:
+		for {
+			select {
+			case task := <-bp.highTasks:
+				high = append(high, task)
+			default:
+				break high
+			}
+		}
+		pending = append(pending, high...)
+		// Senders are held off, so there is room to put everything back
+		for _, task := range high {
+			bp.highTasks <- task
+		}
+		pending = append(pending, dumpQueue(bp.tasks)...)
+		for _, w := range bp.partitions {
+			pending = append(pending, dumpQueue(w.tasks)...)
+		}
+	}
+	bp.sendMu.Unlock()
+
+	bp.busyMu.Lock()
+	ids := make([]int, 0, len(bp.busy))
+	for id := range bp.busy {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		inFlight = append(inFlight, append([]T(nil), bp.busy[id]...))
+	}
+	bp.busyMu.Unlock()
+	return pending, inFlight
+}
+
+// dumpQueue takes the items out of tasks and puts them back, returning the
+// tasks among them. Senders must be held off.
+func dumpQueue[T any](tasks chan item[T]) []T {
+	var items []item[T]
+drain:
+	for {
+		select {
+		case it := <-tasks: // Non-blocking: workers may empty the queue meanwhile
+			items = append(items, it)
+		default:
+			break drain
+		}
+	}
+	var pending []T
+	for _, it := range items {
+		tasks <- it
+		if !it.flush {
+			pending = append(pending, it.task)
+		}
+	}
+	return pending
+}
+
+// Stats returns a snapshot of queue depth and processing counters. It is safe
+// to call concurrently with Add and after Shutdown.
+func (bp *BatchProcessor[T]) Stats() Stats {
+	bp.busyMu.Lock()
+	inFlight := len(bp.busy)
+	bp.busyMu.Unlock()
+	queued := bp.QueueDepth() + len(bp.highTasks)
+	batches := bp.batchesFlushed.Load()
+	var avg float64
+	if batches > 0 {
+		avg = float64(bp.tasksFlushed.Load()) / float64(batches)
+	}
+	return Stats{
+		QueuedTasks:         queued,
+		Capacity:            bp.TasksCap(),
+		InFlightBatches:     inFlight,
+		TotalTasksAdded:     bp.tasksAdded.Load(),
+		TotalTasksDropped:   bp.tasksDropped.Load(),
+		TotalBatchesFlushed: batches,
+		TotalTasksProcessed: bp.tasksProcessed.Load(),
+		TotalTasksCoalesced: bp.tasksCoalesced.Load(),
+		AvgBatchSize:        avg,
+		UnderfilledFlushes:  bp.underfilled.Load(),
+		CurrentWorkers:      bp.NumWorkers(),
+		MemoryUsage:         bp.MemoryUsage(),
+	}
+}
+
+// run is the internal worker loop for processing batches.
+func (bp *BatchProcessor[T]) run(w *workerHandle[T]) {
+	batch := make([]T, 0, bp.MaxSize())
+	var timer *time.Timer
+
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	highStreak := 0 // Consecutive high-priority batches, for WithPriorityFairness
+
+	for {
+		// First check for stop or retire signal
+		select {
+		case <-bp.stop:
+			bp.flushBatch(w.batches, batch, TriggerShutdown)
+			return
+		case <-w.quit:
+			bp.flushBatch(w.batches, batch, TriggerShutdown)
+			return
+		default:
+		}
+
+		// Check thresholds first; they may change between iterations (SetMaxSize)
+		lowerThreshold, upperThreshold := bp.LowerThreshold(), bp.UpperThreshold()
+		if len(batch) >= upperThreshold {
+			trigger := TriggerUpperRatio
+			if len(batch) >= bp.MaxSize() {
+				trigger = TriggerFull
+			}
+			bp.flushBatch(w.batches, batch, trigger)
+			batch, timer = bp.resetBatchAndTimer(batch, timer)
+			continue
+		}
+
+		// Serve high-priority tasks ahead of the normal queue
+		highChan := bp.highTasks
+		if bp.highStreakLimit > 0 && highStreak >= bp.highStreakLimit {
+			highChan = nil
+		}
+		select {
+		case task := <-highChan:
+			bp.flushHigh(w, task)
+			highStreak++
+			continue
+		default:
+		}
+
+		// Flush at once rather than wait when nothing more is queued
+		if bp.flushOnEmpty && len(batch) > 0 && len(w.tasks) == 0 {
+			bp.flushBatch(w.batches, batch, TriggerQueueEmpty)
+			batch, timer = bp.resetBatchAndTimer(batch, timer)
+			continue
+		}
+
+		// Initialize timer
+		timer = bp.initTimer(w, timer)
+
+		select {
+		case it, ok := <-w.tasks:
+			highStreak = 0
+			if !ok {
+				bp.flushBatch(w.batches, batch, TriggerShutdown)
+				return
+			}
+			if it.flush {
+				bp.flushBatch(w.batches, batch, TriggerFlush)
+				batch, timer = bp.resetBatchAndTimer(batch, timer)
+				continue
+			}
+			bp.recordArrival(w)
+			batch = bp.appendTask(batch, w.keys, it.task)
+
+		case task := <-highChan:
+			bp.flushHigh(w, task)
+			highStreak++
+
+		case reply := <-w.flush:
+			bp.flushQueued(w, batch)
+			batch, timer = bp.resetBatchAndTimer(batch, timer)
+			close(reply)
+
+		case <-timer.C:
+			highStreak = 0
+			batch, timer = bp.handleTimerExpired(batch, timer, lowerThreshold, w)
+		}
+	}
+}
+
+// Flush makes every worker hand the batch it is forming to the worker
+// function at once, below the thresholds and without waiting for its timer,
+// together with the tasks still waiting in the queues (split at maxSize), and
+// returns when all of them have been handed off (the worker function may
+// still be running). Tasks added while Flush runs may or may not be included.
+// Unlike Shutdown it leaves the processor running and closes nothing, so it
+// can be called any number of times. It returns ctx.Err() if ctx is done
+// first, ErrClosed if the processor is shut down, or an error if it waits for
+// Start (WithManualStart).
+func (bp *BatchProcessor[T]) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if bp.isStopped() {
+		return ErrClosed
+	}
+	bp.scaleMu.Lock()
+	if !bp.started {
+		bp.scaleMu.Unlock()
+		return errors.E("batch processor has not been started")
+	}
+	workers := make([]*workerHandle[T], 0, len(bp.workers))
+	for _, w := range bp.workers {
+		workers = append(workers, w)
+	}
+	bp.scaleMu.Unlock()
+
+	for _, w := range workers {
+		reply := make(chan struct{})
+		select {
+		case w.flush <- reply:
+		case <-w.quit:
+			continue // A retired worker flushes its batch on exit
+		case <-bp.stop:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-reply:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// idlePollInterval is how often WaitForIdle checks the counters.
+const idlePollInterval = time.Millisecond
+
+// WaitForIdle blocks until every task accepted so far has been processed,
+// i.e. the worker function has returned for it, or ctx is done, returning
+// ctx.Err() in that case. Queued tasks, batches being formed (which may wait
+// up to the underfilled wait) and in-flight batches all count as busy. Unlike
+// Flush it forces nothing, and unlike Shutdown it leaves the processor
+// running. If producers keep adding tasks, the processor may never be idle, or
+// be idle only momentarily: WaitForIdle returns on the first moment it
+// observes idleness, and tasks may be added right after.
+func (bp *BatchProcessor[T]) WaitForIdle(ctx context.Context) error {
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+	for {
+		if bp.settled() >= bp.tasksAdded.Load() {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ScaleWorkers changes the number of workers at runtime. n must be in the same
+// 1-8 range as WithNumWorkers. Scaling up starts new workers immediately.
+// Scaling down is best-effort: each retired worker first hands off the batch it
+// is forming and finishes the batch it is processing, so NumWorkers reports the
+// new value before the excess workers have actually exited. The task queue
+// keeps the capacity it was created with.
+func (bp *BatchProcessor[T]) ScaleWorkers(n int) error {
+	if n < 1 || n > 8 {
+		return errors.E("numWorkers must be between 1 and 8", "numWorkers", n)
+	}
+	if bp.partitionOf != nil {
+		return errors.E("ScaleWorkers is not supported with a partitioner")
+	}
+	bp.scaleMu.Lock()
+	defer bp.scaleMu.Unlock()
+	if bp.closed {
+		return ErrClosed
+	}
+
+	for len(bp.workers) < n {
+		bp.startWorker()
+	}
+	if excess := len(bp.workers) - n; excess > 0 {
+		// Retire the newest workers
+		ids := make([]int, 0, len(bp.workers))
+		for id := range bp.workers {
+			ids = append(ids, id)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+		for _, id := range ids[:excess] {
+			close(bp.workers[id].quit)
+			delete(bp.workers, id)
+		}
+	}
+	bp.numWorkers = n
+	return nil
+}
+
+// startWorker starts a new worker, a pair of goroutines: run forms batches and
+// hands them over the unbuffered batches channel to process, which calls the
+// worker function. Batch formation thus continues while the previous batch is
+// being processed. Both exit when the worker's quit channel is closed. Until
+// a WithManualStart processor is started, the worker is only registered and
+// Start launches its goroutines.
+func (bp *BatchProcessor[T]) startWorker() {
+	id := bp.nextWorkerID
+	bp.nextWorkerID++
+	w := &workerHandle[T]{
+		quit:    make(chan struct{}),
+		flush:   make(chan chan struct{}),
+		tasks:   bp.tasks,
+		batches: bp.batches,
+	}
+	if bp.coalesceKey != nil {
+		w.keys = make(map[string]int)
+	}
+	if bp.adaptiveMax != 0 {
+		// Start halfway between the bounds
+		w.lastArrival = time.Now()
+		w.gapEWMA = float64(bp.adaptiveMin+bp.adaptiveMax) / 4
+		w.wait.Store(int64(bp.adaptiveWait(w.gapEWMA)))
+	}
+	if bp.partitionOf != nil {
+		size := bp.maxSize * 2
+		if bp.queueSize > 0 {
+			size = bp.queueSize
+		}
+		w.tasks = make(chan item[T], size)
+		w.batches = make(chan handoff[T])
+		bp.partitions = append(bp.partitions, w)
+	}
+	bp.workers[id] = w
+	if bp.started {
+		bp.launch(id, w)
+	}
+}
+
+// launch starts the goroutines of worker id.
+func (bp *BatchProcessor[T]) launch(id int, w *workerHandle[T]) {
+	bp.wg.Add(1)
+	bp.processWG.Add(1)
+	go func() {
+		defer bp.wg.Done()
+		bp.run(w)
+	}()
+	go func() {
+		defer bp.processWG.Done()
+		bp.process(id, w.quit, w.batches)
+	}()
+}
+
+// process calls the worker function for each batch handed off on batches
+// until that channel is closed or the worker is retired.
+func (bp *BatchProcessor[T]) process(id int, quit <-chan struct{}, batches <-chan handoff[T]) {
+	for {
+		var h handoff[T]
+		select {
+		case b, ok := <-batches:
+			if !ok {
+				return
+			}
+			h = b
+		case <-quit:
+			return
+		}
+		batch := h.tasks
+
+		if bp.limiter != nil && !bp.limiter.wait(bp.abandon) {
+			bp.reportFailure(batch, errors.E("rate limit wait abandoned by shutdown", "batch-size", len(batch)))
+			bp.releaseMemory(batch)
+			bp.tasksProcessed.Add(int64(len(batch)))
+			continue
+		}
+		bp.observe(len(batch), h.trigger)
+		bp.busyMu.Lock()
+		bp.busy[id] = batch
+		bp.busyMu.Unlock()
+
+		ctx, end := bp.ctx, func() {}
+		if bp.tracer != nil {
+			ctx, end = bp.startSpan(len(batch))
+		}
+		bp.callWorker(ctx, batch)
+		end()
+
+		bp.busyMu.Lock()
+		delete(bp.busy, id)
+		bp.busyMu.Unlock()
+		bp.releaseMemory(batch)
+		bp.tasksProcessed.Add(int64(len(batch)))
+	}
+}
+
+// callWorker passes batch to the worker function, reporting the error of a
+// context-aware one. Retries of an acknowledging worker get only the tasks it
+// has not acknowledged.
+func (bp *BatchProcessor[T]) callWorker(ctx context.Context, batch []T) {
+	if bp.ctxWorker == nil {
+		bp.worker(batch)
+		return
+	}
+	err := bp.ctxWorker(ctx, batch)
+	delay := bp.retryBackoff
+	for attempt := 2; err != nil && attempt <= bp.maxAttempts; attempt++ {
+		if u, ok := err.(*unackedError[T]); ok {
+			batch = u.tasks
+		}
+		if !bp.sleepRetry(delay) {
+			break
+		}
+		if bp.retryExponential {
+			delay *= 2
+		}
+		err = bp.ctxWorker(ctx, batch)
+	}
+	if u, ok := err.(*unackedError[T]); ok {
+		batch = u.tasks
+	}
+	if err == nil {
+		return
+	}
+	bp.reportFailure(batch, err)
+}
+
+// reportFailure passes a batch that was not processed successfully to the
+// WithErrorHandler function, or logs it.
+func (bp *BatchProcessor[T]) reportFailure(batch []T, err error) {
+	if bp.onBatchError != nil {
+		bp.onBatchError(batch, err)
+		return
+	}
+	logrus.Warnf("asyncbatch: batch of %d tasks failed: %v", len(batch), err)
+}
+
+// startSpan calls the WithTracer function for a batch of size n, returning
+// the context for the worker and the function ending the span. A nil context
+// or end function from the tracer is replaced by bp.ctx or a no-op.
+func (bp *BatchProcessor[T]) startSpan(n int) (context.Context, func()) {
+	ctx, end := bp.tracer(bp.ctx, n)
+	if ctx == nil {
+		ctx = bp.ctx
+	}
+	if end == nil {
+		end = func() {}
+	}
+	return ctx, end
+}
+
+// sleepRetry waits d before a retry, returning false if the retry must be
+// abandoned first: the worker context is cancelled, or with WithBatchRetry
+// Shutdown is called.
+func (bp *BatchProcessor[T]) sleepRetry(d time.Duration) bool {
+	var stop <-chan struct{}
+	if bp.retryExponential {
+		stop = bp.stop
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-bp.ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// Helper function 1: Hand a non-empty batch to a processing goroutine over
+// out. Blocks while the goroutines reading out are busy; the caller must not
+// reuse batch.
+func (bp *BatchProcessor[T]) flushBatch(out chan<- handoff[T], batch []T, trigger string) {
+	if len(batch) > 0 {
+		bp.batchesFlushed.Add(1)
+		bp.tasksFlushed.Add(int64(len(batch)))
+		out <- handoff[T]{tasks: batch, trigger: trigger}
+	}
+}
+
+// observe reports a batch about to be processed to the size and batch
+// observers.
+func (bp *BatchProcessor[T]) observe(size int, trigger string) {
+	if bp.sizeObserver != nil {
+		bp.sizeObserver(size)
+	}
+	if bp.batchObserver != nil {
+		bp.batchObserver(size, trigger)
+	}
+}
+
+// flushQueued flushes batch followed by everything currently in w's queues,
+// high-priority tasks first, in batches of at most maxSize, without waiting
+// for more tasks. Flush markers in the queue end a batch as usual.
+func (bp *BatchProcessor[T]) flushQueued(w *workerHandle[T], batch []T) {
+	bp.flushBatch(w.batches, batch, TriggerFlush)
+
+	maxSize := bp.MaxSize()
+	high := make([]T, 0, maxSize)
+drainHigh:
+	for {
+		select {
+		case task := <-bp.highTasks:
+			high = append(high, task)
+			if len(high) >= maxSize {
+				bp.flushBatch(w.batches, high, TriggerFlush)
+				high = make([]T, 0, maxSize)
+			}
+		default:
+			break drainHigh
+		}
+	}
+	bp.flushBatch(w.batches, high, TriggerFlush)
+
+	batch = make([]T, 0, maxSize)
+	for {
+		select {
+		case it, ok := <-w.tasks:
+			if !ok {
+				bp.flushBatch(w.batches, batch, TriggerShutdown)
+				return
+			}
+			if !it.flush {
+				batch = bp.appendTask(batch, w.keys, it.task)
+			}
+			if it.flush || len(batch) >= maxSize {
+				bp.flushBatch(w.batches, batch, TriggerFlush)
+				batch = make([]T, 0, maxSize)
+			}
+		default:
+			bp.flushBatch(w.batches, batch, TriggerFlush)
+			return
+		}
+	}
+}
+
+// appendTask adds task to batch. With WithCoalesce, a task whose key is
+// already in batch replaces that task instead; keys maps each key to its
+// position in batch and is reset when a new batch starts.
+func (bp *BatchProcessor[T]) appendTask(batch []T, keys map[string]int, task T) []T {
+	if bp.coalesceKey == nil {
+		return append(batch, task)
+	}
+	if len(batch) == 0 {
+		clear(keys)
+	}
+	key := bp.coalesceKey(task)
+	if i, ok := keys[key]; ok && i < len(batch) {
+		bp.releaseMemory(batch[i : i+1])
+		batch[i] = task
+		bp.tasksCoalesced.Add(1)
+		return batch
+	}
+	keys[key] = len(batch)
+	return append(batch, task)
+}
+
+// reserveMemory adds the estimated size of task to the WithMemoryLimit usage
+// and returns it, so it can be released if the task is not queued after all,
+// or fails with ErrMemoryLimit if the task does not fit. A task is always
+// accepted when nothing is held.
+func (bp *BatchProcessor[T]) reserveMemory(task T) (int64, error) {
+	if bp.sizeOf == nil {
+		return 0, nil
+	}
+	size := int64(bp.sizeOf(task))
+	for {
+		used := bp.memoryUsed.Load()
+		if used > 0 && used+size > int64(bp.memoryLimit) {
+			return 0, errors.WrapE(ErrMemoryLimit, "", "used", used, "size", size, "limit", bp.memoryLimit)
+		}
+		if bp.memoryUsed.CompareAndSwap(used, used+size) {
+			return size, nil
+		}
+	}
+}
+
+// releaseMemory subtracts the estimated size of tasks, which no longer need
+// processing, from the WithMemoryLimit usage.
+func (bp *BatchProcessor[T]) releaseMemory(tasks []T) {
+	if bp.sizeOf == nil {
+		return
+	}
+	var size int64
+	for _, task := range tasks {
+		size += int64(bp.sizeOf(task))
+	}
+	bp.memoryUsed.Add(-size)
+}
+
+// settled returns the number of tasks that no longer need processing: those
+// the worker function has returned from and those replaced by WithCoalesce.
+func (bp *BatchProcessor[T]) settled() int64 {
+	return bp.tasksProcessed.Load() + bp.tasksCoalesced.Load()
+}
+
+// gapEWMAWeight is the weight of the newest sample in the inter-arrival average.
+const gapEWMAWeight = 0.2
+
+// recordArrival folds the time since w's previous task into its inter-arrival
+// average, for the adaptive wait.
+func (bp *BatchProcessor[T]) recordArrival(w *workerHandle[T]) {
+	if bp.adaptiveMax == 0 {
+		return
+	}
+	now := time.Now()
+	bp.recordGap(w, now.Sub(w.lastArrival))
+	w.lastArrival = now
+}
+
+// recordGap folds gap into w's inter-arrival average and updates its wait.
+// Gaps are capped at the maximum wait, which they already reach at half of
+// it, so that the wait recovers quickly when a burst follows a long lull.
+func (bp *BatchProcessor[T]) recordGap(w *workerHandle[T], gap time.Duration) {
+	gap = min(gap, bp.adaptiveMax)
+	w.gapEWMA += gapEWMAWeight * (float64(gap) - w.gapEWMA)
+	w.wait.Store(int64(bp.adaptiveWait(w.gapEWMA)))
+}
+
+// adaptiveWait returns the wait for an average inter-arrival time of gap
+// nanoseconds: twice the gap, clamped to the WithAdaptiveWait bounds.
+func (bp *BatchProcessor[T]) adaptiveWait(gap float64) time.Duration {
+	wait := time.Duration(math.Min(2*gap, float64(bp.adaptiveMax)))
+	return max(bp.adaptiveMin, wait)
+}
+
+// EffectiveWait returns the wait currently used before checking a forming
+// batch: the fixed wait, or with WithAdaptiveWait the wait derived from the
+// recent arrival rate, averaged over the workers.
+func (bp *BatchProcessor[T]) EffectiveWait() time.Duration {
+	if bp.adaptiveMax == 0 {
+		return bp.fixedWait
+	}
+	bp.scaleMu.Lock()
+	defer bp.scaleMu.Unlock()
+	if len(bp.workers) == 0 {
+		return bp.adaptiveWait(float64(bp.adaptiveMin+bp.adaptiveMax) / 4)
+	}
+	var sum time.Duration
+	for _, w := range bp.workers {
+		sum += time.Duration(w.wait.Load())
+	}
+	return sum / time.Duration(len(bp.workers))
+}
+
+// flushHigh gathers high-priority tasks following first for up to highWait,
+// or until maxSize is reached, and flushes them as one batch.
+func (bp *BatchProcessor[T]) flushHigh(w *workerHandle[T], first T) {
+	maxSize := bp.MaxSize()
+	batch := make([]T, 1, maxSize)
+	batch[0] = first
+	timer := time.NewTimer(bp.highWait)
+	defer timer.Stop()
+gather:
+	for len(batch) < maxSize {
+		select {
+		case task := <-bp.highTasks:
+			batch = append(batch, task)
+		case <-timer.C:
+			break gather
+		case <-bp.stop:
+			break gather
+		}
+	}
+	bp.flushBatch(w.batches, batch, TriggerPriority)
+}
+
+// Helper function 2: Reset batch and timer
+func (bp *BatchProcessor[T]) resetBatchAndTimer(batch []T, timer *time.Timer) ([]T, *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+	return make([]T, 0, bp.MaxSize()), nil
+}
+
+// Helper function 3: Initialize timer
+func (bp *BatchProcessor[T]) initTimer(w *workerHandle[T], timer *time.Timer) *time.Timer {
+	wait := bp.fixedWait
+	if bp.adaptiveMax != 0 {
+		wait = time.Duration(w.wait.Load())
+	}
+	if timer == nil {
+		return time.NewTimer(wait)
+	}
+	timer.Reset(wait)
+	return timer
+}
+
+// Helper function 4: Handle timer expiration
+func (bp *BatchProcessor[T]) handleTimerExpired(batch []T, timer *time.Timer, lowerThreshold int, w *workerHandle[T]) ([]T, *time.Timer) {
+	if bp.adaptiveMax != 0 {
+		// No task for a whole wait: the current gap is at least as long as
+		// the time since the last task, so fold that in to lengthen the wait
+		// while tasks are sparse or have stopped
+		bp.recordGap(w, time.Since(w.lastArrival))
+	}
+	if len(batch) >= lowerThreshold {
+		bp.flushBatch(w.batches, batch, TriggerLowerThreshold)
+		return bp.resetBatchAndTimer(batch, timer)
+	}
+
+	// Start secondary waiting
+	timer.Reset(bp.underfilledWait)
+	select {
+	case it, ok := <-w.tasks:
+		if !ok {
+			bp.flushBatch(w.batches, batch, TriggerShutdown)
+			return bp.resetBatchAndTimer(batch, timer)
+		}
+		if it.flush {
+			bp.flushBatch(w.batches, batch, TriggerFlush)
+			return bp.resetBatchAndTimer(batch, timer)
+		}
+		bp.recordArrival(w)
+		return bp.appendTask(batch, w.keys, it.task), timer
+
+	case task := <-bp.highTasks:
+		// Serve it now; the underfilled batch keeps waiting in the run loop
+		bp.flushHigh(w, task)
+		return batch, timer
+
+	case <-timer.C:
+		if len(batch) > 0 {
+			bp.underfilled.Add(1)
+		}
+		bp.flushBatch(w.batches, batch, TriggerUnderfilledTimeout)
+		return bp.resetBatchAndTimer(batch, timer)
+
+	case <-bp.stop:
+		// Reset so the run loop does not flush the same batch again on stop
+		bp.flushBatch(w.batches, batch, TriggerShutdown)
+		return bp.resetBatchAndTimer(batch, timer)
+
+	case reply := <-w.flush:
+		bp.flushQueued(w, batch)
+		close(reply)
+		return bp.resetBatchAndTimer(batch, timer)
+
+	case <-w.quit:
+		bp.flushBatch(w.batches, batch, TriggerShutdown)
+		return bp.resetBatchAndTimer(batch, timer)
 	}
 }
 
 // Getter methods
-func (bp *BatchProcessor[T]) MaxSize() int                   { return bp.maxSize }
-func (bp *BatchProcessor[T]) UpperRatio() float64            { return bp.upperRatio }
-func (bp *BatchProcessor[T]) LowerRatio() float64            { return bp.lowerRatio }
+func (bp *BatchProcessor[T]) MaxSize() int                   { return bp.limits.Load().maxSize }
+func (bp *BatchProcessor[T]) UpperRatio() float64            { return bp.limits.Load().upperRatio }
+func (bp *BatchProcessor[T]) LowerRatio() float64            { return bp.limits.Load().lowerRatio }
 func (bp *BatchProcessor[T]) FixedWait() time.Duration       { return bp.fixedWait }
 func (bp *BatchProcessor[T]) UnderfilledWait() time.Duration { return bp.underfilledWait }
-func (bp *BatchProcessor[T]) NumWorkers() int                { return bp.numWorkers }
+func (bp *BatchProcessor[T]) MaxBatchesPerSecond() float64   { return bp.maxBatchesPerSec }
 func (bp *BatchProcessor[T]) Worker() func([]T)              { return bp.worker }
+
+// NumWorkers returns the current number of workers, as last set by
+// WithNumWorkers or ScaleWorkers.
+func (bp *BatchProcessor[T]) NumWorkers() int {
+	bp.scaleMu.Lock()
+	defer bp.scaleMu.Unlock()
+	return bp.numWorkers
+}
+
+// LowerThreshold returns the batch size at or above which a batch is flushed
+// once fixedWait expires: floor(maxSize*lowerRatio), at least 1. Smaller
+// batches wait up to underfilledWait for more tasks.
+func (bp *BatchProcessor[T]) LowerThreshold() int {
+	l := bp.limits.Load()
+	return int(math.Max(1, math.Floor(float64(l.maxSize)*l.lowerRatio)))
+}
+
+// UpperThreshold returns the batch size at which a batch is flushed right away
+// without waiting: floor(maxSize*upperRatio), clamped to [1, maxSize].
+func (bp *BatchProcessor[T]) UpperThreshold() int {
+	l := bp.limits.Load()
+	t := int(float64(l.maxSize) * l.upperRatio)
+	return max(1, min(t, l.maxSize))
+}
+
+// SetMaxSize changes the maximum batch size at runtime. Workers pick it up at
+// their next check of the batch they are forming: a batch already at or above
+// the new upper threshold is flushed as it is, and batches handed off before
+// are not affected. The queue capacities, derived from the initial maxSize,
+// do not change.
+func (bp *BatchProcessor[T]) SetMaxSize(size int) error {
+	if size <= 0 {
+		return errors.E("maxSize must be positive", "maxSize", size)
+	}
+	return bp.updateLimits(func(l *batchLimits) { l.maxSize = size })
+}
+
+// SetUpperRatio changes the upper ratio at runtime, like SetMaxSize. It must
+// be in (0, 1] and not below the current lower ratio.
+func (bp *BatchProcessor[T]) SetUpperRatio(ratio float64) error {
+	if ratio <= 0 || ratio > 1 {
+		return errors.E("upperRatio must be between 0 and 1", "upperRatio", ratio)
+	}
+	return bp.updateLimits(func(l *batchLimits) { l.upperRatio = ratio })
+}
+
+// SetLowerRatio changes the lower ratio at runtime, like SetMaxSize. It must
+// be in (0, 1] and not above the current upper ratio.
+func (bp *BatchProcessor[T]) SetLowerRatio(ratio float64) error {
+	if ratio <= 0 || ratio > 1 {
+		return errors.E("lowerRatio must be between 0 and 1", "lowerRatio", ratio)
+	}
+	return bp.updateLimits(func(l *batchLimits) { l.lowerRatio = ratio })
+}
+
+// updateLimits applies change to a copy of the current limits and stores it
+// if the ratios are still consistent.
+func (bp *BatchProcessor[T]) updateLimits(change func(l *batchLimits)) error {
+	bp.limitsMu.Lock()
+	defer bp.limitsMu.Unlock()
+	l := *bp.limits.Load()
+	change(&l)
+	if l.upperRatio < l.lowerRatio {
+		return errors.E("upperRatio must be greater than or equal to lowerRatio",
+			"upperRatio", l.upperRatio, "lowerRatio", l.lowerRatio)
+	}
+	bp.limits.Store(&l)
+	return nil
+}
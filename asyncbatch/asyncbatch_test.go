@@ -3,6 +3,7 @@ package asyncbatch_test
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/kaichao/gopkg/asyncbatch"
+	"github.com/kaichao/gopkg/errors"
 )
 
 // Helper function to wait with timeout
@@ -26,22 +28,14 @@ func waitWithTimeout(t *testing.T, wg *sync.WaitGroup, timeout time.Duration) {
 	}
 }
 
-// addTasks adds tasks to BatchProcessor with retry mechanism.
+// addTasks adds tasks to BatchProcessor, waiting for room in the queue.
 func addTasks[T any](t *testing.T, bp *asyncbatch.BatchProcessor[T], tasks []T, timeout time.Duration) {
 	t.Helper()
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	for i, task := range tasks {
-		for {
-			select {
-			case <-ctx.Done():
-				t.Fatalf("Timeout adding task %d", i)
-			default:
-				if err := bp.Add(task); err == nil {
-					break
-				}
-				time.Sleep(100 * time.Microsecond)
-			}
+		if err := bp.AddWait(ctx, task); err != nil {
+			t.Fatalf("Adding task %d failed: %v", i, err)
 		}
 	}
 }
@@ -331,6 +325,13 @@ func TestBatchProcessor(t *testing.T) {
 		if err := bp.Add("task2"); err == nil || err.Error() != "batch processor is closed" {
 			t.Errorf("Expected error 'batch processor is closed', got %v", err)
 		}
+		// 哨兵错误可用 errors.Is 判断
+		if err := bp.Add("task3"); !errors.Is(err, asyncbatch.ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+		if err := bp.AddPriority("task4", true); !errors.Is(err, asyncbatch.ErrClosed) {
+			t.Errorf("Expected ErrClosed from AddPriority, got %v", err)
+		}
 	})
 
 	t.Run("UpperRatioTrigger", func(t *testing.T) {
@@ -441,6 +442,18 @@ func TestInvalidParameters(t *testing.T) {
 		}
 	})
 
+	t.Run("InvalidWorkerCounts", func(t *testing.T) {
+		for _, n := range []int{0, -1, -8, 9, 100} {
+			_, err := asyncbatch.NewBatchProcessor(
+				func([]string) {},
+				asyncbatch.WithNumWorkers(n),
+			)
+			if err == nil || !strings.Contains(err.Error(), "numWorkers must be between 1 and 8") {
+				t.Errorf("WithNumWorkers(%d): expected worker count error, got: %v", n, err)
+			}
+		}
+	})
+
 	t.Run("InvalidRatios", func(t *testing.T) {
 		_, err := asyncbatch.NewBatchProcessor(
 			func([]string) {},
@@ -451,6 +464,53 @@ func TestInvalidParameters(t *testing.T) {
 			t.Errorf("Expected ratio error, got: %v", err)
 		}
 	})
+
+	t.Run("QueueSizeBelowMaxSize", func(t *testing.T) {
+		_, err := asyncbatch.NewBatchProcessor(
+			func([]string) {},
+			asyncbatch.WithMaxSize(100),
+			asyncbatch.WithQueueSize(99),
+		)
+		if err == nil || !strings.Contains(err.Error(), "queueSize must be at least maxSize") {
+			t.Errorf("Expected queue size error, got: %v", err)
+		}
+	})
+}
+
+func TestWithQueueSize(t *testing.T) {
+	// 默认容量为 maxSize * numWorkers * 2
+	bp, err := asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithMaxSize(100), asyncbatch.WithNumWorkers(2))
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	bp.Shutdown()
+	if bp.TasksCap() != 400 {
+		t.Errorf("Expected default capacity 400, got %d", bp.TasksCap())
+	}
+
+	// 显式容量与 maxSize 无关
+	bp, err = asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithMaxSize(100), asyncbatch.WithNumWorkers(2), asyncbatch.WithQueueSize(150))
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	bp.Shutdown()
+	if bp.TasksCap() != 150 {
+		t.Errorf("Expected capacity 150, got %d", bp.TasksCap())
+	}
+
+	// 分区模式下每个分区队列使用该容量
+	bp, err = asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithMaxSize(100), asyncbatch.WithNumWorkers(3), asyncbatch.WithQueueSize(1000),
+		asyncbatch.WithPartitioner(func(n int) string { return fmt.Sprint(n) }))
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	bp.Shutdown()
+	if bp.TasksCap() != 3000 {
+		t.Errorf("Expected capacity 3000, got %d", bp.TasksCap())
+	}
 }
 
 func TestTinyRatios(t *testing.T) {
@@ -483,12 +543,8 @@ func TestTinyRatios(t *testing.T) {
 
 	// 生成 50 个唯一任务
 	for i := 0; i < totalTasks; i++ {
-		task := fmt.Sprintf("task%d", i)
-		for {
-			if err := bp.Add(task); err == nil {
-				break
-			}
-			time.Sleep(100 * time.Microsecond)
+		if err := bp.AddWait(context.Background(), fmt.Sprintf("task%d", i)); err != nil {
+			t.Fatalf("AddWait failed: %v", err)
 		}
 	}
 
@@ -566,6 +622,43 @@ func TestGetterMethods(t *testing.T) {
 	}
 }
 
+func TestThresholds(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxSize      int
+		upper, lower float64
+		wantUpper    int
+		wantLower    int
+	}{
+		{"defaults", 1000, 0.5, 0.1, 500, 100},
+		{"floor", 50, 0.7, 0.25, 35, 12},
+		{"tiny ratios", 1000, 0.001, 0.0005, 1, 1},
+		{"full upper", 10, 1.0, 0.1, 10, 1},
+		{"below one", 10, 0.05, 0.01, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp, err := asyncbatch.NewBatchProcessor(
+				func([]int) {},
+				asyncbatch.WithMaxSize(tt.maxSize),
+				asyncbatch.WithUpperRatio(tt.upper),
+				asyncbatch.WithLowerRatio(tt.lower),
+			)
+			if err != nil {
+				t.Fatalf("NewBatchProcessor failed: %v", err)
+			}
+			defer bp.Shutdown()
+
+			if got := bp.UpperThreshold(); got != tt.wantUpper {
+				t.Errorf("Expected UpperThreshold %d, got %d", tt.wantUpper, got)
+			}
+			if got := bp.LowerThreshold(); got != tt.wantLower {
+				t.Errorf("Expected LowerThreshold %d, got %d", tt.wantLower, got)
+			}
+		})
+	}
+}
+
 func BenchmarkBatchProcessor(b *testing.B) {
 	var mu sync.Mutex
 	var batchSizes []int
@@ -587,12 +680,7 @@ func BenchmarkBatchProcessor(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		for {
-			if err := bp.Add("task"); err == nil {
-				break
-			}
-			time.Sleep(10 * time.Microsecond)
-		}
+		bp.AddWait(context.Background(), "task")
 	}
 	b.StopTimer()
 
@@ -607,6 +695,29 @@ func BenchmarkBatchProcessor(b *testing.B) {
 }
 
 // 测试并发添加任务的正确性和完整性
+// BenchmarkSlowWorker measures end-to-end throughput of a single worker whose
+// batches each take a fixed time to process, e.g. a database round trip.
+func BenchmarkSlowWorker(b *testing.B) {
+	var wg sync.WaitGroup
+	bp, _ := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			// Busy-wait: time.Sleep is too coarse at this scale
+			for start := time.Now(); time.Since(start) < 200*time.Microsecond; {
+			}
+			wg.Add(-len(batch))
+		},
+		asyncbatch.WithMaxSize(100),
+	)
+	defer bp.Shutdown()
+
+	wg.Add(b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bp.AddWait(context.Background(), i)
+	}
+	wg.Wait()
+}
+
 func TestConcurrentAdd(t *testing.T) {
 	const numTasks = 5000
 	var mu sync.Mutex
@@ -633,12 +744,9 @@ func TestConcurrentAdd(t *testing.T) {
 	for i := 0; i < 5; i++ {
 		go func(start int) {
 			for j := 0; j < numTasks/5; j++ {
-				task := start*1000 + j
-				for {
-					if err := bp.Add(task); err == nil {
-						break
-					}
-					time.Sleep(time.Microsecond)
+				if err := bp.AddWait(context.Background(), start*1000+j); err != nil {
+					t.Errorf("AddWait failed: %v", err)
+					return
 				}
 			}
 		}(i)
@@ -675,6 +783,9 @@ func TestFullChannelBehavior(t *testing.T) {
 	if err == nil || !strings.Contains(err.Error(), "task channel is full") {
 		t.Errorf("Expected channel full error, got: %v", err)
 	}
+	if !errors.Is(err, asyncbatch.ErrChannelFull) {
+		t.Errorf("Expected ErrChannelFull, got: %v", err)
+	}
 
 	// 使用回调函数触发 Done
 	var wg sync.WaitGroup
@@ -828,3 +939,2401 @@ func assertWait(t *testing.T, wg *sync.WaitGroup, timeout time.Duration) {
 		t.Fatal("Test timed out")
 	}
 }
+
+// 测试批次发射速率限制（跨 worker 共享）
+func TestMaxBatchesPerSecond(t *testing.T) {
+	const (
+		rate       = 50.0
+		numBatches = 25
+	)
+	var mu sync.Mutex
+	var emitted []time.Time
+	var wg sync.WaitGroup
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			mu.Lock()
+			emitted = append(emitted, time.Now())
+			mu.Unlock()
+			wg.Add(-len(batch))
+		},
+		asyncbatch.WithMaxSize(2),
+		asyncbatch.WithUpperRatio(0.5), // 每个任务单独成批
+		asyncbatch.WithNumWorkers(4),
+		asyncbatch.WithMaxBatchesPerSecond(rate),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	wg.Add(numBatches)
+	tasks := make([]int, numBatches)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	addTasks(t, bp, tasks, 5*time.Second)
+	waitWithTimeout(t, &wg, 5*time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(emitted) != numBatches {
+		t.Fatalf("Expected %d batches, got %d", numBatches, len(emitted))
+	}
+	first, last := emitted[0], emitted[0]
+	for _, ts := range emitted {
+		if ts.Before(first) {
+			first = ts
+		}
+		if ts.After(last) {
+			last = ts
+		}
+	}
+	// n batches under the cap need at least (n-1)/rate seconds
+	minElapsed := time.Duration(float64(numBatches-1) / rate * float64(time.Second))
+	if elapsed := last.Sub(first); elapsed < minElapsed*9/10 {
+		t.Errorf("Emission too fast: %d batches in %v, expected at least %v", numBatches, elapsed, minElapsed)
+	}
+	if bp.MaxBatchesPerSecond() != rate {
+		t.Errorf("Expected MaxBatchesPerSecond %v, got %v", rate, bp.MaxBatchesPerSecond())
+	}
+}
+
+// 测试 ShutdownWithin 放弃后, 等待令牌的批次立即交给错误处理函数
+func TestMaxBatchesPerSecondShutdownWithin(t *testing.T) {
+	const numTasks = 10
+	var processed, abandoned atomic.Int64
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { processed.Add(int64(len(batch))) },
+		asyncbatch.WithMaxSize(2),
+		asyncbatch.WithUpperRatio(0.5), // 每个任务单独成批
+		asyncbatch.WithMaxBatchesPerSecond(2),
+		asyncbatch.WithQueueSize(numTasks),
+		asyncbatch.WithErrorHandler(func(batch []int, err error) {
+			abandoned.Add(int64(len(batch)))
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	for i := 0; i < numTasks; i++ {
+		if err := bp.Add(i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	// 按速率处理完需要约 4.5 秒
+	start := time.Now()
+	if err := bp.ShutdownWithin(300 * time.Millisecond); err == nil {
+		t.Fatal("Expected ShutdownWithin to give up")
+	}
+	for processed.Load()+abandoned.Load() < numTasks {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Rate limit waits not interrupted: %d processed, %d abandoned",
+				processed.Load(), abandoned.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if abandoned.Load() == 0 {
+		t.Error("Expected abandoned batches to reach the error handler")
+	}
+}
+
+func TestAddFlushMarker(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			mu.Lock()
+			batches = append(batches, append([]int(nil), batch...))
+			mu.Unlock()
+		},
+		asyncbatch.WithMaxSize(100),
+		asyncbatch.WithFixedWait(200*time.Millisecond), // 计时器不触发, 仅由标记切分批次
+		asyncbatch.WithUnderfilledWait(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	// 任务与刷新标记交错; 连续标记与开头的标记不产生空批次
+	steps := []int{-1, 1, 2, 3, -1, 4, 5, -1, -1, 6}
+	for _, v := range steps {
+		if v < 0 {
+			err = bp.AddFlushMarker()
+		} else {
+			err = bp.Add(v)
+		}
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(batches)
+		mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 剩余任务在 Shutdown 时提交; 标记在关闭后同样生效
+	bp.Shutdown()
+	if err := bp.AddFlushMarker(); err == nil {
+		t.Error("Expected error adding flush marker after shutdown")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := [][]int{{1, 2, 3}, {4, 5}, {6}}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("Expected batches %v, got %v", expected, batches)
+	}
+}
+
+func TestFlushMarkerDuringShutdownDrain(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	block := make(chan struct{})
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			if batch[0] == 0 {
+				<-block // 阻塞工作函数, 使后续任务留在队列中
+			}
+			mu.Lock()
+			batches = append(batches, append([]int(nil), batch...))
+			mu.Unlock()
+		},
+		asyncbatch.WithMaxSize(2),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithFixedWait(200*time.Millisecond),
+		asyncbatch.WithUnderfilledWait(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	// [0] 进入工作函数并阻塞; [1 2] 组批完成后等待交付; 其余任务留在队列中
+	for _, v := range []int{0, -1, 1, 2, 3, -1, 4} {
+		if v < 0 {
+			err = bp.AddFlushMarker()
+		} else {
+			err = bp.Add(v)
+		}
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if v == 2 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bp.Shutdown()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown timed out")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := [][]int{{0}, {1, 2}, {3}, {4}}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("Expected batches %v, got %v", expected, batches)
+	}
+}
+
+func TestShutdownWithin(t *testing.T) {
+	t.Run("HungWorker", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		bp, err := asyncbatch.NewBatchProcessor(
+			func(batch []int) {
+				started <- struct{}{}
+				<-release // 模拟卡死的工作函数
+			},
+			asyncbatch.WithMaxSize(100),
+			asyncbatch.WithUpperRatio(0.03), // 3 个任务即成批
+			asyncbatch.WithLowerRatio(0.01),
+		)
+		if err != nil {
+			t.Fatalf("NewBatchProcessor failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		<-started
+
+		start := time.Now()
+		err = bp.ShutdownWithin(100 * time.Millisecond)
+		elapsed := time.Since(start)
+		if err == nil {
+			t.Fatal("Expected deadline error from ShutdownWithin")
+		}
+		if elapsed > 500*time.Millisecond {
+			t.Errorf("ShutdownWithin returned after %v, expected about 100ms", elapsed)
+		}
+		if !strings.Contains(err.Error(), "shutdown deadline exceeded") {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		var te *errors.TracedError
+		if errors.As(err, &te) {
+			if sizes, _ := te.Context["stuck-batch-sizes"].([]int); len(sizes) != 1 || sizes[0] < 1 {
+				t.Errorf("Expected one stuck batch, got sizes %v", te.Context["stuck-batch-sizes"])
+			}
+		}
+
+		// 放开工作函数后, 后台的关闭流程完成
+		close(release)
+		done := make(chan struct{})
+		go func() {
+			bp.Shutdown()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown did not complete after worker was released")
+		}
+	})
+
+	t.Run("CompletesInTime", func(t *testing.T) {
+		var processed atomic.Int32
+		bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+			processed.Add(int32(len(batch)))
+		})
+		if err != nil {
+			t.Fatalf("NewBatchProcessor failed: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if err := bp.ShutdownWithin(time.Second); err != nil {
+			t.Fatalf("ShutdownWithin failed: %v", err)
+		}
+		if processed.Load() != 10 {
+			t.Errorf("Expected 10 tasks processed, got %d", processed.Load())
+		}
+	})
+}
+
+func TestDumpState(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var got []int
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release // 第一个批次卡住, 直到测试放开
+		mu.Lock()
+		got = append(got, batch...)
+		mu.Unlock()
+	},
+		asyncbatch.WithMaxSize(2), // 队列容量 4
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithLowerRatio(0.5),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	add := func(tasks ...int) {
+		for _, task := range tasks {
+			if err := bp.Add(task); err != nil {
+				t.Fatalf("Add(%d) failed: %v", task, err)
+			}
+		}
+	}
+
+	add(0, 1)
+	<-started // [0 1] 在工作函数中
+	add(2, 3)
+	// [2 3] 被批次组装协程取走, 等待交给卡住的处理协程
+	deadline := time.Now().Add(time.Second)
+	for bp.Stats().QueuedTasks > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	add(4, 5, 6, 7) // 队列已满
+	if err := bp.AddPriority(9, true); err != nil {
+		t.Fatalf("AddPriority failed: %v", err)
+	}
+	addWaitDone := make(chan error, 1)
+	go func() { addWaitDone <- bp.AddWait(context.Background(), 8) }()
+	time.Sleep(20 * time.Millisecond) // AddWait 阻塞在满队列上
+
+	dumped := make(chan struct{})
+	var pending []int
+	var inFlight [][]int
+	go func() {
+		pending, inFlight = bp.DumpState()
+		close(dumped)
+	}()
+	select {
+	case <-dumped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("DumpState blocked behind AddWait")
+	}
+	if !reflect.DeepEqual(pending, []int{9, 4, 5, 6, 7}) {
+		t.Errorf("Expected pending [9 4 5 6 7], got %v", pending)
+	}
+	if !reflect.DeepEqual(inFlight, [][]int{{0, 1}}) {
+		t.Errorf("Expected in-flight [[0 1]], got %v", inFlight)
+	}
+	if q := bp.Stats().QueuedTasks; q != 5 {
+		t.Errorf("Expected the 5 tasks back in the queues, got %d", q)
+	}
+
+	// 放开后所有任务都被处理, 普通队列顺序不变
+	close(release)
+	if err := <-addWaitDone; err != nil {
+		t.Errorf("AddWait failed: %v", err)
+	}
+	bp.Shutdown()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 10 {
+		t.Fatalf("Expected 10 processed tasks, got %v", got)
+	}
+	var normal []int
+	for _, task := range got {
+		if task >= 4 && task <= 7 {
+			normal = append(normal, task)
+		}
+	}
+	if !reflect.DeepEqual(normal, []int{4, 5, 6, 7}) {
+		t.Errorf("Queue order changed by DumpState: %v", got)
+	}
+
+	pending, inFlight = bp.DumpState()
+	if pending != nil || inFlight != nil {
+		t.Errorf("Expected empty dump after Shutdown, got %v %v", pending, inFlight)
+	}
+}
+
+func TestShutdownCtx(t *testing.T) {
+	newStuck := func(t *testing.T, opts ...asyncbatch.Option) (*asyncbatch.BatchProcessor[int], chan struct{}) {
+		release := make(chan struct{})
+		started := make(chan struct{}, 1)
+		opts = append([]asyncbatch.Option{
+			asyncbatch.WithMaxSize(100),
+			asyncbatch.WithUpperRatio(0.03), // 3 个任务即成批
+			asyncbatch.WithLowerRatio(0.01),
+		}, opts...)
+		bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+			select {
+			case started <- struct{}{}:
+			default:
+			}
+			<-release // 模拟卡死的工作函数
+		}, opts...)
+		if err != nil {
+			t.Fatalf("NewBatchProcessor failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		<-started
+		// 工作函数卡住时再入队 2 个任务
+		for i := 3; i < 5; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		return bp, release
+	}
+
+	t.Run("Deadline", func(t *testing.T) {
+		bp, release := newStuck(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		unprocessed := bp.ShutdownCtx(ctx)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("ShutdownCtx returned after %v, expected about 100ms", elapsed)
+		}
+		if unprocessed != 5 {
+			t.Errorf("Expected 5 unprocessed tasks, got %d", unprocessed)
+		}
+
+		// 放开后再次调用, 关闭流程完成, 无剩余任务
+		close(release)
+		if n := bp.ShutdownCtx(context.Background()); n != 0 {
+			t.Errorf("Expected 0 unprocessed after completion, got %d", n)
+		}
+		if got := bp.Stats().TotalTasksProcessed; got != 5 {
+			t.Errorf("Expected 5 processed tasks, got %d", got)
+		}
+	})
+
+	t.Run("ShutdownTimeout", func(t *testing.T) {
+		bp, release := newStuck(t)
+		err := bp.ShutdownTimeout(50 * time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), "shutdown timed out") {
+			t.Fatalf("Expected timeout error, got %v", err)
+		}
+		var te *errors.TracedError
+		if errors.As(err, &te) && te.Context["unprocessed"] != 5 {
+			t.Errorf("Expected 5 unprocessed tasks in the error, got %v", te.Context["unprocessed"])
+		}
+
+		// 超时足够长时, 所有任务都被处理, 没有丢失
+		close(release)
+		if err := bp.ShutdownTimeout(5 * time.Second); err != nil {
+			t.Errorf("Expected nil with a generous timeout, got %v", err)
+		}
+		if got := bp.Stats().TotalTasksProcessed; got != 5 {
+			t.Errorf("Expected 5 processed tasks, got %d", got)
+		}
+	})
+
+	t.Run("WithDrainTimeout", func(t *testing.T) {
+		bp, release := newStuck(t, asyncbatch.WithDrainTimeout(100*time.Millisecond))
+		defer close(release)
+
+		done := make(chan struct{})
+		go func() {
+			bp.Shutdown()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Shutdown with drain timeout did not return")
+		}
+	})
+
+	t.Run("CompletesInTime", func(t *testing.T) {
+		bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {})
+		if err != nil {
+			t.Fatalf("NewBatchProcessor failed: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if n := bp.ShutdownCtx(context.Background()); n != 0 {
+			t.Errorf("Expected 0 unprocessed, got %d", n)
+		}
+	})
+}
+
+func TestScaleWorkers(t *testing.T) {
+	var active, maxActive, processed atomic.Int32
+	var wg sync.WaitGroup
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			n := active.Add(1)
+			for {
+				m := maxActive.Load()
+				if n <= m || maxActive.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			active.Add(-1)
+			processed.Add(int32(len(batch)))
+			wg.Add(-len(batch))
+		},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithUpperRatio(0.5),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	tasks := make([]int, 400)
+	for i := range tasks {
+		tasks[i] = i
+	}
+
+	// 扩容: 任务流动时并发处理
+	if err := bp.ScaleWorkers(4); err != nil {
+		t.Fatalf("ScaleWorkers(4) failed: %v", err)
+	}
+	if bp.NumWorkers() != 4 {
+		t.Errorf("Expected 4 workers, got %d", bp.NumWorkers())
+	}
+	wg.Add(len(tasks))
+	addTasks(t, bp, tasks, 5*time.Second)
+	waitWithTimeout(t, &wg, 5*time.Second)
+	if maxActive.Load() < 2 {
+		t.Errorf("Expected concurrent processing after scaling up, max active %d", maxActive.Load())
+	}
+
+	// 缩容: 多余的 worker 完成当前批次后退出
+	if err := bp.ScaleWorkers(1); err != nil {
+		t.Fatalf("ScaleWorkers(1) failed: %v", err)
+	}
+	if bp.NumWorkers() != 1 {
+		t.Errorf("Expected 1 worker, got %d", bp.NumWorkers())
+	}
+	time.Sleep(50 * time.Millisecond)
+	maxActive.Store(0)
+	wg.Add(len(tasks))
+	addTasks(t, bp, tasks, 5*time.Second)
+	waitWithTimeout(t, &wg, 5*time.Second)
+	if maxActive.Load() != 1 {
+		t.Errorf("Expected serial processing after scaling down, max active %d", maxActive.Load())
+	}
+	if processed.Load() != 800 {
+		t.Errorf("Expected 800 tasks processed, got %d", processed.Load())
+	}
+
+	for _, n := range []int{0, 9, -1} {
+		if err := bp.ScaleWorkers(n); err == nil {
+			t.Errorf("Expected error for ScaleWorkers(%d)", n)
+		}
+	}
+	bp.Shutdown()
+	if err := bp.ScaleWorkers(2); err == nil {
+		t.Error("Expected error scaling a closed processor")
+	}
+}
+
+func TestWithBatchSizeObserver(t *testing.T) {
+	var mu sync.Mutex
+	var observed, processed []int
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			mu.Lock()
+			processed = append(processed, len(batch))
+			mu.Unlock()
+		},
+		asyncbatch.WithMaxSize(100),
+		asyncbatch.WithUpperRatio(0.5),
+		asyncbatch.WithFixedWait(200*time.Millisecond), // 计时器不触发
+		asyncbatch.WithUnderfilledWait(5*time.Second),
+		asyncbatch.WithBatchSizeObserver(func(size int) {
+			mu.Lock()
+			observed = append(observed, size)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	// 60 个任务: 达到 upperRatio 时提交 50, 标记提交剩余 10;
+	// 3 个任务后标记; 最后 7 个在 Shutdown 时提交
+	add := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+	}
+	add(60)
+	bp.AddFlushMarker()
+	add(3)
+	bp.AddFlushMarker()
+	add(7)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(processed)
+		mu.Unlock()
+		if n >= 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []int{50, 10, 3, 7}
+	if !reflect.DeepEqual(observed, expected) {
+		t.Errorf("Expected observed sizes %v, got %v", expected, observed)
+	}
+	if !reflect.DeepEqual(processed, observed) {
+		t.Errorf("Observed sizes %v differ from processed batches %v", observed, processed)
+	}
+
+	// nil 观察者被忽略
+	bp2, err := asyncbatch.NewBatchProcessor(func([]int) {}, asyncbatch.WithBatchSizeObserver(nil))
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	bp2.Add(1)
+	bp2.Shutdown()
+}
+
+func TestWithBatchObserver(t *testing.T) {
+	type flush struct {
+		size    int
+		trigger string
+	}
+	var mu sync.Mutex
+	var observed []flush
+	bp, err := asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithUpperRatio(0.5), // upperThreshold = 5
+		asyncbatch.WithLowerRatio(0.3), // lowerThreshold = 3
+		asyncbatch.WithFixedWait(20*time.Millisecond),
+		asyncbatch.WithUnderfilledWait(300*time.Millisecond),
+		asyncbatch.WithBatchObserver(func(size int, trigger string) {
+			mu.Lock()
+			observed = append(observed, flush{size, trigger})
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	idle := func() {
+		t.Helper()
+		if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+			t.Fatalf("WaitForIdle failed: %v", err)
+		}
+	}
+
+	addTasks(t, bp, make([]int, 5), time.Second) // 达到 upperRatio
+	idle()
+	addTasks(t, bp, make([]int, 4), time.Second) // 等待结束时不低于 lowerThreshold
+	idle()
+	addTasks(t, bp, make([]int, 1), time.Second) // 欠填充超时
+	idle()
+	addTasks(t, bp, make([]int, 2), time.Second) // 刷新标记
+	if err := bp.AddFlushMarker(); err != nil {
+		t.Fatalf("AddFlushMarker failed: %v", err)
+	}
+	idle()
+	if err := bp.SetUpperRatio(1); err != nil { // 达到 maxSize
+		t.Fatalf("SetUpperRatio failed: %v", err)
+	}
+	addTasks(t, bp, make([]int, 10), time.Second)
+	idle()
+	addTasks(t, bp, make([]int, 2), time.Second)
+	time.Sleep(50 * time.Millisecond) // 两个任务都已进入批次, 欠填充等待期间关闭
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []flush{
+		{5, asyncbatch.TriggerUpperRatio},
+		{4, asyncbatch.TriggerLowerThreshold},
+		{1, asyncbatch.TriggerUnderfilledTimeout},
+		{2, asyncbatch.TriggerFlush},
+		{10, asyncbatch.TriggerFull},
+		{2, asyncbatch.TriggerShutdown},
+	}
+	if !reflect.DeepEqual(observed, expected) {
+		t.Errorf("Expected flushes %v, got %v", expected, observed)
+	}
+}
+
+func TestWithAdaptiveWait(t *testing.T) {
+	const minWait, maxWait = 2 * time.Millisecond, 20 * time.Millisecond
+	var processed atomic.Int32
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { processed.Add(int32(len(batch))) },
+		asyncbatch.WithMaxSize(100),
+		asyncbatch.WithUpperRatio(0.5),
+		asyncbatch.WithLowerRatio(0.01), // 单个任务的批次在等待结束后即提交
+		asyncbatch.WithAdaptiveWait(minWait, maxWait),
+		asyncbatch.WithUnderfilledWait(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	initial := bp.EffectiveWait()
+	if initial <= minWait || initial >= maxWait {
+		t.Errorf("Expected initial wait strictly between bounds, got %v", initial)
+	}
+
+	// 突发负载: 任务间隔极短, 等待时间缩短至下限附近
+	burst := func(n int) time.Duration {
+		processed.Store(0)
+		addTasks(t, bp, make([]int, n), 5*time.Second)
+		deadline := time.Now().Add(5 * time.Second)
+		for processed.Load() < int32(n) && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return bp.EffectiveWait()
+	}
+	bursty := burst(2000)
+	if bursty >= initial || bursty > minWait+2*time.Millisecond {
+		t.Errorf("Expected wait near %v after burst, got %v (initial %v)", minWait, bursty, initial)
+	}
+
+	// 空闲: 没有任务到达, 计时器到期使等待时间增长至上限附近
+	time.Sleep(400 * time.Millisecond)
+	idle := bp.EffectiveWait()
+	if idle < maxWait-3*time.Millisecond {
+		t.Errorf("Expected wait near %v when idle, got %v", maxWait, idle)
+	}
+
+	// 空闲后的突发: 等待时间迅速回落
+	if again := burst(200); again > minWait+2*time.Millisecond {
+		t.Errorf("Expected wait near %v after a burst following idle, got %v", minWait, again)
+	}
+
+	// 稀疏负载: 任务间隔超过上限, 等待时间增长至上限附近
+	for i := 0; i < 25; i++ {
+		bp.Add(i)
+		time.Sleep(maxWait + 10*time.Millisecond)
+	}
+	sparse := bp.EffectiveWait()
+	if sparse < maxWait-3*time.Millisecond {
+		t.Errorf("Expected wait near %v after sparse load, got %v", maxWait, sparse)
+	}
+
+	// 等待时间只由到达速率决定, 与批次填充率无关: 任务到达缓慢时即使批次满也接近上限
+	var sizesMu sync.Mutex
+	var sizes []int
+	full, err := asyncbatch.NewBatchProcessor(
+		func([]int) {},
+		asyncbatch.WithMaxSize(2),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithLowerRatio(1), // 单个任务的批次等待 underfilledWait, 期间下一个任务到达使批次满
+		asyncbatch.WithAdaptiveWait(minWait, maxWait),
+		asyncbatch.WithUnderfilledWait(50*time.Millisecond),
+		asyncbatch.WithBatchSizeObserver(func(size int) {
+			sizesMu.Lock()
+			sizes = append(sizes, size)
+			sizesMu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer full.Shutdown()
+	for i := 0; i < 24; i++ {
+		full.Add(i)
+		time.Sleep(maxWait + 10*time.Millisecond)
+	}
+	sizesMu.Lock()
+	if len(sizes) == 0 {
+		t.Error("Expected full batches, got none")
+	}
+	for _, size := range sizes {
+		if size != 2 {
+			t.Errorf("Expected only full batches, got sizes %v", sizes)
+			break
+		}
+	}
+	sizesMu.Unlock()
+	if slow := full.EffectiveWait(); slow < maxWait-3*time.Millisecond {
+		t.Errorf("Expected wait near %v for full batches of slow tasks, got %v", maxWait, slow)
+	}
+
+	// 非法参数
+	for _, bounds := range [][2]time.Duration{{0, maxWait}, {maxWait, minWait}, {minWait, time.Second}} {
+		_, err := asyncbatch.NewBatchProcessor(func([]int) {}, asyncbatch.WithAdaptiveWait(bounds[0], bounds[1]))
+		if err == nil {
+			t.Errorf("Expected error for adaptive wait bounds %v", bounds)
+		}
+	}
+
+	// 默认使用固定等待
+	fixed, _ := asyncbatch.NewBatchProcessor(func([]int) {})
+	defer fixed.Shutdown()
+	if fixed.EffectiveWait() != fixed.FixedWait() {
+		t.Errorf("Expected fixed wait %v, got %v", fixed.FixedWait(), fixed.EffectiveWait())
+	}
+}
+
+func TestAddPriority(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	gate := make(chan struct{})
+	var wg sync.WaitGroup
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			<-gate
+			mu.Lock()
+			batches = append(batches, append([]int(nil), batch...))
+			mu.Unlock()
+			wg.Add(-len(batch))
+		},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithUpperRatio(1.0),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+	var gateOnce sync.Once
+	openGate := func() { gateOnce.Do(func() { close(gate) }) }
+	defer openGate()
+
+	// 积压 40 个普通任务, worker 阻塞
+	wg.Add(41)
+	tasks := make([]int, 40)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	addTasks(t, bp, tasks, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+
+	// 高优先级任务插队
+	if err := bp.AddPriority(-1, true); err != nil {
+		t.Fatalf("AddPriority(high) failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	openGate()
+	waitWithTimeout(t, &wg, 5*time.Second)
+
+	mu.Lock()
+	defer mu.Unlock()
+	normalBefore := 0
+	for _, batch := range batches {
+		if len(batch) == 1 && batch[0] == -1 {
+			break
+		}
+		normalBefore += len(batch)
+	}
+	// 至多两个已成形的普通批次 (处理中 + 交接中) 先于高优先级任务
+	if normalBefore > 20 {
+		t.Errorf("Expected high-priority task ahead of the backlog, %d normal tasks processed before it: %v", normalBefore, batches)
+	}
+	if normalBefore == 40 {
+		t.Errorf("High-priority task did not jump the queue: %v", batches)
+	}
+}
+
+func TestPriorityFairness(t *testing.T) {
+	var normal, high atomic.Int32
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			for _, v := range batch {
+				if v < 0 {
+					high.Add(1)
+				} else {
+					normal.Add(1)
+				}
+			}
+		},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithPriorityFairness(1),
+		asyncbatch.WithHighPriorityWait(50*time.Microsecond),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 持续的高优先级流下普通任务仍被处理
+	for i := 0; i < 5; i++ {
+		bp.Add(i)
+	}
+	stop := time.After(300 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case <-stop:
+			done = true
+		default:
+			bp.AddPriority(-1, true)
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+	if normal.Load() != 5 {
+		t.Errorf("Expected 5 normal tasks processed under high-priority load, got %d (high %d)", normal.Load(), high.Load())
+	}
+}
+
+func TestNewBatchProcessorCtx(t *testing.T) {
+	// nil 工作函数报错
+	if _, err := asyncbatch.NewBatchProcessorCtx[int](nil); err == nil {
+		t.Error("Expected error for nil worker")
+	}
+
+	// 工作函数的错误交给错误回调
+	var mu sync.Mutex
+	var errs []error
+	bp, err := asyncbatch.NewBatchProcessorCtx(
+		func(ctx context.Context, batch []int) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed %d", len(batch))
+		},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithErrorHandler(func(batch []int, err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorCtx failed: %v", err)
+	}
+	addTasks(t, bp, []int{1, 2, 3}, time.Second)
+	bp.Shutdown()
+
+	mu.Lock()
+	if len(errs) == 0 {
+		t.Fatal("Expected worker errors to reach the error handler")
+	}
+	for _, e := range errs {
+		if !strings.Contains(fmt.Sprintf("%+v", e), "failed") {
+			t.Errorf("Expected wrapped worker error, got %+v", e)
+		}
+	}
+	mu.Unlock()
+
+	// Shutdown 后宽限期结束时取消上下文
+	started := make(chan struct{})
+	var ctxErr atomic.Value
+	bp2, err := asyncbatch.NewBatchProcessorCtx(
+		func(ctx context.Context, batch []int) error {
+			close(started)
+			<-ctx.Done()
+			ctxErr.Store(ctx.Err())
+			return ctx.Err()
+		},
+		asyncbatch.WithGracePeriod(50*time.Millisecond),
+		asyncbatch.WithErrorHandler(func([]int, error) {}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorCtx failed: %v", err)
+	}
+	bp2.Add(1)
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Worker not started")
+	}
+
+	start := time.Now()
+	if err := bp2.ShutdownWithin(2 * time.Second); err != nil {
+		t.Fatalf("Shutdown did not complete after grace period: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Context cancelled before grace period: %v", elapsed)
+	}
+	if got, _ := ctxErr.Load().(error); got != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	gate := make(chan struct{})
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { <-gate },
+		asyncbatch.WithMaxSize(5),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithNumWorkers(2),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	var openOnce sync.Once
+	openGate := func() { openOnce.Do(func() { close(gate) }) }
+	defer openGate()
+
+	stats := bp.Stats()
+	if stats.Capacity != bp.TasksCap() || stats.CurrentWorkers != 2 {
+		t.Errorf("Unexpected initial stats: %+v", stats)
+	}
+
+	// 两个工作函数阻塞, 每个 goroutine 再各持一个批次, 其余任务留在队列
+	tasks := make([]int, 30)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	addTasks(t, bp, tasks, time.Second)
+	deadline := time.Now().Add(2 * time.Second)
+	for bp.Stats().InFlightBatches < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats = bp.Stats()
+	if stats.InFlightBatches != 2 {
+		t.Errorf("Expected 2 in-flight batches, got %+v", stats)
+	}
+	if stats.QueuedTasks == 0 || stats.TotalTasksProcessed != 0 {
+		t.Errorf("Expected queued and no processed tasks, got %+v", stats)
+	}
+	// 每个 goroutine 再各持一个待交付批次后, 队列中剩余 30 - 4*5 = 10 个任务
+	for bp.QueueDepth() > 10 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if depth := bp.QueueDepth(); depth != 10 {
+		t.Errorf("Expected queue depth 10, got %d", depth)
+	}
+
+	openGate()
+	bp.Shutdown()
+	stats = bp.Stats()
+	if stats.QueuedTasks != 0 || stats.InFlightBatches != 0 || stats.TotalTasksProcessed != 30 {
+		t.Errorf("Unexpected stats after shutdown: %+v", stats)
+	}
+	if depth := bp.QueueDepth(); depth != 0 {
+		t.Errorf("Expected queue depth 0 after shutdown, got %d", depth)
+	}
+	// 2 个阻塞批次之外的任务至少还需 1 个批次 (Shutdown 会合并队列剩余任务)
+	if stats.TotalBatchesFlushed < 3 {
+		t.Errorf("Expected at least 3 batches, got %d", stats.TotalBatchesFlushed)
+	}
+}
+
+func TestAddWait(t *testing.T) {
+	gate := make(chan struct{})
+	var processed atomic.Int64
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			<-gate
+			processed.Add(int64(len(batch)))
+		},
+		asyncbatch.WithMaxSize(5),
+		asyncbatch.WithUpperRatio(1),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	var openOnce sync.Once
+	openGate := func() { openOnce.Do(func() { close(gate) }) }
+	defer openGate()
+
+	// 工作函数阻塞, 填满队列 (容量 10, 另有两个批次在 goroutine 中)
+	total := 0
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err := bp.AddWait(ctx, total)
+		cancel()
+		if err != nil {
+			if err != context.DeadlineExceeded {
+				t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+			}
+			break
+		}
+		total++
+		if total > 100 {
+			t.Fatal("Queue never filled up")
+		}
+	}
+	if err := bp.Add(-1); err == nil {
+		t.Fatal("Expected Add to fail on a full queue")
+	}
+
+	// 阻塞的 AddWait 在有空间后成功
+	result := make(chan error, 1)
+	go func() { result <- bp.AddWait(context.Background(), total) }()
+	select {
+	case err := <-result:
+		t.Fatalf("AddWait returned before room was available: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	openGate()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("AddWait failed: %v", err)
+		}
+		total++
+	case <-time.After(2 * time.Second):
+		t.Fatal("AddWait still blocked after the queue drained")
+	}
+
+	bp.Shutdown()
+	if got := processed.Load(); got != int64(total) {
+		t.Errorf("Expected %d processed tasks, got %d", total, got)
+	}
+	if err := bp.AddWait(context.Background(), 0); err == nil {
+		t.Error("Expected error after Shutdown")
+	}
+}
+
+func TestAddWaitDuringShutdown(t *testing.T) {
+	gate := make(chan struct{})
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { <-gate },
+		asyncbatch.WithMaxSize(5),
+		asyncbatch.WithUpperRatio(1),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	// 填满队列后, 阻塞的 AddWait 在 Shutdown 时返回关闭错误
+	for bp.AddWait(ctxWithTimeout(t, 50*time.Millisecond), 1) == nil {
+	}
+	result := make(chan error, 1)
+	go func() { result <- bp.AddWait(context.Background(), 2) }()
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		bp.Shutdown()
+		close(done)
+	}()
+	select {
+	case err := <-result:
+		if err == nil || !strings.Contains(err.Error(), "closed") {
+			t.Errorf("Expected closed error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("AddWait still blocked after Shutdown")
+	}
+	close(gate)
+	<-done
+}
+
+// ctxWithTimeout returns a context cancelled after d or at the end of the test.
+func ctxWithTimeout(t *testing.T, d time.Duration) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestWithContext(t *testing.T) {
+	var processed atomic.Int64
+	ctx, cancel := context.WithCancel(context.Background())
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { processed.Add(int64(len(batch))) },
+		asyncbatch.WithContext(ctx),
+		asyncbatch.WithFixedWait(200*time.Millisecond), // 任务留在队列中直到取消
+		asyncbatch.WithUnderfilledWait(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	addTasks(t, bp, []int{1, 2, 3, 4, 5}, time.Second)
+
+	// 取消后拒绝新任务, 并自动关闭, 排空已排队任务
+	cancel()
+	if err := bp.Add(6); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Expected closed error after cancel, got %v", err)
+	}
+	if err := bp.AddWait(context.Background(), 7); err == nil {
+		t.Error("Expected AddWait to fail after cancel")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// 显式 Shutdown 与自动关闭并发, 只执行一次
+		bp.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown did not complete after cancel")
+	}
+	if got := processed.Load(); got != 5 {
+		t.Errorf("Expected 5 drained tasks, got %d", got)
+	}
+
+	// 未调用 Shutdown 时, 取消也会停止处理器
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	var processed2 atomic.Int64
+	bp2, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { processed2.Add(int64(len(batch))) },
+		asyncbatch.WithContext(ctx2),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	bp2.Add(1)
+	cancel2()
+	deadline := time.Now().Add(2 * time.Second)
+	for processed2.Load() != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if processed2.Load() != 1 {
+		t.Errorf("Expected queued task processed after cancel, got %d", processed2.Load())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	batches := make(chan []int, 10)
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) { batches <- batch },
+		asyncbatch.WithMaxSize(100),
+		asyncbatch.WithFixedWait(time.Second), // 不主动提交
+		asyncbatch.WithUnderfilledWait(5*time.Second),
+		asyncbatch.WithNumWorkers(2),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	addTasks(t, bp, []int{1, 2, 3}, time.Second)
+	time.Sleep(20 * time.Millisecond) // 等待任务进入正在形成的批次
+
+	// Flush 立即提交低于阈值的批次, 处理器继续运行
+	start := time.Now()
+	if err := bp.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	got := 0
+	for got < 3 {
+		select {
+		case b := <-batches:
+			got += len(b)
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("Flushed batches not processed, got %d tasks", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Flush took %v", elapsed)
+	}
+	if err := bp.Add(4); err != nil {
+		t.Errorf("Add after Flush failed: %v", err)
+	}
+
+	// 已取消的上下文
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bp.Flush(ctx); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	bp.Shutdown()
+	if err := bp.Flush(context.Background()); err == nil {
+		t.Error("Expected error from Flush after Shutdown")
+	}
+}
+
+func TestFlushQueued(t *testing.T) {
+	gate := make(chan struct{})
+	var mu sync.Mutex
+	var sizes []int
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			<-gate
+			mu.Lock()
+			sizes = append(sizes, len(batch))
+			mu.Unlock()
+		},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithFixedWait(time.Second),
+		asyncbatch.WithUnderfilledWait(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 工作函数阻塞时任务积压在队列中; Flush 将其按 maxSize 全部提交
+	tasks := make([]int, 25)
+	for i := range tasks {
+		tasks[i] = i
+	}
+	addTasks(t, bp, tasks, time.Second)
+	close(gate)
+	if err := bp.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if q := bp.Stats().QueuedTasks; q != 0 {
+		t.Errorf("Expected empty queue after Flush, got %d", q)
+	}
+
+	// 可重复调用, 处理器保持运行
+	for i := 0; i < 3; i++ {
+		if err := bp.Add(100 + i); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if err := bp.Flush(context.Background()); err != nil {
+			t.Fatalf("Flush %d failed: %v", i, err)
+		}
+	}
+
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	total := 0
+	for _, n := range sizes {
+		if n > 10 {
+			t.Errorf("Batch of %d exceeds maxSize", n)
+		}
+		total += n
+	}
+	if total != 28 {
+		t.Errorf("Expected 28 processed tasks, got %d (batches %v)", total, sizes)
+	}
+}
+
+func TestNewBatchProcessorE(t *testing.T) {
+	var mu sync.Mutex
+	var failed [][]int
+	var failedErrs []error
+	errOdd := errors.E("odd task")
+	bp, err := asyncbatch.NewBatchProcessorE(
+		func(batch []int) error {
+			for _, v := range batch {
+				if v%2 == 1 {
+					return errOdd
+				}
+			}
+			return nil
+		},
+		asyncbatch.WithMaxSize(1),
+		asyncbatch.WithUpperRatio(1), // 每个任务一个批次
+		asyncbatch.WithErrorHandler(func(batch []int, err error) {
+			mu.Lock()
+			failed = append(failed, batch)
+			failedErrs = append(failedErrs, err)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorE failed: %v", err)
+	}
+	addTasks(t, bp, []int{1, 2, 3, 4}, time.Second)
+	// 等待处理完成, 避免 Shutdown 合并剩余任务
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	got := []int{}
+	for _, b := range failed {
+		got = append(got, b...)
+	}
+	if !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("Expected failing batches [1] [3], got %v", failed)
+	}
+	for _, e := range failedErrs {
+		if e != errOdd {
+			t.Errorf("Expected the worker's error, got %v", e)
+		}
+	}
+
+	// 处理函数类型与任务类型不符
+	_, err = asyncbatch.NewBatchProcessorE(
+		func([]int) error { return nil },
+		asyncbatch.WithErrorHandler(func([]string, error) {}),
+	)
+	if err == nil {
+		t.Error("Expected error for mismatched handler type")
+	}
+	if _, err := asyncbatch.NewBatchProcessorE[int](nil); err == nil {
+		t.Error("Expected error for nil worker")
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	var mu sync.Mutex
+	attempts := map[int]int{}
+	var failed []int
+	bp, err := asyncbatch.NewBatchProcessorE(
+		func(batch []int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			v := batch[0]
+			attempts[v]++
+			// 任务 1 第 2 次成功, 任务 2 始终失败
+			if (v == 1 && attempts[v] < 2) || v == 2 {
+				return errors.E("transient")
+			}
+			return nil
+		},
+		asyncbatch.WithMaxSize(1),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithNumWorkers(2),
+		asyncbatch.WithRetry(3, 20*time.Millisecond),
+		asyncbatch.WithErrorHandler(func(batch []int, err error) {
+			mu.Lock()
+			failed = append(failed, batch...)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorE failed: %v", err)
+	}
+	start := time.Now()
+	addTasks(t, bp, []int{1, 2, 3}, time.Second)
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	elapsed := time.Since(start)
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts[1] != 2 || attempts[2] != 3 || attempts[3] != 1 {
+		t.Errorf("Unexpected attempts %v", attempts)
+	}
+	// 只有重试耗尽的批次交给错误处理函数
+	if !reflect.DeepEqual(failed, []int{2}) {
+		t.Errorf("Expected only [2] to fail, got %v", failed)
+	}
+	if elapsed < 40*time.Millisecond {
+		t.Errorf("Expected two backoffs of 20ms, took %v", elapsed)
+	}
+}
+
+func TestStatsCounters(t *testing.T) {
+	bp, err := asyncbatch.NewBatchProcessor(
+		func([]int) {},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithLowerRatio(0.5), // LowerThreshold 5
+		asyncbatch.WithFixedWait(time.Millisecond),
+		asyncbatch.WithUnderfilledWait(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 2 个任务低于下限, 等待 underfilledWait 后提交
+	addTasks(t, bp, []int{1, 2}, time.Second)
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	stats := bp.Stats()
+	if stats.TotalTasksAdded != 2 || stats.TotalBatchesFlushed != 1 || stats.AvgBatchSize != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.UnderfilledFlushes != 1 {
+		t.Errorf("Expected 1 underfilled flush, got %+v", stats)
+	}
+
+	// 队列满时拒绝的任务计入 TotalTasksDropped
+	gate := make(chan struct{})
+	bp2, err := asyncbatch.NewBatchProcessor(func([]int) { <-gate }, asyncbatch.WithMaxSize(5))
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	added, dropped := 0, 0
+	for i := 0; i < 100; i++ {
+		if bp2.Add(i) == nil {
+			added++
+		} else {
+			dropped++
+		}
+	}
+	close(gate)
+	bp2.Shutdown()
+	stats = bp2.Stats()
+	if dropped == 0 || stats.TotalTasksDropped != int64(dropped) || stats.TotalTasksAdded != int64(added) {
+		t.Errorf("Expected %d added and %d dropped, got %+v", added, dropped, stats)
+	}
+}
+
+func TestWithBatchRetry(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	failed := make(chan []int, 1)
+	bp, err := asyncbatch.NewBatchProcessorE(
+		func(batch []int) error {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+			return errors.E("downstream unavailable")
+		},
+		asyncbatch.WithBatchRetry(4, 20*time.Millisecond),
+		asyncbatch.WithErrorHandler(func(batch []int, err error) { failed <- batch }),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorE failed: %v", err)
+	}
+	bp.Add(1)
+	select {
+	case <-failed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Batch never reached the error handler")
+	}
+	bp.Shutdown()
+
+	// 4 次尝试, 间隔 20ms, 40ms, 80ms
+	mu.Lock()
+	if len(times) != 4 {
+		t.Fatalf("Expected 4 attempts, got %d", len(times))
+	}
+	for i, want := range []time.Duration{20, 40, 80} {
+		gap := times[i+1].Sub(times[i])
+		if gap < want*time.Millisecond || gap > (want+50)*time.Millisecond {
+			t.Errorf("Gap %d: expected about %dms, got %v", i, want, gap)
+		}
+	}
+	mu.Unlock()
+
+	// Shutdown 放弃等待中的重试
+	var attempts atomic.Int64
+	bp2, err := asyncbatch.NewBatchProcessorE(
+		func(batch []int) error {
+			attempts.Add(1)
+			return errors.E("down")
+		},
+		asyncbatch.WithBatchRetry(5, time.Hour),
+		asyncbatch.WithErrorHandler(func([]int, error) {}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorE failed: %v", err)
+	}
+	bp2.Add(1)
+	for attempts.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := bp2.ShutdownWithin(time.Second); err != nil {
+		t.Fatalf("Shutdown blocked by pending retry: %v", err)
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts.Load())
+	}
+}
+
+func TestWithPartitioner(t *testing.T) {
+	type event struct {
+		key string
+		seq int
+	}
+
+	var mu sync.Mutex
+	active := make(map[string]bool) // 正在处理中的 key
+	var processed []event
+	overlap := false
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []event) {
+		keys := make(map[string]bool)
+		mu.Lock()
+		for _, e := range batch {
+			if active[e.key] {
+				overlap = true // 同一 key 的两个批次被并发处理
+			}
+			keys[e.key] = true
+		}
+		for k := range keys {
+			active[k] = true
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		for k := range keys {
+			delete(active, k)
+		}
+		processed = append(processed, batch...)
+		mu.Unlock()
+	},
+		asyncbatch.WithNumWorkers(4),
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithPartitioner(func(e event) string { return e.key }),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	const perKey = 200
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	ctx := ctxWithTimeout(t, 5*time.Second)
+	for i := 0; i < perKey; i++ {
+		for _, k := range keys {
+			if err := bp.AddWait(ctx, event{key: k, seq: i}); err != nil {
+				t.Fatalf("AddWait failed: %v", err)
+			}
+		}
+		if i == perKey/2 {
+			if err := bp.AddFlushMarker(); err != nil {
+				t.Errorf("AddFlushMarker failed: %v", err)
+			}
+		}
+	}
+
+	// 分区模式不支持扩缩容和高优先级任务
+	if err := bp.ScaleWorkers(2); err == nil {
+		t.Error("Expected ScaleWorkers to fail with a partitioner")
+	}
+	if err := bp.AddPriority(event{key: "a"}, true); err == nil {
+		t.Error("Expected high-priority AddPriority to fail with a partitioner")
+	}
+	if got := bp.TasksCap(); got != 4*10*2 {
+		t.Errorf("Expected total queue capacity 80, got %d", got)
+	}
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if overlap {
+		t.Error("Batches of the same key were processed concurrently")
+	}
+	if len(processed) != perKey*len(keys) {
+		t.Fatalf("Expected %d processed events, got %d", perKey*len(keys), len(processed))
+	}
+	next := make(map[string]int)
+	for _, e := range processed {
+		if e.seq != next[e.key] {
+			t.Fatalf("Key %s: expected seq %d, got %d", e.key, next[e.key], e.seq)
+		}
+		next[e.key]++
+	}
+
+	// 分区函数的任务类型必须匹配
+	_, err = asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithPartitioner(func(s string) string { return s }))
+	if err == nil {
+		t.Error("Expected an error for a partitioner of another task type")
+	}
+}
+
+func TestWithIntPartitioner(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	},
+		asyncbatch.WithNumWorkers(3),
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithIntPartitioner(func(n int) int { return n }),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	ctx := ctxWithTimeout(t, 5*time.Second)
+	for n := -30; n < 90; n++ { // 负数键也映射到有效分区
+		if err := bp.AddWait(ctx, n); err != nil {
+			t.Fatalf("AddWait failed: %v", err)
+		}
+	}
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// 每个批次只含同一分区 (n mod 3) 的任务, 且各分区按提交顺序处理
+	total := 0
+	last := map[int]int{0: -31, 1: -31, 2: -31}
+	for _, batch := range batches {
+		p := (batch[0]%3 + 3) % 3
+		for _, n := range batch {
+			if (n%3+3)%3 != p {
+				t.Fatalf("Batch %v mixes partitions", batch)
+			}
+			if n <= last[p] {
+				t.Fatalf("Partition %d: %d processed after %d", p, n, last[p])
+			}
+			last[p] = n
+		}
+		total += len(batch)
+	}
+	if total != 120 {
+		t.Errorf("Expected 120 processed tasks, got %d", total)
+	}
+
+	_, err = asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithIntPartitioner(func(s string) int { return len(s) }))
+	if err == nil {
+		t.Error("Expected an error for a partitioner of another task type")
+	}
+}
+
+func TestWaitForIdle(t *testing.T) {
+	var processed atomic.Int64
+	release := make(chan struct{})
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		<-release
+		time.Sleep(2 * time.Millisecond)
+		processed.Add(int64(len(batch)))
+	},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithNumWorkers(2),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 空闲的处理器立即返回
+	if err := bp.WaitForIdle(ctxWithTimeout(t, time.Second)); err != nil {
+		t.Fatalf("WaitForIdle on an idle processor failed: %v", err)
+	}
+
+	tasks := make([]int, 53) // 最后一批低于下限, 需等待 underfilledWait
+	addTasks(t, bp, tasks, time.Second)
+
+	// 工作函数卡住时超时返回
+	err = bp.WaitForIdle(ctxWithTimeout(t, 30*time.Millisecond))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(release)
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 5*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	if got := processed.Load(); got != 53 {
+		t.Errorf("Expected 53 tasks processed before WaitForIdle returned, got %d", got)
+	}
+}
+
+func TestSetMaxSize(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		mu.Lock()
+		sizes = append(sizes, len(batch))
+		mu.Unlock()
+	},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithLowerRatio(0.1),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	collect := func() []int {
+		if err := bp.WaitForIdle(ctxWithTimeout(t, 5*time.Second)); err != nil {
+			t.Fatalf("WaitForIdle failed: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		got := sizes
+		sizes = nil
+		return got
+	}
+
+	addTasks(t, bp, make([]int, 30), time.Second)
+	if got := collect(); !reflect.DeepEqual(got, []int{10, 10, 10}) {
+		t.Errorf("Expected batches of 10, got %v", got)
+	}
+
+	// 运行时缩小批次, 下一批次生效
+	if err := bp.SetMaxSize(4); err != nil {
+		t.Fatalf("SetMaxSize failed: %v", err)
+	}
+	if bp.MaxSize() != 4 || bp.UpperThreshold() != 4 {
+		t.Errorf("Expected maxSize 4 and upper threshold 4, got %d and %d", bp.MaxSize(), bp.UpperThreshold())
+	}
+	addTasks(t, bp, make([]int, 12), time.Second)
+	if got := collect(); !reflect.DeepEqual(got, []int{4, 4, 4}) {
+		t.Errorf("Expected batches of 4, got %v", got)
+	}
+
+	// 调整比例
+	if err := bp.SetUpperRatio(0.5); err != nil {
+		t.Fatalf("SetUpperRatio failed: %v", err)
+	}
+	if err := bp.SetLowerRatio(0.5); err != nil {
+		t.Fatalf("SetLowerRatio failed: %v", err)
+	}
+	if bp.UpperThreshold() != 2 || bp.LowerThreshold() != 2 {
+		t.Errorf("Expected thresholds 2/2, got %d/%d", bp.UpperThreshold(), bp.LowerThreshold())
+	}
+
+	// 非法值被拒绝, 当前设置不变
+	for name, err := range map[string]error{
+		"SetMaxSize(0)":      bp.SetMaxSize(0),
+		"SetUpperRatio(0)":   bp.SetUpperRatio(0),
+		"SetLowerRatio(1.5)": bp.SetLowerRatio(1.5),
+		"SetUpperRatio(0.2)": bp.SetUpperRatio(0.2), // 低于下限比例
+		"SetLowerRatio(0.9)": bp.SetLowerRatio(0.9), // 高于上限比例
+	} {
+		if err == nil {
+			t.Errorf("Expected %s to fail", name)
+		}
+	}
+	if bp.MaxSize() != 4 || bp.UpperRatio() != 0.5 || bp.LowerRatio() != 0.5 {
+		t.Errorf("Settings changed by rejected calls: %d %v %v", bp.MaxSize(), bp.UpperRatio(), bp.LowerRatio())
+	}
+
+	// 与 Add 并发修改
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= 50; i++ {
+			_ = bp.SetMaxSize(i%8 + 1)
+		}
+	}()
+	addTasks(t, bp, make([]int, 200), 5*time.Second)
+	wg.Wait()
+	collect()
+}
+
+func TestShutdownDrainChunked(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	block := make(chan struct{})
+
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			if batch[0] == 0 {
+				<-block // 阻塞工作函数, 使后续任务留在队列中
+			}
+			mu.Lock()
+			sizes = append(sizes, len(batch))
+			mu.Unlock()
+		},
+		asyncbatch.WithMaxSize(5),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithFixedWait(50*time.Millisecond),
+		asyncbatch.WithUnderfilledWait(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	// [0] 进入工作函数并阻塞; 下一批 5 个任务等待交付; 其余 10 个留在队列中 (容量 10)
+	if err := bp.Add(0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	for i := 1; i <= 15; i++ {
+		if err := bp.Add(i); err != nil {
+			t.Fatalf("Add %d failed: %v", i, err)
+		}
+		if i == 5 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		bp.Shutdown()
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown timed out")
+	}
+
+	// 剩余的 10 个任务按 maxSize 分成两批, 而不是一批 10 个
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []int{1, 5, 5, 5}
+	if !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("Expected batch sizes %v, got %v", expected, sizes)
+	}
+}
+
+func TestWithFinalBatchHandler(t *testing.T) {
+	// drain 使 2500 个任务留在队列中后关闭处理器, 返回工作函数与最终处理函数收到的批次大小
+	drain := func(t *testing.T, withHandler bool) (workerSizes, finalSizes []int) {
+		var mu sync.Mutex
+		block := make(chan struct{})
+		opts := []asyncbatch.Option{
+			asyncbatch.WithMaxSize(2000), // 队列容量 4000
+			asyncbatch.WithUpperRatio(1),
+			asyncbatch.WithLowerRatio(0.0001),
+			asyncbatch.WithFixedWait(50 * time.Millisecond),
+			asyncbatch.WithUnderfilledWait(5 * time.Second),
+		}
+		if withHandler {
+			opts = append(opts, asyncbatch.WithFinalBatchHandler(func(batch []int) {
+				mu.Lock()
+				finalSizes = append(finalSizes, len(batch))
+				mu.Unlock()
+			}))
+		}
+		bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+			if batch[0] == 0 {
+				<-block // 阻塞工作函数, 使后续任务留在队列中
+			}
+			mu.Lock()
+			workerSizes = append(workerSizes, len(batch))
+			mu.Unlock()
+		}, opts...)
+		if err != nil {
+			t.Fatalf("NewBatchProcessor failed: %v", err)
+		}
+
+		// [0] 进入工作函数并阻塞; [1] 组批完成后等待交付
+		for _, v := range []int{0, 1} {
+			if err := bp.Add(v); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err := bp.SetMaxSize(1000); err != nil {
+			t.Fatalf("SetMaxSize failed: %v", err)
+		}
+		for i := 2; i < 2502; i++ {
+			if err := bp.Add(i); err != nil {
+				t.Fatalf("Add %d failed: %v", i, err)
+			}
+		}
+
+		done := make(chan struct{})
+		go func() {
+			bp.Shutdown()
+			close(done)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		close(block)
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Shutdown timed out")
+		}
+		if s := bp.Stats(); s.TotalTasksProcessed != 2502 {
+			t.Errorf("Expected 2502 processed tasks, got %d", s.TotalTasksProcessed)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return workerSizes, finalSizes
+	}
+
+	t.Run("WorkerReceivesChunks", func(t *testing.T) {
+		workerSizes, _ := drain(t, false)
+		expected := []int{1, 1, 1000, 1000, 500}
+		if !reflect.DeepEqual(workerSizes, expected) {
+			t.Errorf("Expected worker batch sizes %v, got %v", expected, workerSizes)
+		}
+	})
+
+	t.Run("FinalHandler", func(t *testing.T) {
+		workerSizes, finalSizes := drain(t, true)
+		if !reflect.DeepEqual(workerSizes, []int{1, 1}) {
+			t.Errorf("Expected worker batch sizes [1 1], got %v", workerSizes)
+		}
+		expected := []int{1000, 1000, 500}
+		if !reflect.DeepEqual(finalSizes, expected) {
+			t.Errorf("Expected final batch sizes %v, got %v", expected, finalSizes)
+		}
+	})
+
+	t.Run("TypeMismatch", func(t *testing.T) {
+		_, err := asyncbatch.NewBatchProcessor(func([]int) {},
+			asyncbatch.WithFinalBatchHandler(func([]string) {}))
+		if err == nil {
+			t.Error("Expected error for mismatched final batch handler")
+		}
+	})
+}
+
+func TestAddBatch(t *testing.T) {
+	block := make(chan struct{})
+	var processed atomic.Int64
+	bp, err := asyncbatch.NewBatchProcessor(
+		func(batch []int) {
+			<-block // 阻塞工作函数, 使任务留在队列中
+			processed.Add(int64(len(batch)))
+		},
+		asyncbatch.WithMaxSize(5),
+		asyncbatch.WithUpperRatio(1),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+
+	// 先占满工作函数与待交付的批次, 队列容量 10 随后保持不变
+	if err := bp.Add(0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, err := bp.AddBatch([]int{1, 2, 3, 4, 5}); n != 5 || err != nil {
+		t.Fatalf("AddBatch: expected 5 accepted, got %d, %v", n, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// TryAddBatch: 超出剩余容量时一个都不入队
+	if n, err := bp.TryAddBatch(make([]int, 11)); n != 0 || !errors.Is(err, asyncbatch.ErrChannelFull) {
+		t.Errorf("TryAddBatch: expected 0 accepted and ErrChannelFull, got %d, %v", n, err)
+	}
+	if n, err := bp.TryAddBatch([]int{6, 7, 8, 9}); n != 4 || err != nil {
+		t.Errorf("TryAddBatch: expected 4 accepted, got %d, %v", n, err)
+	}
+
+	// AddBatch: 尽量入队, 返回接受的数量
+	n, err := bp.AddBatch([]int{10, 11, 12, 13, 14, 15, 16, 17})
+	if n != 6 || !errors.Is(err, asyncbatch.ErrChannelFull) {
+		t.Errorf("AddBatch: expected 6 accepted and ErrChannelFull, got %d, %v", n, err)
+	}
+	stats := bp.Stats()
+	if stats.TotalTasksAdded != 16 || stats.TotalTasksDropped != 13 {
+		t.Errorf("Expected 16 added and 13 dropped, got %d and %d", stats.TotalTasksAdded, stats.TotalTasksDropped)
+	}
+
+	close(block)
+	bp.Shutdown()
+	if processed.Load() != 16 {
+		t.Errorf("Expected 16 processed tasks, got %d", processed.Load())
+	}
+	if n, err := bp.AddBatch([]int{1}); n != 0 || !errors.Is(err, asyncbatch.ErrClosed) {
+		t.Errorf("Expected ErrClosed after Shutdown, got %d, %v", n, err)
+	}
+}
+
+func TestNewBatchProcessorAck(t *testing.T) {
+	var mu sync.Mutex
+	calls := [][]int{}
+	attempts := map[int]int{}
+	var failed []int
+
+	// 偶数任务第一次不确认, 第二次确认; 任务 7 始终不确认
+	bp, err := asyncbatch.NewBatchProcessorAck(
+		func(batch []int) []bool {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, append([]int(nil), batch...))
+			acks := make([]bool, len(batch))
+			for i, task := range batch {
+				attempts[task]++
+				acks[i] = task != 7 && (task%2 == 1 || attempts[task] > 1)
+			}
+			return acks[:len(acks)-1] // 缺失的确认视为未确认
+		},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithFixedWait(100*time.Millisecond),
+		asyncbatch.WithUnderfilledWait(time.Second),
+		asyncbatch.WithRetry(3, time.Millisecond),
+		asyncbatch.WithErrorHandler(func(batch []int, err error) {
+			mu.Lock()
+			failed = append(failed, batch...)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorAck failed: %v", err)
+	}
+	if n, err := bp.AddBatch([]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}); n != 10 || err != nil {
+		t.Fatalf("AddBatch failed: %d, %v", n, err)
+	}
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// 第 1 次: 全部; 第 2 次: 未确认的偶数任务与 7 (10 的确认缺失); 第 3 次: 7 与 10 之外已确认
+	expected := [][]int{
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+		{2, 4, 6, 7, 8, 10},
+		{7, 10},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected worker calls %v, got %v", expected, calls)
+	}
+	if !reflect.DeepEqual(failed, []int{7, 10}) {
+		t.Errorf("Expected unacknowledged tasks [7 10], got %v", failed)
+	}
+}
+
+func TestWithCoalesce(t *testing.T) {
+	type reading struct {
+		sensor string
+		value  int
+	}
+	var mu sync.Mutex
+	var batches [][]reading
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []reading) {
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	},
+		asyncbatch.WithMaxSize(100),
+		asyncbatch.WithFixedWait(5*time.Second), // 由 Flush 触发批次
+		asyncbatch.WithUnderfilledWait(10*time.Second),
+		asyncbatch.WithCoalesce(func(r reading) string { return r.sensor }),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 5 个传感器各 10 个读数, 每个传感器只应保留最后一个
+	for i := 0; i < 50; i++ {
+		if err := bp.Add(reading{sensor: fmt.Sprintf("s%d", i%5), value: i}); err != nil {
+			t.Fatalf("Add %d failed: %v", i, err)
+		}
+	}
+	// 等待所有任务进入正在组建的批次, 使其合并到同一批次
+	deadline := time.Now().Add(2 * time.Second)
+	for bp.Stats().QueuedTasks > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Tasks were not taken from the queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := bp.Flush(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := [][]reading{{{"s0", 45}, {"s1", 46}, {"s2", 47}, {"s3", 48}, {"s4", 49}}}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("Expected batches %v, got %v", expected, batches)
+	}
+	stats := bp.Stats()
+	if stats.TotalTasksCoalesced != 45 || stats.TotalTasksProcessed != 5 {
+		t.Errorf("Expected 45 coalesced and 5 processed tasks, got %d and %d",
+			stats.TotalTasksCoalesced, stats.TotalTasksProcessed)
+	}
+
+	// 键函数类型与任务类型不符时构造失败
+	_, err = asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithCoalesce(func(s string) string { return s }))
+	if err == nil {
+		t.Error("Expected an error for a mismatched coalesce key type")
+	}
+}
+
+func TestWithTracer(t *testing.T) {
+	type spanKey struct{}
+	var mu sync.Mutex
+	var events []string
+	record := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+
+	bp, err := asyncbatch.NewBatchProcessorCtx(func(ctx context.Context, batch []int) error {
+		// 工作函数收到追踪函数返回的上下文
+		span, _ := ctx.Value(spanKey{}).(string)
+		record(fmt.Sprintf("worker %d in %s", len(batch), span))
+		return nil
+	},
+		asyncbatch.WithMaxSize(3),
+		asyncbatch.WithUpperRatio(1),
+		asyncbatch.WithTracer(func(ctx context.Context, batchSize int) (context.Context, func()) {
+			span := fmt.Sprintf("span-%d", batchSize)
+			record("start " + span)
+			return context.WithValue(ctx, spanKey{}, span), func() { record("end " + span) }
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessorCtx failed: %v", err)
+	}
+	addTasks(t, bp, []int{1, 2, 3}, time.Second)
+	bp.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"start span-3", "worker 3 in span-3", "end span-3"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %v, got %v", expected, events)
+	}
+
+	// 追踪函数返回 nil 时使用处理器的上下文, 普通工作函数同样被追踪
+	var ended atomic.Int64
+	bp2, err := asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithTracer(func(ctx context.Context, n int) (context.Context, func()) {
+			return nil, func() { ended.Add(int64(n)) }
+		}))
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	addTasks(t, bp2, []int{1, 2}, time.Second)
+	bp2.Shutdown()
+	if ended.Load() != 2 {
+		t.Errorf("Expected spans over 2 tasks, got %d", ended.Load())
+	}
+}
+
+func TestWithFlushOnEmpty(t *testing.T) {
+	var mu sync.Mutex
+	var triggers []string
+	processed := make(chan int, 10)
+	gate := make(chan struct{})
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		if batch[0] == 0 {
+			<-gate // 阻塞期间后续任务在队列中积累
+		}
+		processed <- len(batch)
+	},
+		asyncbatch.WithMaxSize(100),
+		asyncbatch.WithFixedWait(time.Second),
+		asyncbatch.WithUnderfilledWait(2*time.Second),
+		asyncbatch.WithFlushOnEmpty(true),
+		asyncbatch.WithBatchObserver(func(_ int, trigger string) {
+			mu.Lock()
+			triggers = append(triggers, trigger)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 单个任务立即提交, 不等待 fixedWait
+	start := time.Now()
+	if err := bp.Add(0); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for bp.Stats().InFlightBatches == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected an immediate flush, took %v", elapsed)
+	}
+
+	// 工作函数忙时入队的任务在其返回后同样无需等待即被处理
+	addTasks(t, bp, []int{1, 2, 3, 4, 5}, time.Second)
+	close(gate)
+	total := 0
+	for total < 6 {
+		select {
+		case n := <-processed:
+			total += n
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("Only %d of 6 tasks processed without waiting", total)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, trigger := range triggers {
+		if trigger != asyncbatch.TriggerQueueEmpty {
+			t.Errorf("Expected only %q triggers, got %v", asyncbatch.TriggerQueueEmpty, triggers)
+			break
+		}
+	}
+}
+
+func TestWithMemoryLimit(t *testing.T) {
+	const mb = 1 << 20
+	gate := make(chan struct{})
+	bp, err := asyncbatch.NewBatchProcessor(func(batch [][]byte) {
+		<-gate // 阻塞期间已接受的任务仍占用内存
+	},
+		asyncbatch.WithMaxSize(2),
+		asyncbatch.WithFixedWait(time.Millisecond),
+		asyncbatch.WithMemoryLimit(3*mb, func(task []byte) int { return len(task) }),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// 3 个 1MB 任务恰好用满预算, 第 4 个被拒绝
+	for i := 0; i < 3; i++ {
+		if err := bp.Add(make([]byte, mb)); err != nil {
+			t.Fatalf("Add %d failed: %v", i, err)
+		}
+	}
+	if err := bp.Add(make([]byte, mb)); !errors.Is(err, asyncbatch.ErrMemoryLimit) {
+		t.Fatalf("Expected ErrMemoryLimit, got %v", err)
+	}
+	if accepted, err := bp.AddBatch([][]byte{make([]byte, 10)}); accepted != 0 || !errors.Is(err, asyncbatch.ErrMemoryLimit) {
+		t.Fatalf("Expected AddBatch to accept nothing with ErrMemoryLimit, got %d, %v", accepted, err)
+	}
+	stats := bp.Stats()
+	if stats.MemoryUsage != 3*mb || bp.MemoryUsage() != 3*mb {
+		t.Errorf("Expected memory usage %d, got %d (Stats %d)", 3*mb, bp.MemoryUsage(), stats.MemoryUsage)
+	}
+	if stats.TotalTasksDropped != 2 {
+		t.Errorf("Expected 2 dropped tasks, got %d", stats.TotalTasksDropped)
+	}
+
+	// 批次处理完后释放内存, 可以继续添加
+	close(gate)
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	if usage := bp.MemoryUsage(); usage != 0 {
+		t.Errorf("Expected memory usage 0 after processing, got %d", usage)
+	}
+	if err := bp.Add(make([]byte, 2*mb)); err != nil {
+		t.Errorf("Add after draining failed: %v", err)
+	}
+
+	// 大小函数的类型必须与任务类型一致
+	if _, err := asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithMemoryLimit(mb, func(task string) int { return len(task) })); err == nil {
+		t.Error("Expected an error for a size function of the wrong type")
+	}
+	if _, err := asyncbatch.NewBatchProcessor(func([]int) {},
+		asyncbatch.WithMemoryLimit(0, func(int) int { return 8 })); err == nil {
+		t.Error("Expected an error for a non-positive memory limit")
+	}
+}
+
+func TestWithManualStart(t *testing.T) {
+	var processed atomic.Int64
+	bp, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		processed.Add(int64(len(batch)))
+	},
+		asyncbatch.WithMaxSize(10),
+		asyncbatch.WithFixedWait(time.Millisecond),
+		asyncbatch.WithManualStart(),
+	)
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	defer bp.Shutdown()
+
+	// Start 之前任务只入队, 不会被处理
+	addTasks(t, bp, []int{1, 2, 3}, time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if n := processed.Load(); n != 0 {
+		t.Fatalf("Expected no tasks processed before Start, got %d", n)
+	}
+	if err := bp.Flush(ctxWithTimeout(t, time.Second)); err == nil {
+		t.Error("Expected Flush to fail before Start")
+	}
+
+	if err := bp.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := bp.WaitForIdle(ctxWithTimeout(t, 2*time.Second)); err != nil {
+		t.Fatalf("WaitForIdle failed: %v", err)
+	}
+	if n := processed.Load(); n != 3 {
+		t.Errorf("Expected 3 tasks processed after Start, got %d", n)
+	}
+	if err := bp.Start(); err == nil {
+		t.Error("Expected an error when starting twice")
+	}
+
+	// 未使用该选项的处理器已自动启动
+	auto, err := asyncbatch.NewBatchProcessor(func([]int) {})
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	if err := auto.Start(); err == nil {
+		t.Error("Expected Start to fail on an auto-started processor")
+	}
+	auto.Shutdown()
+
+	// 未启动即关闭时仍处理已入队的任务, 之后 Start 返回 ErrClosed
+	var drained atomic.Int64
+	idle, err := asyncbatch.NewBatchProcessor(func(batch []int) {
+		drained.Add(int64(len(batch)))
+	}, asyncbatch.WithManualStart())
+	if err != nil {
+		t.Fatalf("NewBatchProcessor failed: %v", err)
+	}
+	addTasks(t, idle, []int{1, 2}, time.Second)
+	if unprocessed := idle.ShutdownCtx(ctxWithTimeout(t, 2*time.Second)); unprocessed != 0 {
+		t.Fatalf("Shutdown without Start left %d tasks unprocessed", unprocessed)
+	}
+	if n := drained.Load(); n != 2 {
+		t.Errorf("Expected 2 tasks drained at Shutdown, got %d", n)
+	}
+	if err := idle.Start(); !errors.Is(err, asyncbatch.ErrClosed) {
+		t.Errorf("Expected ErrClosed from Start after Shutdown, got %v", err)
+	}
+}
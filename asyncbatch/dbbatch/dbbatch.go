@@ -0,0 +1,158 @@
+package dbbatch
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/asyncbatch"
+	"github.com/kaichao/gopkg/errors"
+	"github.com/kaichao/gopkg/pgbulk"
+)
+
+// identifierRe matches a plain, unquoted SQL identifier.
+var identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// DBBatcher accumulates rows added from any goroutine and writes them to a
+// PostgreSQL table in bulk with pgbulk.Copy, one COPY per batch.
+type DBBatcher struct {
+	conn        *pgx.Conn
+	sqlTemplate string
+	numColumns  int
+	onError     func(rows [][]interface{}, err error)
+	connMu      sync.Mutex // pgx.Conn is not safe for concurrent use
+	bp          *asyncbatch.BatchProcessor[[]interface{}]
+	inserted    atomic.Int64
+	failed      atomic.Int64
+}
+
+// Option configures a DBBatcher.
+type Option func(*config)
+
+// config holds settings applied by Option values.
+type config struct {
+	batchOpts []asyncbatch.Option
+	onError   func(rows [][]interface{}, err error)
+}
+
+// WithBatchOptions passes options such as WithMaxSize or WithUnderfilledWait
+// to the underlying BatchProcessor. Several workers can form batches in
+// parallel, but the writes share the single connection and are serialized.
+func WithBatchOptions(opts ...asyncbatch.Option) Option {
+	return func(c *config) {
+		c.batchOpts = append(c.batchOpts, opts...)
+	}
+}
+
+// WithErrorHandler registers fn to receive the rows of a batch whose COPY
+// failed together with the error, e.g. to retry them or write them aside.
+// fn runs on the processing goroutine. Without a handler failures are logged.
+// Either way the rows are counted in Failed.
+func WithErrorHandler(fn func(rows [][]interface{}, err error)) Option {
+	return func(c *config) {
+		c.onError = fn
+	}
+}
+
+// NewDBBatcher creates and starts a DBBatcher writing rows of the given
+// columns to table, which may be schema-qualified. Call Shutdown to write the
+// remaining rows before closing conn.
+func NewDBBatcher(conn *pgx.Conn, table string, columns []string, opts ...Option) (*DBBatcher, error) {
+	if conn == nil {
+		return nil, errors.E("connection is required")
+	}
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return nil, errors.E("invalid table identifier", "table", table)
+	}
+	for _, part := range parts {
+		if !identifierRe.MatchString(part) {
+			return nil, errors.E("invalid table identifier", "table", table)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, errors.E("no columns specified", "table", table)
+	}
+	for _, c := range columns {
+		if !identifierRe.MatchString(c) {
+			return nil, errors.E("invalid column identifier", "column", c)
+		}
+	}
+
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	b := &DBBatcher{
+		conn:        conn,
+		sqlTemplate: fmt.Sprintf("INSERT INTO %s (%s)", table, strings.Join(columns, ",")),
+		numColumns:  len(columns),
+		onError:     cfg.onError,
+	}
+	bp, err := asyncbatch.NewBatchProcessorCtx(b.write, cfg.batchOpts...)
+	if err != nil {
+		return nil, errors.WrapE(err, "create batch processor")
+	}
+	b.bp = bp
+	return b, nil
+}
+
+// write copies one batch of rows. An error goes to the error handler if there
+// is one, and is otherwise returned for the BatchProcessor to log.
+func (b *DBBatcher) write(ctx context.Context, rows [][]interface{}) error {
+	b.connMu.Lock()
+	n, err := pgbulk.Copy(b.conn, b.sqlTemplate, rows)
+	b.connMu.Unlock()
+	if err == nil {
+		b.inserted.Add(int64(n))
+		return nil
+	}
+
+	b.failed.Add(int64(len(rows)))
+	if b.onError != nil {
+		b.onError(rows, err)
+		return nil
+	}
+	return err
+}
+
+// Add queues row for writing without blocking; it fails with
+// asyncbatch.ErrChannelFull if the queue is full or asyncbatch.ErrClosed if
+// the batcher is shut down. row must have one value per column.
+func (b *DBBatcher) Add(row []interface{}) error {
+	if len(row) != b.numColumns {
+		return errors.E("row length does not match columns",
+			"row-length", len(row), "num-columns", b.numColumns)
+	}
+	return b.bp.Add(row)
+}
+
+// AddWait queues row like Add, but blocks while the queue is full until there
+// is room or ctx is done, applying backpressure to the producer.
+func (b *DBBatcher) AddWait(ctx context.Context, row []interface{}) error {
+	if len(row) != b.numColumns {
+		return errors.E("row length does not match columns",
+			"row-length", len(row), "num-columns", b.numColumns)
+	}
+	return b.bp.AddWait(ctx, row)
+}
+
+// Shutdown stops accepting rows and returns once every queued row has been
+// written or reported as failed.
+func (b *DBBatcher) Shutdown() {
+	b.bp.Shutdown()
+}
+
+// Inserted returns the number of rows written so far.
+func (b *DBBatcher) Inserted() int64 { return b.inserted.Load() }
+
+// Failed returns the number of rows in batches whose COPY failed.
+func (b *DBBatcher) Failed() int64 { return b.failed.Load() }
+
+// Stats returns the statistics of the underlying BatchProcessor.
+func (b *DBBatcher) Stats() asyncbatch.Stats { return b.bp.Stats() }
@@ -0,0 +1,49 @@
+// Package dbbatch wires an asyncbatch.BatchProcessor to pgbulk.Copy, so rows
+// added one at a time from many goroutines are written to PostgreSQL in bulk.
+//
+// Core Features:
+// - Batching: rows accumulate under the usual asyncbatch size and wait rules
+// - Bulk writes: each batch is written with a single binary COPY
+// - Error handling: failed batches go to WithErrorHandler with their rows, or are logged
+// - Backpressure: AddWait blocks while the queue is full instead of failing
+// - Shutdown drain: Shutdown writes all queued rows before returning
+//
+// Usage Examples:
+//
+//	conn, _ := pgx.Connect(ctx, dbURL)
+//	b, err := dbbatch.NewDBBatcher(conn, "events", []string{"id", "name"},
+//	    dbbatch.WithBatchOptions(asyncbatch.WithMaxSize(5000)),
+//	    dbbatch.WithErrorHandler(func(rows [][]interface{}, err error) {
+//	        log.Printf("lost %d rows: %v", len(rows), err)
+//	    }),
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//	for _, e := range events {
+//	    if err := b.AddWait(ctx, []interface{}{e.ID, e.Name}); err != nil {
+//	        break
+//	    }
+//	}
+//	b.Shutdown() // Before closing conn
+//
+// Available Functions:
+//
+//	NewDBBatcher(conn *pgx.Conn, table string, columns []string, opts ...Option) (*DBBatcher, error)
+//	(b *DBBatcher) Add(row []interface{}) error
+//	(b *DBBatcher) AddWait(ctx context.Context, row []interface{}) error
+//	(b *DBBatcher) Shutdown()
+//	(b *DBBatcher) Inserted() int64
+//	(b *DBBatcher) Failed() int64
+//	(b *DBBatcher) Stats() asyncbatch.Stats
+//
+// Available Options:
+//
+//	WithBatchOptions(opts ...asyncbatch.Option) Option                 // Configure the underlying BatchProcessor
+//	WithErrorHandler(fn func(rows [][]interface{}, err error)) Option // Receive failed batches (default: logged)
+//
+// Concurrency:
+// A pgx.Conn serves one statement at a time, so writes are serialized on it even
+// with several asyncbatch workers; extra workers only form batches in parallel.
+// A failed COPY writes none of its batch's rows.
+package dbbatch
@@ -62,6 +62,9 @@
 //   - maxSize (Maximum Batch Size): Maximum tasks per batch. Larger values increase throughput
 //     but may increase memory usage and latency. Smaller values are suitable for low-latency scenarios.
 //
+//   - upperRatio (Upper Ratio): A batch is flushed as soon as it reaches maxSize * upperRatio
+//     tasks, without waiting. UpperThreshold() reports the resulting size.
+//
 //   - lowerRatio (Lower Ratio): Minimum ratio for underfilled batch processing. Triggers a batch
 //     when batch size reaches maxSize * lowerRatio and wait time exceeds underfilledWait.
 //
@@ -72,42 +75,278 @@
 //     but above lowerRatio). Balances latency and throughput.
 //
 //   - numWorkers (Number of Workers): Parallel workers for batch processing. More workers increase
-//     throughput but add CPU/memory overhead.
+//     throughput but add CPU/memory overhead. Must be 1-8; NewBatchProcessor returns an error
+//     for any other value, including zero and negative values.
+//
+//   - maxBatchesPerSecond (Emission Rate Cap): Token-bucket limit on how often batches are handed
+//     to the worker, shared by all workers. Batches over the cap wait; none are dropped.
+//     Once ShutdownCtx or ShutdownWithin gives up, the waits end and the batches still
+//     waiting go to the WithErrorHandler function (or are logged) instead of the worker.
+//
+// Pipelining:
+// Each worker is a pair of goroutines: one forms batches from the task queue and
+// hands them over an unbuffered channel to the other, which calls the worker
+// function. Batch formation therefore continues while the previous batch is
+// processed, at the cost of up to one extra batch (maxSize tasks) held in
+// memory per worker. When the worker function is the bottleneck, throughput is
+// unchanged; the gain is that intake keeps draining the queue meanwhile, so Add
+// reports a full channel later under bursts.
+//
+// Bounded Shutdown:
+// ShutdownWithin(d) starts the same graceful shutdown as Shutdown but returns an
+// error after d if worker functions are still running, logging the sizes of the
+// batches they hold. The abandoned workers are not killed and may keep running;
+// remaining tasks are still processed if they return. ShutdownCtx(ctx) does the
+// same bounded by a context and returns the number of accepted tasks not yet
+// processed when it gave up (0 on completion); WithDrainTimeout(d) makes plain
+// Shutdown behave like ShutdownCtx with a deadline of d, and ShutdownTimeout(d)
+// returns an error carrying the unprocessed count instead. No accepted task is
+// dropped by any of them: a shutdown that times out completes in the
+// background once the workers return. The tasks still queued at shutdown go to
+// the worker function in batches of at most maxSize, also split at flush
+// markers, like every other batch. WithFinalBatchHandler(fn) sends those
+// batches to fn instead, once all normal batches have been processed, so
+// shutdown-flushed batches can be told apart.
+//
+// Partitioning:
+// WithPartitioner(key) routes every task to the worker chosen by hashing
+// key(task); WithIntPartitioner(key) picks worker key(task) % numWorkers for
+// integer keys. Each worker then has its own queue and processing goroutine, so
+// all tasks of one key land in the batches of one worker and are processed in
+// submission order, also across batches. ScaleWorkers and high-priority tasks
+// are not supported in this mode; AddFlushMarker flushes every partition.
+//
+// Coalescing:
+// WithCoalesce(key) keeps only the newest task per key(task) in each batch: a
+// later task replaces the earlier one in place while the batch is formed, so
+// the worker function sees latest-wins batches (e.g. the last reading per
+// sensor). Tasks in different batches are not merged. Replaced tasks are
+// counted in Stats.TotalTasksCoalesced and count as settled for WaitForIdle.
+//
+// Memory Limit:
+// WithMemoryLimit(bytes, size) caps the estimated memory of the tasks accepted
+// but not yet processed, by size(task) per task. Beyond it Add and its variants
+// fail with ErrMemoryLimit (AddWait does not wait for memory); the budget is
+// freed as the worker function returns from batches. MemoryUsage() and
+// Stats.MemoryUsage report the current estimate.
+//
+// Debugging:
+// DumpState() returns copies of the queued tasks and of the batches inside the
+// worker function. It briefly takes the queued tasks out and puts them back, so
+// while workers are consuming, a task may overtake queued ones; it is meant for
+// diagnosing a wedged processor, not for regular monitoring (use Stats).
+//
+// Worker Scaling:
+// ScaleWorkers(n) changes the number of workers at runtime within the same 1-8
+// range as WithNumWorkers. Scaling down is cooperative: a retired worker flushes
+// the batch it is forming and exits once its in-flight batch is processed, so
+// the worker count drops shortly after the call returns. The task queue
+// capacity is fixed at construction and does not follow the worker count.
+//
+// Batch Size Tuning:
+// SetMaxSize, SetUpperRatio and SetLowerRatio change the batch limits of a
+// running processor with the same validation as the options. Workers apply
+// them from their next check of the batch being formed; batches already handed
+// off are not touched. Queue capacities keep their initial size.
+//
+// On-Demand Flush:
+// Flush(ctx) asks every worker to hand its forming batch to the worker function
+// right away, whatever its size and timer, followed by the tasks still waiting
+// in the queues in batches of at most maxSize, and returns once all have been
+// handed off. The processor keeps running and no channel is closed, so Flush
+// can be called repeatedly, e.g. at the end of each unit of work. Compared with
+// AddFlushMarker it reaches every worker and the whole queue.
+//
+// Waiting for Idle:
+// WaitForIdle(ctx) blocks until every task accepted so far has been processed,
+// forcing nothing: a small final batch is processed after the underfilled
+// wait as usual. Combine it with Flush to avoid that wait. While producers keep
+// adding tasks it only reports a moment of idleness, which may not last.
+//
+// Flush Markers:
+// AddFlushMarker enqueues a marker in line with the tasks. The worker goroutine
+// that receives it flushes its current batch at once; the marker never reaches
+// the worker function. With one worker, every task added before a marker is
+// delivered in a batch that ends at or before the marker, and no task added
+// after it joins that batch. With several workers, only the receiving
+// goroutine's batch is flushed.
+//
+// Priorities:
+// AddPriority(task, true) puts a task on a separate high-priority queue. Before
+// each read from the normal queue a worker checks the high-priority queue; a
+// high-priority task is flushed in its own batch together with any others that
+// arrive within WithHighPriorityWait (default 1ms), without waiting for the
+// normal batch being formed. Batches already handed to the worker function are
+// not preempted. Under strict priority (the default) a continuous stream of
+// high-priority tasks starves normal tasks; WithPriorityFairness(n) lets a
+// worker serve at most n high-priority batches in a row before it must take a
+// normal task or let its timer run. On Shutdown, remaining high-priority tasks
+// are flushed first.
+//
+// Adaptive Wait:
+// With WithAdaptiveWait(min, max) the fixed wait is replaced by one derived from
+// the task arrival rate. Each worker keeps an exponential moving average (weight
+// 0.2) of the time between the tasks it receives, and waits twice that average,
+// clamped to [min, max]: bursts pull the wait toward min, so batches are handed
+// off without idle latency, and sparse tasks push it toward max so they are
+// gathered into fewer batches. When the wait expires with no task, the time
+// since the last task is folded in as well, so the wait also grows while no
+// tasks arrive at all. Samples are capped at max. The wait starts halfway
+// between the bounds; max must be below underfilledWait. EffectiveWait()
+// reports the current value, averaged over the workers.
+// The wait is driven by the arrival rate only, not by the fill ratio of
+// batches, which earlier versions used: a fill ratio does not change while no
+// batches form, so it could not react to a lull. Full batches of slowly
+// arriving tasks therefore get a wait near max.
+//
+// Backpressure:
+// Add fails at once with ErrChannelFull ("task channel is full") when the queue
+// is saturated; test for it and for ErrClosed with errors.Is.
+// AddWait(ctx, task) instead blocks until there is room, returning ctx.Err() if
+// ctx is done first, or ErrClosed if Shutdown is called meanwhile.
+// AddBatch(tasks) queues the longest prefix of tasks that fits and reports
+// its length; TryAddBatch(tasks) queues all of them or none, checking the free
+// room first. That check races with concurrent Adds, so TryAddBatch can still
+// stop partway and its accepted count must be checked too.
+//
+// Cancellation:
+// WithContext(ctx) ties the processor to a parent context such as an errgroup's.
+// Once ctx is done every Add variant fails with ErrClosed and Shutdown
+// runs in the background, draining queued tasks; calling Shutdown as well is
+// safe, it runs only once. A NewBatchProcessorCtx worker context inherits the
+// values of ctx but not its cancellation, which stays governed by the grace
+// period.
+//
+// Manual Start:
+// The constructor launches the workers at once. With WithManualStart() it only
+// validates and allocates, and Start() launches them later (e.g. once the
+// resources the worker function captures are ready). Tasks added before Start
+// wait in the queue; Start fails if called twice or after Shutdown, and
+// Shutdown without Start still processes the queued tasks.
+//
+// Monitoring:
+// Stats() returns a Stats snapshot safe to read while tasks are added: queued
+// tasks, queue capacity, batches inside the worker function, tasks added and
+// dropped on a full queue, batches flushed with their average size, underfilled
+// flushes, tasks processed and coalesced, and the current worker count. The counters are
+// atomic and suit export to a metrics system such as Prometheus.
+//
+// Context-Aware Workers:
+// NewBatchProcessorCtx takes a worker function func(ctx, batch) error. The
+// context lives as long as the processor; after Shutdown starts, in-flight and
+// remaining batches keep it for the grace period (WithGracePeriod, default 5s),
+// after which it is cancelled so slow workers can give up. Shutdown still waits
+// for them to return. Failing batches go to WithErrorHandler with their
+// errors, or are logged without one. Worker() returns nil for such a
+// processor.
+//
+// Error Handling:
+// NewBatchProcessorE takes a worker function func(batch) error without a
+// context. For it and NewBatchProcessorCtx, a failing batch is passed with the
+// error to WithErrorHandler, e.g. to dead-letter or retry it, or is logged
+// without a handler. The handler's T must
+// match the processor's, which the constructor checks. With
+// WithRetry(maxAttempts, backoff) a failing batch is retried on the same
+// processing goroutine, other workers continuing meanwhile, and only reported
+// once all attempts have failed. WithBatchRetry does the same with the wait
+// doubling after each attempt, and gives up on pending retries once Shutdown
+// is called instead of at the end of the grace period.
+//
+// Acknowledgements:
+// NewBatchProcessorAck takes a worker function that returns one flag per task,
+// true for each task it processed. With WithRetry or WithBatchRetry only the
+// unacknowledged tasks are passed again, up to maxAttempts calls per task;
+// those left over go to the error handler above. They are held in memory
+// meanwhile, occupying the processing goroutine, and delivery is
+// at-least-once only within the process: a crash loses queued and pending
+// tasks.
 //
-// Note: The upperRatio parameter is currently unused in the implementation and setting it
-// has no effect. It is recommended to ignore this parameter.
+// Thresholds:
+// UpperThreshold() is floor(maxSize*upperRatio) clamped to [1, maxSize]; a batch
+// of that size is flushed immediately. LowerThreshold() is
+// floor(maxSize*lowerRatio), at least 1; when fixedWait expires a batch of at
+// least that size is flushed, while a smaller one waits up to underfilledWait.
+// For example, maxSize 1000 with upperRatio 0.001 gives UpperThreshold 1, so
+// every task becomes its own batch.
 //
 // Available Functions:
 //
 //	NewBatchProcessor[T any](worker func([]T), opts ...Option) (*BatchProcessor[T], error)
+//	NewBatchProcessorCtx[T any](worker func(context.Context, []T) error, opts ...Option) (*BatchProcessor[T], error)
+//	NewBatchProcessorE[T any](worker func([]T) error, opts ...Option) (*BatchProcessor[T], error)
+//	NewBatchProcessorAck[T any](worker func(batch []T) (processed []bool), opts ...Option) (*BatchProcessor[T], error)
 //	(bp *BatchProcessor[T]) Add(task T) error
+//	(bp *BatchProcessor[T]) Start() error
+//	(bp *BatchProcessor[T]) AddWait(ctx context.Context, task T) error
+//	(bp *BatchProcessor[T]) AddBatch(tasks []T) (accepted int, err error)
+//	(bp *BatchProcessor[T]) TryAddBatch(tasks []T) (accepted int, err error)
+//	(bp *BatchProcessor[T]) AddPriority(task T, high bool) error
+//	(bp *BatchProcessor[T]) AddFlushMarker() error
+//	(bp *BatchProcessor[T]) Flush(ctx context.Context) error
+//	(bp *BatchProcessor[T]) WaitForIdle(ctx context.Context) error
+//	(bp *BatchProcessor[T]) ScaleWorkers(n int) error
+//	(bp *BatchProcessor[T]) SetMaxSize(size int) error
+//	(bp *BatchProcessor[T]) SetUpperRatio(ratio float64) error
+//	(bp *BatchProcessor[T]) SetLowerRatio(ratio float64) error
 //	(bp *BatchProcessor[T]) Shutdown()
+//	(bp *BatchProcessor[T]) ShutdownWithin(d time.Duration) error
+//	(bp *BatchProcessor[T]) ShutdownCtx(ctx context.Context) (unprocessed int)
+//	(bp *BatchProcessor[T]) ShutdownTimeout(d time.Duration) error
+//	(bp *BatchProcessor[T]) DumpState() (pending []T, inFlight [][]T)
 //	(bp *BatchProcessor[T]) TasksCap() int
+//	(bp *BatchProcessor[T]) QueueDepth() int
+//	(bp *BatchProcessor[T]) MemoryUsage() int64
+//	(bp *BatchProcessor[T]) Stats() Stats
 //
 // Getter Methods:
 //
 //	(bp *BatchProcessor[T]) MaxSize() int
 //	(bp *BatchProcessor[T]) UpperRatio() float64
 //	(bp *BatchProcessor[T]) LowerRatio() float64
+//	(bp *BatchProcessor[T]) UpperThreshold() int
+//	(bp *BatchProcessor[T]) LowerThreshold() int
+//	(bp *BatchProcessor[T]) EffectiveWait() time.Duration
 //	(bp *BatchProcessor[T]) FixedWait() time.Duration
 //	(bp *BatchProcessor[T]) UnderfilledWait() time.Duration
 //	(bp *BatchProcessor[T]) NumWorkers() int
+//	(bp *BatchProcessor[T]) MaxBatchesPerSecond() float64
 //	(bp *BatchProcessor[T]) Worker() func([]T)
 //
 // Available Options:
 //
 //	WithMaxSize(size int) Option                 // Set maximum batch size
-//	WithUpperRatio(ratio float64) Option         // Set upper ratio (currently unused)
+//	WithUpperRatio(ratio float64) Option         // Set upper ratio (flush at once at maxSize*upperRatio)
 //	WithLowerRatio(ratio float64) Option         // Set lower ratio for underfilled batches
 //	WithFixedWait(duration time.Duration) Option // Set fixed wait time
 //	WithUnderfilledWait(duration time.Duration) Option // Set underfilled wait time
-//	WithNumWorkers(numWorkers int) Option        // Set number of parallel workers (1-8)
+//	WithFlushOnEmpty(enabled bool) Option        // Flush as soon as the queue is empty instead of waiting fixedWait
+//	WithNumWorkers(numWorkers int) Option        // Set number of parallel workers (1-8, otherwise error)
+//	WithQueueSize(n int) Option                  // Task queue capacity, >= maxSize (default maxSize*numWorkers*2)
+//	WithMaxBatchesPerSecond(r float64) Option    // Cap batch emission rate (delays, never drops)
+//	WithBatchSizeObserver(fn func(size int)) Option // Called with each batch size before the worker (histograms)
+//	WithBatchObserver(fn func(size int, trigger string)) Option // Also told the flush trigger (Trigger* constants)
+//	WithTracer(fn func(ctx context.Context, batchSize int) (context.Context, func())) Option // Span around each worker call
+//	WithAdaptiveWait(min, max time.Duration) Option  // Wait adapts to the arrival rate between min and max instead of fixedWait
+//	WithHighPriorityWait(d time.Duration) Option    // Gathering time for high-priority batches (default 1ms)
+//	WithPriorityFairness(n int) Option              // Max consecutive high-priority batches (default 0: strict)
+//	WithContext(ctx context.Context) Option         // Shut down (draining) and reject Adds once ctx is done
+//	WithManualStart() Option                        // Constructor does not launch the workers; Start() does
+//	WithErrorHandler[T any](fn func(batch []T, err error)) Option // Failing batch with its error, for E/Ctx/Ack workers (default: logged)
+//	WithRetry(maxAttempts int, backoff time.Duration) Option // Attempts per failing batch, fixed backoff between them
+//	WithBatchRetry(maxAttempts int, backoff time.Duration) Option // Like WithRetry, doubling backoff; retries abandoned on Shutdown
+//	WithPartitioner[T any](key func(task T) string) Option // Same key -> same worker and batches, in order
+//	WithIntPartitioner[T any](key func(task T) int) Option // Like WithPartitioner, worker key % numWorkers
+//	WithFinalBatchHandler[T any](fn func(batch []T)) Option // Receives the batches drained from the queues at Shutdown
+//	WithCoalesce[T any](key func(task T) string) Option // Later task replaces an earlier one with the same key in the batch
+//	WithMemoryLimit[T any](bytes int, size func(task T) int) Option // Reject Adds with ErrMemoryLimit while unprocessed tasks exceed bytes
+//	WithDrainTimeout(d time.Duration) Option        // Shutdown returns after at most d, logging unprocessed tasks (default 0: wait)
+//	WithGracePeriod(d time.Duration) Option         // Delay after Shutdown before the worker context is cancelled (default 5s)
 //
 // Parameter Defaults and Recommended Ranges:
 //
 //	Parameter           Default  Recommended Range
 //	MaxSize             1000     100-10000 (High throughput: 1000-10000, Low latency: 100-500)
-//	UpperRatio          0.5      0.5-0.8 (Currently unused)
+//	UpperRatio          0.5      0.5-0.8 (Flush without waiting at maxSize*upperRatio)
 //	LowerRatio          0.1      0.05-0.3 (Low latency: 0.05-0.1, High throughput: 0.2-0.3)
 //	FixedWait           5 ms     1ms-50ms (High throughput: 1ms-10ms, Low frequency: 20ms-50ms)
 //	UnderfilledWait     20 ms    10ms-100ms (Low latency: 10ms-20ms, High throughput: 50ms-100ms)
@@ -0,0 +1,43 @@
+package asyncbatch
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket with a capacity of one token, shared by all
+// workers of a processor. Callers are delayed, never rejected.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration // time to refill one token
+	next     time.Time     // earliest time the next token is available
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until a token is available and consumes it, returning true, or
+// until abandon is closed, returning false.
+func (l *rateLimiter) wait(abandon <-chan struct{}) bool {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-abandon:
+		return false
+	}
+}
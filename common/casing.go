@@ -0,0 +1,111 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// SnakeToCamelJSON renames every object key of jsonStr, at any depth, from
+// snake_case to camelCase ("user_id" -> "userId"); values, including string
+// values, are left untouched. Leading underscores are kept ("_id" stays
+// "_id") and keys without an underscore are unchanged. Two keys of one object
+// that map to the same name (e.g. "user_id" and "userId") are an error.
+func SnakeToCamelJSON(jsonStr string) (string, error) {
+	return renameJSONKeys(jsonStr, snakeToCamel)
+}
+
+// CamelToSnakeJSON is the inverse of SnakeToCamelJSON: it renames every object
+// key from camelCase (or PascalCase) to snake_case. A run of capitals is taken
+// as one acronym word: "userID" -> "user_id", "HTTPServer" -> "http_server".
+// Digits stay with the preceding word ("line1" -> "line1"), so "line_1" does
+// not round-trip.
+func CamelToSnakeJSON(jsonStr string) (string, error) {
+	return renameJSONKeys(jsonStr, camelToSnake)
+}
+
+// renameJSONKeys decodes jsonStr, renames all object keys with rename and
+// encodes the result.
+func renameJSONKeys(jsonStr string, rename func(string) string) (string, error) {
+	doc, err := decodeJSON(jsonStr)
+	if err != nil {
+		return "", err
+	}
+	out, err := renameKeys(doc, rename)
+	if err != nil {
+		return "", err
+	}
+	return encodeJSON(out)
+}
+
+// renameKeys returns a copy of v with the keys of all nested objects renamed.
+func renameKeys(v interface{}, rename func(string) string) (interface{}, error) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(x))
+		from := make(map[string]string, len(x))
+		for k, child := range x {
+			nk := rename(k)
+			if prev, dup := from[nk]; dup {
+				return nil, errors.E("renamed keys collide", "key", nk, "from", prev, "and", k)
+			}
+			from[nk] = k
+			renamed, err := renameKeys(child, rename)
+			if err != nil {
+				return nil, err
+			}
+			obj[nk] = renamed
+		}
+		return obj, nil
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, child := range x {
+			renamed, err := renameKeys(child, rename)
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = renamed
+		}
+		return arr, nil
+	default:
+		return v, nil
+	}
+}
+
+// snakeToCamel converts "user_id" to "userId", keeping leading underscores.
+func snakeToCamel(s string) string {
+	body := strings.TrimLeft(s, "_")
+	var b strings.Builder
+	b.WriteString(s[:len(s)-len(body)])
+	for i, part := range strings.Split(body, "_") {
+		if part == "" {
+			continue
+		}
+		if i == 0 {
+			b.WriteString(part)
+			continue
+		}
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	return b.String()
+}
+
+// camelToSnake converts "userID" to "user_id" and "HTTPServer" to "http_server".
+func camelToSnake(s string) string {
+	r := []rune(s)
+	var b strings.Builder
+	for i, c := range r {
+		if unicode.IsUpper(c) && i > 0 {
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
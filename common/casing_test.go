@@ -0,0 +1,79 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnakeToCamelJSON(t *testing.T) {
+	t.Run("nested objects and arrays", func(t *testing.T) {
+		out, err := common.SnakeToCamelJSON(`{
+			"user_id": 12345678901234567890,
+			"_internal_flag": true,
+			"display_name": "first_name",
+			"home_address": {"zip_code": "100000", "street_lines": ["a_b", "c"]},
+			"order_items": [{"item_id": 1, "unit_price": 9.5}, [{"deep_key": null}]],
+			"plain": 1
+		}`)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"userId": 12345678901234567890,
+			"_internalFlag": true,
+			"displayName": "first_name",
+			"homeAddress": {"zipCode": "100000", "streetLines": ["a_b", "c"]},
+			"orderItems": [{"itemId": 1, "unitPrice": 9.5}, [{"deepKey": null}]],
+			"plain": 1
+		}`, out)
+	})
+
+	t.Run("non-object documents", func(t *testing.T) {
+		out, err := common.SnakeToCamelJSON(`[{"a_b": 1}, "x_y"]`)
+		assert.NoError(t, err)
+		assert.Equal(t, `[{"aB":1},"x_y"]`, out)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := common.SnakeToCamelJSON(`{"user_id": 1, "userId": 2}`)
+		assert.Error(t, err)
+		_, err = common.SnakeToCamelJSON(`{"a":`)
+		assert.Error(t, err)
+		_, err = common.SnakeToCamelJSON(`{"a_b":1} x`)
+		assert.Error(t, err)
+	})
+}
+
+func TestCamelToSnakeJSON(t *testing.T) {
+	t.Run("nested objects and arrays", func(t *testing.T) {
+		out, err := common.CamelToSnakeJSON(`{
+			"userID": 1,
+			"HTTPServer": {"listenAddr": "0.0.0.0", "maxConns": 10},
+			"addressLine1": "someValue",
+			"items": [{"itemId": 1, "tags": ["camelCase"]}],
+			"already_snake": true
+		}`)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"user_id": 1,
+			"http_server": {"listen_addr": "0.0.0.0", "max_conns": 10},
+			"address_line1": "someValue",
+			"items": [{"item_id": 1, "tags": ["camelCase"]}],
+			"already_snake": true
+		}`, out)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		in := `{"order_id":1,"shipping_address":{"zip_code":"x"}}`
+		camel, err := common.SnakeToCamelJSON(in)
+		assert.NoError(t, err)
+		out, err := common.CamelToSnakeJSON(camel)
+		assert.NoError(t, err)
+		assert.Equal(t, in, out)
+	})
+
+	t.Run("collision", func(t *testing.T) {
+		_, err := common.CamelToSnakeJSON(`{"userId": 1, "user_id": 2}`)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,58 @@
+// Package common provides small general-purpose helpers shared across gopkg,
+// focused on JSON document manipulation and JSON-serializable accumulation.
+//
+// Core Features:
+// - JSON projection: keep only selected dotted paths of a document
+// - JSON nesting: rebuild objects and arrays from flat dotted keys ("a.b.0")
+// - JSON key casing: convert object keys between snake_case and camelCase
+// - JSON array merge: upsert objects into an array by a key field
+// - SyncMap: concurrency-safe typed map with counters and JSON snapshots
+// - Templates: expand ${name} / $name placeholders from a map, or JSON-aware into a JSON document
+// - Errors: serialize an error and its unwrap chain as JSON for structured logs
+// - Durations: parse Go duration syntax or bare numbers of seconds
+// - Truncation: shorten UTF-8 strings by runes or bytes without splitting a rune
+//
+// Usage Examples:
+//
+//	import "github.com/kaichao/gopkg/common"
+//
+//	out, err := common.ProjectJSON(`{"user":{"name":"alice","email":"a@x.com"},"debug":true}`,
+//		[]string{"user.name"})
+//	// out == `{"user":{"name":"alice"}}`
+//
+//	out, err = common.MergeJSONArrayByKey(`[{"id":1,"v":"a"}]`, `[{"id":1,"v":"b"},{"id":2}]`, "id")
+//	// out == `[{"id":1,"v":"b"},{"id":2}]`
+//
+// Available Functions:
+//
+//	ProjectJSON(jsonStr string, paths []string) (string, error)
+//	NestMap(flat map[string]interface{}) (string, error) // inverse of flattening; "a.0" segments build arrays
+//	SnakeToCamelJSON(jsonStr string) (string, error) // rename keys user_id -> userId at any depth
+//	CamelToSnakeJSON(jsonStr string) (string, error) // rename keys userID -> user_id at any depth
+//	MergeJSONArrayByKey(baseArr, overrideArr string, key string) (string, error)
+//	JSONEqual(a, b string) (bool, error) // semantic equality: key order, whitespace, 1.0 vs 1 ignored
+//	ExpandTemplate(s string, vars map[string]string, opts ...ExpandOption) (string, error)
+//	WithKeepUndefined() ExpandOption // leave undefined placeholders verbatim instead of failing
+//	RenderJSONTemplate(tmpl string, vars map[string]string) (string, error) // ${name} escaped for its JSON position
+//	ErrorToJSON(err error) string // {"error":"...","causes":[...]} from the errors.Unwrap chain; nil -> "null"
+//	ParseDuration(s string) (time.Duration, error) // "1h30m", "500ms", or seconds as "30" / "1.5"
+//	TruncateRunes(s string, maxRunes int) string   // at most maxRunes runes, ending in "..." if cut
+//	TruncateBytes(s string, maxBytes int) string   // at most maxBytes bytes, cut at a rune boundary
+//
+// Types:
+//
+//	SyncMap[V any]                       // zero value ready to use
+//	(s *SyncMap[V]) Set(key string, value V)
+//	(s *SyncMap[V]) Get(key string) (V, bool)
+//	(s *SyncMap[V]) Delete(key string)
+//	Inc[V Number](s *SyncMap[V], key string, delta V) V // numeric V only, checked by the compiler
+//	(s *SyncMap[V]) Snapshot() map[string]V
+//	(s *SyncMap[V]) ToJSON() (string, error)
+//
+// Number Handling:
+// JSON numbers are decoded as json.Number, so large integers survive a
+// decode/encode round trip unchanged.
+//
+// Error Handling:
+// Errors are returned as traced errors via github.com/kaichao/gopkg/errors.
+package common
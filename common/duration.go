@@ -0,0 +1,55 @@
+package common
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// ParseDuration parses a duration given either in Go syntax ("500ms", "2m",
+// "1h30m") or as a bare integer or decimal number of seconds ("30", "1.5").
+// Surrounding whitespace is ignored. Empty input, other formats, and values
+// outside the time.Duration range are errors.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.E("empty duration")
+	}
+
+	if secs, err := strconv.ParseFloat(s, 64); err == nil && isPlainNumber(s) {
+		// float64(math.MaxInt64) rounds up to 2^63, which int64 cannot hold;
+		// -2^63 itself is valid
+		ns := math.Round(secs * float64(time.Second))
+		if math.IsNaN(ns) || ns >= -math.MinInt64 || ns < math.MinInt64 {
+			return 0, errors.E("duration out of range", "value", s)
+		}
+		return time.Duration(ns), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.WrapE(err, "invalid duration: expected Go syntax like 1h30m or a number of seconds", "value", s)
+	}
+	return d, nil
+}
+
+// isPlainNumber reports whether s consists only of an optional sign, digits
+// and at most one decimal point, rejecting forms strconv.ParseFloat accepts
+// such as "1e3", "Inf" or "0x10".
+func isPlainNumber(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	dot := false
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+		case r == '.' && !dot:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return s != "" && s != "."
+}
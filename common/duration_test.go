@@ -0,0 +1,77 @@
+package common_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDuration(t *testing.T) {
+	t.Run("go format", func(t *testing.T) {
+		cases := map[string]time.Duration{
+			"5s":      5 * time.Second,
+			"2m":      2 * time.Minute,
+			"1h30m":   90 * time.Minute,
+			"250ms":   250 * time.Millisecond,
+			" 1.5h ":  90 * time.Minute,
+			"-3s":     -3 * time.Second,
+			"0":       0,
+			"1m0.5s":  time.Minute + 500*time.Millisecond,
+			"100us":   100 * time.Microsecond,
+			"1h1m1s":  time.Hour + time.Minute + time.Second,
+			"+10s":    10 * time.Second,
+			"10ns":    10,
+			"0.001ms": time.Microsecond,
+		}
+		for in, want := range cases {
+			got, err := common.ParseDuration(in)
+			require.NoError(t, err, in)
+			assert.Equal(t, want, got, in)
+		}
+	})
+
+	t.Run("bare number of seconds", func(t *testing.T) {
+		cases := map[string]time.Duration{
+			"30":     30 * time.Second,
+			"1.5":    1500 * time.Millisecond,
+			".25":    250 * time.Millisecond,
+			"7.":     7 * time.Second,
+			"-2":     -2 * time.Second,
+			"0.0001": 100 * time.Microsecond,
+			" 60\n":  time.Minute,
+		}
+		for in, want := range cases {
+			got, err := common.ParseDuration(in)
+			require.NoError(t, err, in)
+			assert.Equal(t, want, got, in)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, in := range []string{"", "  ", "abc", "5 s", "1e3", "Inf", "NaN", "0x10", ".", "5d", "1..5", "99999999999999"} {
+			_, err := common.ParseDuration(in)
+			assert.Error(t, err, in)
+		}
+	})
+
+	t.Run("range boundary", func(t *testing.T) {
+		// 2^63 ns, which float64(math.MaxInt64) rounds to, does not fit
+		for _, in := range []string{"9223372036.854775807", "9223372036.854775808", "-9223372036.86"} {
+			_, err := common.ParseDuration(in)
+			assert.ErrorContains(t, err, "out of range", in)
+		}
+
+		got, err := common.ParseDuration("-9223372036.854775808")
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(math.MinInt64), got)
+
+		got, err = common.ParseDuration("9223372036.854775")
+		require.NoError(t, err)
+		assert.Greater(t, got, time.Duration(0))
+		assert.InDelta(t, float64(math.MaxInt64), float64(got), 2048)
+	})
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"github.com/kaichao/gopkg/errors"
+)
+
+// maxErrorCauses bounds the unwrap chain ErrorToJSON follows, guarding
+// against errors whose Unwrap forms a cycle.
+const maxErrorCauses = 100
+
+// ErrorToJSON serializes err for structured logs as
+// {"error":"<err.Error()>","causes":["...", ...]}, where causes holds the
+// messages of the errors reached by repeated errors.Unwrap, outermost first
+// (an empty array if err wraps nothing). Only single-error unwrapping is
+// followed, so the branches of errors.Join are not listed. A nil err gives
+// "null".
+func ErrorToJSON(err error) string {
+	if err == nil {
+		return "null"
+	}
+	doc := struct {
+		Error  string   `json:"error"`
+		Causes []string `json:"causes"`
+	}{Error: err.Error(), Causes: []string{}}
+	for cause := errors.Unwrap(err); cause != nil && len(doc.Causes) < maxErrorCauses; cause = errors.Unwrap(cause) {
+		doc.Causes = append(doc.Causes, cause.Error())
+	}
+	out, _ := encodeJSON(doc) // Strings and slices always encode
+	return out
+}
@@ -0,0 +1,37 @@
+package common_test
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/kaichao/gopkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorToJSON(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		assert.Equal(t, "null", common.ErrorToJSON(nil))
+	})
+
+	t.Run("single error", func(t *testing.T) {
+		assert.Equal(t, `{"error":"boom","causes":[]}`, common.ErrorToJSON(stderrors.New("boom")))
+	})
+
+	t.Run("fmt wrapping chain", func(t *testing.T) {
+		root := stderrors.New(`disk "sda" full`)
+		mid := fmt.Errorf("write block: %w", root)
+		top := fmt.Errorf("save <file>: %w", mid)
+		assert.Equal(t,
+			`{"error":"save <file>: write block: disk \"sda\" full","causes":["write block: disk \"sda\" full","disk \"sda\" full"]}`,
+			common.ErrorToJSON(top))
+	})
+
+	t.Run("traced errors", func(t *testing.T) {
+		err := errors.WrapE(errors.WrapE(errors.E(125, "connection refused"), "dial"), "run query", "table", "t")
+		assert.JSONEq(t,
+			`{"error":"run query: dial: connection refused","causes":["dial: connection refused","connection refused"]}`,
+			common.ErrorToJSON(err))
+	})
+}
@@ -0,0 +1,243 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// decodeJSON unmarshals s into a generic value, keeping numbers as json.Number
+// so that they round-trip without float64 precision loss. s must hold exactly
+// one JSON value; anything but whitespace after it is an error.
+func decodeJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.WrapE(err, "invalid JSON")
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return nil, errors.E("invalid JSON: unexpected data after the top-level value",
+			"offset", dec.InputOffset())
+	}
+	return v, nil
+}
+
+// encodeJSON marshals v without HTML escaping.
+func encodeJSON(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", errors.WrapE(err, "marshal JSON")
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// ProjectJSON returns a new JSON object containing only the listed dotted paths
+// of jsonStr (e.g. "user.name"), recreating the nested structure as needed.
+// Path segments address object keys; paths that are absent, or that run through
+// a non-object value, are omitted. The document must be a JSON object.
+func ProjectJSON(jsonStr string, paths []string) (string, error) {
+	doc, err := decodeJSON(jsonStr)
+	if err != nil {
+		return "", err
+	}
+	src, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", errors.E("JSON document must be an object")
+	}
+
+	result := make(map[string]interface{})
+	for _, path := range paths {
+		segments := strings.Split(path, ".")
+		value, found := lookupPath(src, segments)
+		if !found {
+			continue
+		}
+		setPath(result, segments, value)
+	}
+	return encodeJSON(result)
+}
+
+// lookupPath walks segments through nested objects.
+func lookupPath(obj map[string]interface{}, segments []string) (interface{}, bool) {
+	var cur interface{} = obj
+	for _, seg := range segments {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[seg]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setPath stores value at segments, creating intermediate objects. If a prefix
+// of the path already holds a non-object value, that value is kept.
+func setPath(obj map[string]interface{}, segments []string, value interface{}) {
+	cur := obj
+	for _, seg := range segments[:len(segments)-1] {
+		next, ok := cur[seg].(map[string]interface{})
+		if !ok {
+			if _, exists := cur[seg]; exists {
+				return
+			}
+			next = make(map[string]interface{})
+			cur[seg] = next
+		}
+		cur = next
+	}
+	cur[segments[len(segments)-1]] = value
+}
+
+// MergeJSONArrayByKey merges two JSON arrays of objects, matching elements by the
+// value of the top-level field key (values are compared by their JSON encoding,
+// so 1 and "1" are different keys). For each element of overrideArr:
+//   - if a base element has the same key, the override's fields are overlaid
+//     onto it (shallow: top-level fields replace those of the base element);
+//   - otherwise it is appended to the result, in override order.
+//
+// Elements lacking the key, or that are not objects, are never matched: base
+// ones stay in place and override ones are appended unchanged. When several
+// base elements share a key, only the first is updated. Base order is kept.
+func MergeJSONArrayByKey(baseArr, overrideArr string, key string) (string, error) {
+	base, err := decodeJSONArray(baseArr)
+	if err != nil {
+		return "", errors.WrapE(err, "decode base array")
+	}
+	override, err := decodeJSONArray(overrideArr)
+	if err != nil {
+		return "", errors.WrapE(err, "decode override array")
+	}
+
+	result := make([]interface{}, len(base), len(base)+len(override))
+	copy(result, base)
+	index := make(map[string]int)
+	for i, elem := range result {
+		if k, ok := elementKey(elem, key); ok {
+			if _, seen := index[k]; !seen {
+				index[k] = i
+			}
+		}
+	}
+
+	for _, elem := range override {
+		k, ok := elementKey(elem, key)
+		if !ok {
+			result = append(result, elem)
+			continue
+		}
+		i, found := index[k]
+		if !found {
+			index[k] = len(result)
+			result = append(result, elem)
+			continue
+		}
+		merged := make(map[string]interface{})
+		for f, v := range result[i].(map[string]interface{}) {
+			merged[f] = v
+		}
+		for f, v := range elem.(map[string]interface{}) {
+			merged[f] = v
+		}
+		result[i] = merged
+	}
+	return encodeJSON(result)
+}
+
+// decodeJSONArray decodes s, which must be a JSON array.
+func decodeJSONArray(s string) ([]interface{}, error) {
+	v, err := decodeJSON(s)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.E("JSON document must be an array")
+	}
+	return arr, nil
+}
+
+// elementKey returns the JSON encoding of elem[key] if elem is an object holding key.
+func elementKey(elem interface{}, key string) (string, bool) {
+	obj, ok := elem.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	v, ok := obj[key]
+	if !ok {
+		return "", false
+	}
+	k, err := encodeJSON(v)
+	if err != nil {
+		return "", false
+	}
+	return k, true
+}
+
+// JSONEqual reports whether a and b hold the same JSON value: object key
+// order, whitespace and number formatting are ignored, so `{"x":1.0,"y":[1e2]}`
+// equals `{"y":[100],"x":1}`. Numbers are compared exactly, without float64
+// rounding; arrays compare element by element, in order. An error is returned
+// if either input is not valid JSON.
+func JSONEqual(a, b string) (bool, error) {
+	va, err := decodeJSON(a)
+	if err != nil {
+		return false, errors.WrapE(err, "decode first JSON")
+	}
+	vb, err := decodeJSON(b)
+	if err != nil {
+		return false, errors.WrapE(err, "decode second JSON")
+	}
+	return jsonValuesEqual(va, vb), nil
+}
+
+// jsonValuesEqual compares two values produced by decodeJSON.
+func jsonValuesEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !jsonValuesEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonValuesEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		rx, okx := new(big.Rat).SetString(string(x))
+		ry, oky := new(big.Rat).SetString(string(y))
+		return okx && oky && rx.Cmp(ry) == 0
+	default: // string, bool or nil
+		return a == b
+	}
+}
@@ -0,0 +1,161 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectJSON(t *testing.T) {
+	doc := `{
+		"id": 12345678901234567890,
+		"user": {"name": "alice", "email": "a@example.com", "address": {"city": "Beijing", "zip": "100000"}},
+		"tags": ["x", "y"],
+		"debug": true
+	}`
+
+	t.Run("nested paths", func(t *testing.T) {
+		out, err := common.ProjectJSON(doc, []string{"id", "user.name", "user.address.city"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"id":12345678901234567890,"user":{"name":"alice","address":{"city":"Beijing"}}}`, out)
+	})
+
+	t.Run("whole subtree and arrays", func(t *testing.T) {
+		out, err := common.ProjectJSON(doc, []string{"user.address", "tags"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"user":{"address":{"city":"Beijing","zip":"100000"}},"tags":["x","y"]}`, out)
+	})
+
+	t.Run("absent paths are omitted", func(t *testing.T) {
+		out, err := common.ProjectJSON(doc, []string{"missing", "user.phone", "tags.0", "user.name"})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"user":{"name":"alice"}}`, out)
+	})
+
+	t.Run("no paths", func(t *testing.T) {
+		out, err := common.ProjectJSON(doc, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, `{}`, out)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := common.ProjectJSON(`{"a":`, []string{"a"})
+		assert.Error(t, err)
+		_, err = common.ProjectJSON(`[1,2]`, []string{"a"})
+		assert.Error(t, err)
+		_, err = common.ProjectJSON(`{"a":1} garbage`, []string{"a"})
+		assert.Error(t, err)
+	})
+}
+
+func TestMergeJSONArrayByKey(t *testing.T) {
+	base := `[
+		{"id": 1, "name": "a", "port": 80},
+		{"id": 2, "name": "b"},
+		{"name": "no-id"}
+	]`
+
+	t.Run("update matching elements", func(t *testing.T) {
+		out, err := common.MergeJSONArrayByKey(base, `[{"id": 2, "name": "B", "tls": true}]`, "id")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"id": 1, "name": "a", "port": 80},
+			{"id": 2, "name": "B", "tls": true},
+			{"name": "no-id"}
+		]`, out)
+	})
+
+	t.Run("append new elements", func(t *testing.T) {
+		out, err := common.MergeJSONArrayByKey(base, `[{"id": 3, "name": "c"}, {"id": 1, "port": 443}]`, "id")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"id": 1, "name": "a", "port": 443},
+			{"id": 2, "name": "b"},
+			{"name": "no-id"},
+			{"id": 3, "name": "c"}
+		]`, out)
+	})
+
+	t.Run("elements missing the key", func(t *testing.T) {
+		out, err := common.MergeJSONArrayByKey(base, `[{"name": "no-id"}, "scalar", {"id": "1"}]`, "id")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"id": 1, "name": "a", "port": 80},
+			{"id": 2, "name": "b"},
+			{"name": "no-id"},
+			{"name": "no-id"},
+			"scalar",
+			{"id": "1"}
+		]`, out)
+	})
+
+	t.Run("empty base", func(t *testing.T) {
+		out, err := common.MergeJSONArrayByKey(`[]`, `[{"id": 1}]`, "id")
+		assert.NoError(t, err)
+		assert.Equal(t, `[{"id":1}]`, out)
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := common.MergeJSONArrayByKey(`{"id": 1}`, `[]`, "id")
+		assert.Error(t, err)
+		_, err = common.MergeJSONArrayByKey(`[]`, `[`, "id")
+		assert.Error(t, err)
+		_, err = common.MergeJSONArrayByKey(`[] []`, `[]`, "id")
+		assert.Error(t, err)
+	})
+}
+
+func TestJSONEqual(t *testing.T) {
+	t.Run("equal despite formatting", func(t *testing.T) {
+		pairs := [][2]string{
+			{`{"a":1,"b":[1,2,{"c":null}]}`, "{\n  \"b\": [1, 2, {\"c\": null}],\n  \"a\": 1\n}"},
+			{`{"x":1.0,"y":[1e2,0.5]}`, `{"y":[100,5e-1],"x":1}`},
+			{`12345678901234567890`, `1.2345678901234567890e19`},
+			{`"text"`, ` "text" `},
+			{`[]`, `[ ]`},
+		}
+		for _, p := range pairs {
+			eq, err := common.JSONEqual(p[0], p[1])
+			assert.NoError(t, err)
+			assert.True(t, eq, "%s vs %s", p[0], p[1])
+		}
+	})
+
+	t.Run("different values", func(t *testing.T) {
+		pairs := [][2]string{
+			{`{"a":1}`, `{"a":2}`},
+			{`{"a":1}`, `{"a":1,"b":2}`},
+			{`{"a":{"b":[1,2]}}`, `{"a":{"b":[2,1]}}`},
+			{`{"a":"1"}`, `{"a":1}`},
+			{`12345678901234567890`, `12345678901234567891`}, // float64 would round both alike
+			{`null`, `false`},
+			{`[1]`, `{"0":1}`},
+		}
+		for _, p := range pairs {
+			eq, err := common.JSONEqual(p[0], p[1])
+			assert.NoError(t, err)
+			assert.False(t, eq, "%s vs %s", p[0], p[1])
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		_, err := common.JSONEqual(`{"a":`, `{}`)
+		assert.Error(t, err)
+		_, err = common.JSONEqual(`{}`, `nope`)
+		assert.Error(t, err)
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		for _, doc := range []string{`{"a":1} garbage`, `{"a":1}{"a":1}`, `[1] ]`, `1 2`} {
+			_, err := common.JSONEqual(doc, `{"a":1}`)
+			assert.Error(t, err, doc)
+			_, err = common.JSONEqual(`{"a":1}`, doc)
+			assert.Error(t, err, doc)
+		}
+		// Trailing whitespace is fine
+		eq, err := common.JSONEqual("{\"a\":1} \n\t", `{"a":1}`)
+		assert.NoError(t, err)
+		assert.True(t, eq)
+	})
+}
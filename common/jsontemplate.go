@@ -0,0 +1,102 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// RenderJSONTemplate substitutes ${name} placeholders in the JSON template
+// tmpl with values from vars, encoding each value for the position it occupies:
+//
+//   - inside a JSON string ("Hello ${user}"), the value is escaped as string
+//     content, so quotes, backslashes and control characters stay valid;
+//   - elsewhere ({"port": ${port}}), a value that is a JSON number, true,
+//     false or null is inserted as is, and any other value as a quoted JSON
+//     string. Quote the placeholder ("${port}") to always get a string.
+//
+// "$$" produces a literal "$"; any other "$" not followed by "{" is kept as
+// is. Substituted values are not expanded again. An undefined variable, a
+// malformed placeholder or a result that is not valid JSON is an error.
+func RenderJSONTemplate(tmpl string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(tmpl); {
+		c := tmpl[i]
+		if c != '$' || i+1 == len(tmpl) || (tmpl[i+1] != '{' && tmpl[i+1] != '$') {
+			switch {
+			case escaped:
+				escaped = false
+			case inString && c == '\\':
+				escaped = true
+			case c == '"':
+				inString = !inString
+			}
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if tmpl[i+1] == '$' {
+			b.WriteByte('$')
+			i += 2
+			continue
+		}
+
+		end := strings.IndexByte(tmpl[i+2:], '}')
+		if end < 0 {
+			return "", errors.E("unterminated placeholder", "offset", i)
+		}
+		name := tmpl[i+2 : i+2+end]
+		if name == "" || nameLen(name) != len(name) {
+			return "", errors.E("invalid placeholder", "placeholder", tmpl[i:i+3+end], "offset", i)
+		}
+		value, ok := vars[name]
+		if !ok {
+			return "", errors.E("undefined variable", "name", name, "offset", i)
+		}
+		switch {
+		case inString:
+			quoted := jsonString(value)
+			b.WriteString(quoted[1 : len(quoted)-1])
+		case isJSONScalar(value):
+			b.WriteString(value)
+		default:
+			b.WriteString(jsonString(value))
+		}
+		i += 3 + end
+	}
+
+	out := b.String()
+	if !json.Valid([]byte(out)) {
+		return "", errors.E("rendered template is not valid JSON", "output", TruncateRunes(out, 200))
+	}
+	return out, nil
+}
+
+// jsonString returns s as a quoted JSON string, without escaping HTML
+// characters.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s) // Encoding a string cannot fail
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// isJSONScalar reports whether s is, verbatim, a JSON number, true, false or
+// null.
+func isJSONScalar(s string) bool {
+	switch s {
+	case "true", "false", "null":
+		return true
+	case "":
+		return false
+	}
+	if s[0] != '-' && (s[0] < '0' || s[0] > '9') {
+		return false
+	}
+	var n json.Number
+	return json.Unmarshal([]byte(s), &n) == nil
+}
@@ -0,0 +1,62 @@
+package common_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderJSONTemplate(t *testing.T) {
+	t.Run("values escaped inside strings", func(t *testing.T) {
+		vars := map[string]string{
+			"name": `Bob "the builder" O'Neil`,
+			"path": `C:\temp\new`,
+			"note": "line1\nline2\ttab <b>&</b> 日本",
+		}
+		out, err := common.RenderJSONTemplate(`{"greeting": "Hi ${name}!", "path": "${path}", "note": "${note}"}`, vars)
+		assert.NoError(t, err)
+
+		var got map[string]string
+		assert.NoError(t, json.Unmarshal([]byte(out), &got))
+		assert.Equal(t, "Hi "+vars["name"]+"!", got["greeting"])
+		assert.Equal(t, vars["path"], got["path"])
+		assert.Equal(t, vars["note"], got["note"])
+		assert.Contains(t, out, "<b>&</b>")
+	})
+
+	t.Run("bare positions", func(t *testing.T) {
+		vars := map[string]string{
+			"port": "8080", "ratio": "-0.5e3", "debug": "true", "none": "null",
+			"host": `db "primary"`, "word": "yes", "id": "42",
+		}
+		out, err := common.RenderJSONTemplate(
+			`{"port": ${port}, "ratio": ${ratio}, "debug": ${debug}, "none": ${none}, "host": ${host}, "word": ${word}, "id": "${id}", "list": [${port}, ${word}]}`,
+			vars)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"port": 8080, "ratio": -0.5e3, "debug": true, "none": null,
+			"host": "db \"primary\"", "word": "yes", "id": "42", "list": [8080, "yes"]}`, out)
+	})
+
+	t.Run("escapes and placeholders in keys", func(t *testing.T) {
+		out, err := common.RenderJSONTemplate(`{"${key}": "price $$5, \"quoted ${key}\"", "cost": "$5"}`,
+			map[string]string{"key": `a"b`})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"a\"b": "price $5, \"quoted a\"b\"", "cost": "$5"}`, out)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		vars := map[string]string{"v": "1"}
+		for _, tmpl := range []string{
+			`{"a": ${missing}}`, // undefined variable
+			`{"a": ${v}`,        // not valid JSON after substitution
+			`{"a": "${v"}`,      // unterminated placeholder
+			`{"a": "${1x}"}`,    // invalid name
+			`{"a": ${v} ${v}}`,  // two values in a row
+		} {
+			_, err := common.RenderJSONTemplate(tmpl, vars)
+			assert.Error(t, err, tmpl)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package common
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// nestNode is a node of the tree NestMap builds before deciding, per node,
+// whether it becomes an object or an array.
+type nestNode struct {
+	value    interface{}
+	leaf     bool
+	children map[string]*nestNode
+}
+
+// NestMap is the inverse of flattening: it rebuilds a JSON document from dotted
+// keys such as "a.b.0" and "a.b.1". A node whose child segments are all
+// decimal indices ("0", "1", ..., no leading zeros) becomes an array, any other
+// node an object; the root follows the same rule. Values are encoded as they
+// are, so strings stay strings.
+//
+// It fails on empty keys or segments, on a key that is both a value and a
+// prefix of another key ("a" and "a.b"), on index and name segments under the
+// same node ("a.0" and "a.x"), and on sparse arrays, i.e. indices that do not
+// cover 0..n-1. An empty map yields "{}".
+func NestMap(flat map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	root := &nestNode{}
+	for _, key := range keys {
+		if key == "" {
+			return "", errors.E("empty key")
+		}
+		cur := root
+		segments := strings.Split(key, ".")
+		for i, seg := range segments {
+			if seg == "" {
+				return "", errors.E("empty path segment", "key", key)
+			}
+			if cur.leaf {
+				return "", errors.E("key conflicts with a value at its prefix",
+					"key", key, "prefix", strings.Join(segments[:i], "."))
+			}
+			if cur.children == nil {
+				cur.children = make(map[string]*nestNode)
+			}
+			next, ok := cur.children[seg]
+			if !ok {
+				next = &nestNode{}
+				cur.children[seg] = next
+			}
+			cur = next
+		}
+		if cur.children != nil {
+			return "", errors.E("key conflicts with nested keys below it", "key", key)
+		}
+		cur.leaf = true
+		cur.value = flat[key]
+	}
+
+	if root.children == nil {
+		return "{}", nil
+	}
+	doc, err := root.build("")
+	if err != nil {
+		return "", err
+	}
+	return encodeJSON(doc)
+}
+
+// build converts n into a leaf value, an object or an array. path is n's
+// dotted key, for error context.
+func (n *nestNode) build(path string) (interface{}, error) {
+	if n.leaf {
+		return n.value, nil
+	}
+
+	indices := 0
+	for seg := range n.children {
+		if isArrayIndex(seg) {
+			indices++
+		}
+	}
+	if indices > 0 && indices < len(n.children) {
+		return nil, errors.E("mixed array indices and object keys", "path", path)
+	}
+
+	if indices == 0 {
+		obj := make(map[string]interface{}, len(n.children))
+		for seg, child := range n.children {
+			v, err := child.build(joinPath(path, seg))
+			if err != nil {
+				return nil, err
+			}
+			obj[seg] = v
+		}
+		return obj, nil
+	}
+
+	arr := make([]interface{}, len(n.children))
+	for seg, child := range n.children {
+		i, err := strconv.Atoi(seg)
+		if err != nil || i >= len(arr) {
+			return nil, errors.E("sparse array indices", "path", path,
+				"index", seg, "elements", len(arr))
+		}
+		v, err := child.build(joinPath(path, seg))
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+// isArrayIndex reports whether seg is a canonical non-negative decimal integer.
+func isArrayIndex(seg string) bool {
+	if seg == "" || (len(seg) > 1 && seg[0] == '0') {
+		return false
+	}
+	for _, c := range seg {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// joinPath appends seg to the dotted path.
+func joinPath(path, seg string) string {
+	if path == "" {
+		return seg
+	}
+	return path + "." + seg
+}
@@ -0,0 +1,66 @@
+package common_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNestMap(t *testing.T) {
+	t.Run("objects and arrays", func(t *testing.T) {
+		out, err := common.NestMap(map[string]interface{}{
+			"name":           "svc",
+			"a.b.0":          "x",
+			"a.b.1":          "y",
+			"a.c":            true,
+			"ports.0.port":   80,
+			"ports.0.proto":  "tcp",
+			"ports.1.port":   json.Number("443"),
+			"matrix.0.0":     1,
+			"matrix.0.1":     2,
+			"matrix.1.0":     3,
+			"labels.01":      "not an index",
+			"labels.version": "v1",
+		})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"name": "svc",
+			"a": {"b": ["x", "y"], "c": true},
+			"ports": [{"port": 80, "proto": "tcp"}, {"port": 443}],
+			"matrix": [[1, 2], [3]],
+			"labels": {"01": "not an index", "version": "v1"}
+		}`, out)
+	})
+
+	t.Run("array root and empty map", func(t *testing.T) {
+		out, err := common.NestMap(map[string]interface{}{"0": "a", "1.k": nil})
+		assert.NoError(t, err)
+		assert.JSONEq(t, `["a", {"k": null}]`, out)
+
+		out, err = common.NestMap(map[string]interface{}{})
+		assert.NoError(t, err)
+		assert.Equal(t, `{}`, out)
+	})
+
+	t.Run("round trip values stay strings", func(t *testing.T) {
+		out, err := common.NestMap(map[string]interface{}{"a.0": "1", "a.1": "true"})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":["1","true"]}`, out)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for name, flat := range map[string]map[string]interface{}{
+			"sparse indices":   {"a.0": 1, "a.2": 3},
+			"missing zero":     {"a.1": 1},
+			"value and prefix": {"a": 1, "a.b": 2},
+			"mixed segments":   {"a.0": 1, "a.x": 2},
+			"empty segment":    {"a..b": 1},
+			"empty key":        {"": 1},
+		} {
+			_, err := common.NestMap(flat)
+			assert.Error(t, err, name)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package common
+
+import "sync"
+
+// Number is the constraint of the values Inc can add: any type whose
+// underlying type is an integer or floating-point type.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// SyncMap is a mutex-guarded map with string keys, safe for concurrent use,
+// intended for accumulating values (e.g. counters) from many goroutines and
+// serializing them as a JSON object. The zero value is ready to use.
+type SyncMap[V any] struct {
+	mu sync.RWMutex
+	m  map[string]V
+}
+
+// Set stores value under key.
+func (s *SyncMap[V]) Set(key string, value V) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]V)
+	}
+	s.m[key] = value
+}
+
+// Get returns the value stored under key and whether it was present.
+func (s *SyncMap[V]) Get(key string) (V, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.m[key]
+	return v, ok
+}
+
+// Delete removes key.
+func (s *SyncMap[V]) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.m, key)
+}
+
+// Inc atomically adds delta to the value of s under key (starting from the
+// zero value if absent) and returns the result. It is a function rather than a
+// method so that the compiler restricts it to numeric maps.
+func Inc[V Number](s *SyncMap[V], key string, delta V) V {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[string]V)
+	}
+	s.m[key] += delta
+	return s.m[key]
+}
+
+// Snapshot returns a copy of the map taken under a single lock, so it reflects
+// one consistent point in time.
+func (s *SyncMap[V]) Snapshot() map[string]V {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := make(map[string]V, len(s.m))
+	for k, v := range s.m {
+		snap[k] = v
+	}
+	return snap
+}
+
+// ToJSON serializes a snapshot of the map as a JSON object with sorted keys.
+func (s *SyncMap[V]) ToJSON() (string, error) {
+	return encodeJSON(s.Snapshot())
+}
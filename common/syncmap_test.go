@@ -0,0 +1,90 @@
+package common_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncMap(t *testing.T) {
+	t.Run("concurrent increments", func(t *testing.T) {
+		var m common.SyncMap[int64]
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					common.Inc(&m, "total", 1)
+					common.Inc(&m, fmt.Sprintf("worker-%d", g%4), 2)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		v, ok := m.Get("total")
+		assert.True(t, ok)
+		assert.Equal(t, int64(16000), v)
+		out, err := m.ToJSON()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"total":16000,"worker-0":8000,"worker-1":8000,"worker-2":8000,"worker-3":8000}`, out)
+	})
+
+	t.Run("consistent snapshot", func(t *testing.T) {
+		// a and b are always incremented together under one Inc each, so any
+		// snapshot must satisfy a >= b and a - b <= number of writers.
+		var m common.SyncMap[int]
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+						common.Inc(&m, "a", 1)
+						common.Inc(&m, "b", 1)
+					}
+				}
+			}()
+		}
+		for i := 0; i < 200; i++ {
+			snap := m.Snapshot()
+			assert.GreaterOrEqual(t, snap["a"], snap["b"])
+			assert.LessOrEqual(t, snap["a"]-snap["b"], 4)
+			snap["a"] = -1 // the snapshot is a copy
+		}
+		close(stop)
+		wg.Wait()
+		a, _ := m.Get("a")
+		b, _ := m.Get("b")
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("set get delete", func(t *testing.T) {
+		type ratio float64
+		var m common.SyncMap[ratio]
+		_, ok := m.Get("x")
+		assert.False(t, ok)
+		m.Set("x", 0.5)
+		assert.Equal(t, ratio(0.75), common.Inc(&m, "x", 0.25))
+		m.Delete("x")
+		out, err := m.ToJSON()
+		assert.NoError(t, err)
+		assert.Equal(t, `{}`, out)
+	})
+
+	t.Run("non-numeric values", func(t *testing.T) {
+		var m common.SyncMap[[]string]
+		m.Set("tags", []string{"a", "<b>"})
+		out, err := m.ToJSON()
+		assert.NoError(t, err)
+		assert.Equal(t, `{"tags":["a","<b>"]}`, out)
+	})
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// ExpandOption configures ExpandTemplate.
+type ExpandOption func(*expandOptions)
+
+type expandOptions struct {
+	keepUndefined bool
+}
+
+// WithKeepUndefined makes ExpandTemplate leave placeholders of undefined
+// variables in the output verbatim instead of returning an error.
+func WithKeepUndefined() ExpandOption {
+	return func(o *expandOptions) {
+		o.keepUndefined = true
+	}
+}
+
+// ExpandTemplate substitutes ${name} and $name placeholders in s with values
+// from vars. Names match [A-Za-z_][A-Za-z0-9_]*; the $name form takes the
+// longest such name. "$$" produces a literal "$", and a "$" not followed by a
+// name or "{" is kept as is. Substituted values are not expanded again.
+//
+// An undefined variable is an error unless WithKeepUndefined is given. A "${"
+// without a closing brace, or braces not enclosing a valid name (including
+// nested-looking forms such as "${a${b}}"), is always an error.
+func ExpandTemplate(s string, vars map[string]string, opts ...ExpandOption) (string, error) {
+	var o expandOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		var name, placeholder string
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i += 2
+			continue
+		case next == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				return "", errors.E("unterminated placeholder", "offset", i)
+			}
+			name = s[i+2 : i+2+end]
+			placeholder = s[i : i+3+end]
+			if nameLen(name) != len(name) || name == "" {
+				return "", errors.E("invalid placeholder", "placeholder", placeholder, "offset", i)
+			}
+		default:
+			n := nameLen(s[i+1:])
+			if n == 0 {
+				b.WriteByte('$')
+				i++
+				continue
+			}
+			name = s[i+1 : i+1+n]
+			placeholder = s[i : i+1+n]
+		}
+
+		value, ok := vars[name]
+		switch {
+		case ok:
+			b.WriteString(value)
+		case o.keepUndefined:
+			b.WriteString(placeholder)
+		default:
+			return "", errors.E("undefined variable", "name", name, "offset", i)
+		}
+		i += len(placeholder)
+	}
+	return b.String(), nil
+}
+
+// nameLen returns the length of the variable name at the start of s.
+func nameLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,66 @@
+package common_test
+
+import (
+	"testing"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	vars := map[string]string{
+		"host":   "10.0.0.1",
+		"port":   "22",
+		"dir":    "/data/$HOME",
+		"_x1":    "X",
+		"prefix": "p",
+	}
+
+	t.Run("defined variables", func(t *testing.T) {
+		cases := map[string]string{
+			"ssh -p ${port} root@${host}": "ssh -p 22 root@10.0.0.1",
+			"ssh -p $port root@$host":     "ssh -p 22 root@10.0.0.1",
+			"${prefix}_suffix":            "p_suffix",
+			"$prefix-suffix":              "p-suffix",
+			"$_x1$_x1":                    "XX",
+			"cd $dir":                     "cd /data/$HOME", // values are not expanded again
+			"cost: $$5, ${port}$$":        "cost: $5, 22$",
+			"$$host":                      "$host",
+			"trailing $":                  "trailing $",
+			"$1 and $-":                   "$1 and $-",
+			"":                            "",
+		}
+		for in, want := range cases {
+			out, err := common.ExpandTemplate(in, vars)
+			assert.NoError(t, err, in)
+			assert.Equal(t, want, out, in)
+		}
+	})
+
+	t.Run("undefined variables", func(t *testing.T) {
+		_, err := common.ExpandTemplate("ssh $user@${host}", vars)
+		assert.ErrorContains(t, err, "undefined variable")
+
+		// $prefix_suffix is the single name "prefix_suffix"
+		_, err = common.ExpandTemplate("$prefix_suffix", vars)
+		assert.ErrorContains(t, err, "undefined variable")
+
+		out, err := common.ExpandTemplate("ssh ${user}@$host:$dir2", vars, common.WithKeepUndefined())
+		assert.NoError(t, err)
+		assert.Equal(t, "ssh ${user}@10.0.0.1:$dir2", out)
+	})
+
+	t.Run("nested-looking placeholders", func(t *testing.T) {
+		for _, in := range []string{"${a${host}}", "${}", "${host", "${ho st}", "${1x}"} {
+			_, err := common.ExpandTemplate(in, vars)
+			assert.Error(t, err, in)
+			_, err = common.ExpandTemplate(in, vars, common.WithKeepUndefined())
+			assert.Error(t, err, in)
+		}
+
+		// "$${host}" escapes the dollar, leaving a literal placeholder
+		out, err := common.ExpandTemplate("$${host}", vars)
+		assert.NoError(t, err)
+		assert.Equal(t, "${host}", out)
+	})
+}
@@ -0,0 +1,51 @@
+package common
+
+import "unicode/utf8"
+
+// truncMarker is appended by TruncateRunes and TruncateBytes when they cut s.
+const truncMarker = "..."
+
+// TruncateRunes returns s unchanged if it has at most maxRunes runes, and
+// otherwise its leading runes followed by "...", maxRunes runes in total.
+// When maxRunes is too small to hold the marker, s is cut without it.
+// A negative maxRunes is treated as 0.
+func TruncateRunes(s string, maxRunes int) string {
+	if utf8.RuneCountInString(s) <= maxRunes {
+		return s
+	}
+	keep := max(0, maxRunes)
+	if keep > len(truncMarker) {
+		keep -= len(truncMarker)
+	}
+	i := 0
+	for n := 0; n < keep; n++ {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	if maxRunes <= len(truncMarker) {
+		return s[:i]
+	}
+	return s[:i] + truncMarker
+}
+
+// TruncateBytes returns s unchanged if it is at most maxBytes bytes long, and
+// otherwise a prefix followed by "...", at most maxBytes bytes in total. The
+// cut backs off to a rune boundary, so a multibyte rune is never split and the
+// result may be a few bytes shorter than maxBytes. When maxBytes is too small
+// to hold the marker, s is cut without it. A negative maxBytes is treated as 0.
+func TruncateBytes(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := max(0, maxBytes)
+	if cut > len(truncMarker) {
+		cut -= len(truncMarker)
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if maxBytes <= len(truncMarker) {
+		return s[:cut]
+	}
+	return s[:cut] + truncMarker
+}
@@ -0,0 +1,49 @@
+package common_test
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/kaichao/gopkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	// "héllo 世界" is 8 runes, 12 bytes
+	s := "héllo 世界"
+	assert.Equal(t, s, common.TruncateRunes(s, 8))
+	assert.Equal(t, s, common.TruncateRunes(s, 100))
+	assert.Equal(t, "héllo 世界!!", common.TruncateRunes("héllo 世界!!", 10))
+	assert.Equal(t, "héllo 世...", common.TruncateRunes("héllo 世界!!!", 10))
+	assert.Equal(t, "héll...", common.TruncateRunes(s, 7))
+	assert.Equal(t, "h...", common.TruncateRunes(s, 4))
+	assert.Equal(t, "hé", common.TruncateRunes(s, 2))
+	assert.Equal(t, "", common.TruncateRunes(s, 0))
+	assert.Equal(t, "", common.TruncateRunes(s, -1))
+	assert.Equal(t, "世界世界世界", common.TruncateRunes("世界世界世界", 6))
+	assert.Equal(t, "世界世...", common.TruncateRunes("世界世界世界世", 6))
+	assert.Equal(t, "", common.TruncateRunes("", 0))
+}
+
+func TestTruncateBytes(t *testing.T) {
+	// "世界世界" is 4 runes of 3 bytes each
+	s := "世界世界"
+	assert.Equal(t, s, common.TruncateBytes(s, 12))
+	assert.Equal(t, "世界...", common.TruncateBytes(s, 11))
+	assert.Equal(t, "世界...", common.TruncateBytes(s, 9))
+	assert.Equal(t, "世...", common.TruncateBytes(s, 8))
+	assert.Equal(t, "世...", common.TruncateBytes(s, 6))
+	assert.Equal(t, "...", common.TruncateBytes(s, 5))
+	assert.Equal(t, "世", common.TruncateBytes(s, 3))
+	assert.Equal(t, "", common.TruncateBytes(s, 2))
+	assert.Equal(t, "", common.TruncateBytes(s, -1))
+	assert.Equal(t, "abcd...", common.TruncateBytes("abcdefghij", 7))
+
+	// Every result is valid UTF-8 and within the limit
+	mixed := "aé世😀b"
+	for n := 0; n <= len(mixed); n++ {
+		got := common.TruncateBytes(mixed, n)
+		assert.True(t, utf8.ValidString(got), "n=%d got %q", n, got)
+		assert.LessOrEqual(t, len(got), n, "n=%d got %q", n, got)
+	}
+}
@@ -4,25 +4,25 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
-
-	"github.com/patrickmn/go-cache"
 )
 
-// DBCache provides a generic caching layer for database queries.
+// DBCache provides a generic caching layer for database queries. It is a
+// KeyedCache[string, T] keyed by the formatted query params.
 type DBCache[T any] struct {
-	db         *sql.DB                 // Database connection
-	cache      *cache.Cache            // In-memory cache
-	sql        string                  // SQL template for query
-	defaultExp time.Duration           // Default cache expiration
-	loadFunc   func(...any) (T, error) // Custom loader function
+	db       *sql.DB                 // Database connection
+	cache    *KeyedCache[string, T]  // In-memory cache
+	sql      string                  // SQL template for query
+	loadFunc func(...any) (T, error) // Custom loader function
 }
 
-// New ...
+// New creates a cache; callers should call Close when done with it so the
+// background cleanup goroutine is stopped promptly.
 func New[T any](
 	db *sql.DB,
 	sqlTemplate string,
 	defaultExp, cleanupInterval time.Duration,
 	loader func(...any) (T, error),
+	opts ...Option,
 ) *DBCache[T] {
 	if loader == nil {
 		loader = func(params ...any) (T, error) {
@@ -36,26 +36,70 @@ func New[T any](
 	}
 
 	return &DBCache[T]{
-		db:         db,
-		cache:      cache.New(defaultExp, cleanupInterval),
-		sql:        sqlTemplate,
-		defaultExp: defaultExp,
-		loadFunc:   loader,
+		db:       db,
+		cache:    newEngine[string, T](defaultExp, cleanupInterval, opts),
+		sql:      sqlTemplate,
+		loadFunc: loader,
 	}
 }
 
+// Close stops the background cleanup goroutine. Cached values remain readable,
+// but expired items are no longer purged. Close is safe to call more than once.
+// A cache that is dropped without Close has its goroutine stopped once the
+// garbage collector reclaims it, which may take a while.
+func (c *DBCache[T]) Close() {
+	c.cache.Close()
+}
+
+// Get returns the cached value for params, loading and caching it on a miss.
 func (c *DBCache[T]) Get(params ...any) (T, error) {
 	key := fmt.Sprintf("%v", params)
+	return c.cache.getOrLoad(key, func() (T, error) { return c.loadFunc(params...) })
+}
 
-	if val, found := c.cache.Get(key); found {
-		return val.(T), nil
-	}
+// SetCachePredicate makes Get cache a loaded value only when fn returns true
+// for it; other values are returned but not stored, so the next Get loads them
+// again. Use it to keep empty or fallback results out of the cache. A nil fn
+// restores the default of caching every value. Entries already cached are
+// kept.
+func (c *DBCache[T]) SetCachePredicate(fn func(T) bool) {
+	c.cache.SetCachePredicate(fn)
+}
 
-	result, err := c.loadFunc(params...)
-	if err != nil {
-		return result, err
-	}
+// Entry is a cached value together with its expiration time, as produced by
+// Snapshot. A zero Expiration means the entry never expires.
+type Entry[T any] struct {
+	Value      T         `json:"value"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// Items returns a copy of the current unexpired entries, keyed by cache key.
+func (c *DBCache[T]) Items() map[string]T {
+	return c.cache.Items()
+}
+
+// Snapshot returns the current unexpired entries with their expiration times,
+// suitable for persisting (e.g. with encoding/json) and reloading via Restore.
+func (c *DBCache[T]) Snapshot() map[string]Entry[T] {
+	return c.cache.Snapshot()
+}
+
+// Restore loads entries produced by Snapshot, keeping each entry's original
+// expiration time. Entries that have expired in the meantime are skipped;
+// existing entries with the same key are overwritten.
+func (c *DBCache[T]) Restore(entries map[string]Entry[T]) {
+	c.cache.Restore(entries)
+}
+
+// Clear removes all entries from the cache.
+func (c *DBCache[T]) Clear() {
+	c.cache.Clear()
+}
 
-	c.cache.Set(key, result, c.defaultExp)
-	return result, nil
+// TopKeys returns up to n of the most accessed keys, most accessed first, or
+// nil unless the cache was created with WithKeyStats. A negative n returns all
+// tracked keys. Counts are approximate once more distinct keys have been
+// accessed than the tracking capacity (see WithKeyStats).
+func (c *DBCache[T]) TopKeys(n int) []KeyStat {
+	return c.cache.TopKeys(n)
 }
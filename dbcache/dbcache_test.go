@@ -2,7 +2,9 @@ package dbcache_test
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"runtime"
 	"testing"
 	"time"
 
@@ -47,3 +49,158 @@ func TestDBCache_Integration(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "Alice", name)
 }
+
+func TestDBCache_Close(t *testing.T) {
+	loader := func(params ...any) (int, error) { return params[0].(int) * 2, nil }
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		c := dbcache.New[int](nil, "", time.Minute, time.Millisecond, loader)
+		v, err := c.Get(i)
+		require.NoError(t, err)
+		assert.Equal(t, i*2, v)
+		c.Close()
+		c.Close() // idempotent
+	}
+
+	// Janitor goroutines exit asynchronously after Close.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "janitor goroutines leaked")
+}
+
+func TestDBCache_CollectedWithoutClose(t *testing.T) {
+	loader := func(params ...any) (int, error) { return params[0].(int) * 2, nil }
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		c := dbcache.New[int](nil, "", time.Minute, time.Millisecond, loader)
+		_, err := c.Get(i)
+		require.NoError(t, err)
+		// Dropped without Close.
+	}
+
+	// Janitor goroutines exit once the collector reclaims their caches.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before, "janitor goroutines of unclosed caches leaked")
+}
+
+func TestDBCache_SnapshotRestore(t *testing.T) {
+	loads := 0
+	loader := func(params ...any) (string, error) {
+		loads++
+		return fmt.Sprintf("value-%v", params[0]), nil
+	}
+
+	c := dbcache.New[string](nil, "", time.Minute, 0, loader)
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		_, err := c.Get(i)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, loads)
+
+	items := c.Items()
+	assert.Equal(t, map[string]string{"[0]": "value-0", "[1]": "value-1", "[2]": "value-2"}, items)
+
+	// Persist the snapshot as a process would across a restart.
+	data, err := json.Marshal(c.Snapshot())
+	require.NoError(t, err)
+
+	c.Clear()
+	assert.Empty(t, c.Items())
+
+	var snapshot map[string]dbcache.Entry[string]
+	require.NoError(t, json.Unmarshal(data, &snapshot))
+	snapshot["[9]"] = dbcache.Entry[string]{Value: "stale", Expiration: time.Now().Add(-time.Second)}
+	c.Restore(snapshot)
+
+	assert.Equal(t, items, c.Items(), "expired entries are not restored")
+	for i := 0; i < 3; i++ {
+		v, err := c.Get(i)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), v)
+	}
+	assert.Equal(t, 3, loads, "restored entries are served without reloading")
+
+	for _, e := range c.Snapshot() {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), e.Expiration, 5*time.Second)
+	}
+}
+
+func TestDBCache_TopKeys(t *testing.T) {
+	loader := func(params ...any) (int, error) { return params[0].(int), nil }
+
+	// Disabled by default.
+	plain := dbcache.New[int](nil, "", time.Minute, 0, loader)
+	defer plain.Close()
+	_, _ = plain.Get(1)
+	assert.Nil(t, plain.TopKeys(5))
+
+	c := dbcache.New[int](nil, "", time.Minute, 0, loader, dbcache.WithKeyStats(10))
+	defer c.Close()
+
+	// Hot keys 1, 2, 3 accessed 50, 30, 20 times; 100 cold keys once each.
+	for i := 0; i < 50; i++ {
+		_, _ = c.Get(1)
+		if i < 30 {
+			_, _ = c.Get(2)
+		}
+		if i < 20 {
+			_, _ = c.Get(3)
+		}
+		_, _ = c.Get(1000 + i)
+		_, _ = c.Get(2000 + i)
+	}
+
+	top := c.TopKeys(3)
+	require.Len(t, top, 3)
+	assert.Equal(t, []string{"[1]", "[2]", "[3]"}, []string{top[0].Key, top[1].Key, top[2].Key})
+	assert.Equal(t, int64(50), top[0].Accesses)
+	assert.Equal(t, int64(30), top[1].Accesses)
+
+	// Tracking is bounded by the capacity.
+	assert.Len(t, c.TopKeys(-1), 10)
+}
+
+func TestDBCache_SetCachePredicate(t *testing.T) {
+	loads := map[int]int{}
+	loader := func(params ...any) ([]string, error) {
+		id := params[0].(int)
+		loads[id]++
+		if id == 0 {
+			return nil, nil // Empty result, not worth caching
+		}
+		return []string{"row"}, nil
+	}
+
+	c := dbcache.New[[]string](nil, "", time.Minute, 0, loader)
+	defer c.Close()
+	c.SetCachePredicate(func(v []string) bool { return len(v) > 0 })
+
+	for i := 0; i < 3; i++ {
+		v, err := c.Get(0)
+		require.NoError(t, err)
+		assert.Empty(t, v)
+		v, err = c.Get(1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"row"}, v)
+	}
+	assert.Equal(t, 3, loads[0], "values failing the predicate are reloaded")
+	assert.Equal(t, 1, loads[1], "values passing the predicate are cached")
+	assert.Len(t, c.Items(), 1)
+
+	// A nil predicate caches every value again.
+	c.SetCachePredicate(nil)
+	_, err := c.Get(0)
+	require.NoError(t, err)
+	_, err = c.Get(0)
+	require.NoError(t, err)
+	assert.Equal(t, 4, loads[0])
+}
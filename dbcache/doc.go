@@ -13,7 +13,7 @@
 // - Type Safety: Generics support for any data type
 // - Cache Control: Configurable expiration and cleanup intervals
 // - Custom Loaders: Optional custom loader functions for complex data loading
-// - Memory Efficiency: Uses go-cache for efficient in-memory storage
+// - Memory Efficiency: Plain map storage with a stoppable cleanup goroutine
 //
 // Use Cases:
 // - Frequently accessed database records (users, products, settings)
@@ -45,6 +45,7 @@
 //	        10*time.Minute, // Cleanup interval
 //	        nil,            // Use default SQL loader
 //	    )
+//	    defer emailCache.Close()
 //
 //	    // Get user email - first call queries database, second uses cache
 //	    email, err := emailCache.Get(123)
@@ -98,6 +99,57 @@
 //	// Get retrieves value from cache or loads it using the SQL template/custom loader
 //	func (c *DBCache[T]) Get(params ...any) (T, error)
 //
+//	// Close stops the background cleanup goroutine; callers should call it when done
+//	// (an unclosed cache has it stopped only once garbage collected)
+//	func (c *DBCache[T]) Close()
+//
+//	// Items returns a copy of the current unexpired entries
+//	func (c *DBCache[T]) Items() map[string]T
+//
+//	// Snapshot returns entries with expiration times; Restore reloads them (warm restart)
+//	func (c *DBCache[T]) Snapshot() map[string]Entry[T]
+//	func (c *DBCache[T]) Restore(entries map[string]Entry[T])
+//
+//	// Clear removes all entries
+//	func (c *DBCache[T]) Clear()
+//
+//	// TopKeys returns the most accessed keys; requires New(..., WithKeyStats(capacity))
+//	func WithKeyStats(capacity int) Option
+//	func (c *DBCache[T]) TopKeys(n int) []KeyStat
+//
+//	// SetCachePredicate caches a loaded value only if fn returns true (default: cache everything)
+//	func (c *DBCache[T]) SetCachePredicate(fn func(T) bool)
+//
+// Key Statistics:
+// WithKeyStats(capacity) tracks per-key access counts (hits and misses) in a
+// min-heap of at most capacity keys using the Space-Saving algorithm, so memory
+// stays bounded however many distinct keys are seen. Any key accessed more than
+// total/capacity times is tracked; a key that took over an evicted slot inherits
+// its count, so counts may be overestimated by up to the smallest tracked count.
+// Tracking is opt-in because every Get then takes a mutex and updates the heap.
+//
+// Typed Keys:
+// KeyedCache[K, V] keys entries by a comparable K (an int id, a struct of
+// several fields) instead of formatting variadic params, so distinct keys never
+// collide. It offers the same methods and options with K in place of params or
+// string keys; DBCache itself is a KeyedCache keyed by the formatted params:
+//
+//	func NewKeyed[K comparable, V any](db *sql.DB, sqlTemplate string, defaultExp, cleanupInterval time.Duration, loader func(K) (V, error), opts ...Option) *KeyedCache[K, V]
+//	func (c *KeyedCache[K, V]) Get(key K) (V, error)
+//	func (c *KeyedCache[K, V]) TopKeys(n int) []KeyStat // keys formatted with %v
+//
+// To migrate, replace New[T] with NewKeyed[K, T] and the func(...any) loader with
+// a func(K) loader; multi-param lookups become a struct key. Without a loader the
+// key is passed as the single $1 query parameter.
+//
+// Warm Restart:
+//
+//	data, _ := json.Marshal(emailCache.Snapshot()) // before shutdown
+//	...
+//	var entries map[string]dbcache.Entry[string]
+//	_ = json.Unmarshal(data, &entries)
+//	emailCache.Restore(entries) // after startup
+//
 // Error Handling:
 // All errors are returned as-is from database operations or custom loader functions.
 // No special error wrapping is applied, allowing callers to handle errors directly.
@@ -109,7 +161,6 @@
 // - Memory usage scales with cached data and expiration settings
 //
 // Dependencies:
-// - github.com/patrickmn/go-cache: In-memory cache implementation
 // - Go 1.18 or higher (generic type support required)
 //
 // License: MIT
@@ -50,6 +50,7 @@ func main() {
 		2*time.Hour,
 		productLoader,
 	)
+	defer productCache.Close()
 
 	// Cache miss - loads via custom loader
 	product, err := productCache.Get(123)
@@ -94,6 +95,7 @@ func main() {
 		time.Hour,
 		multiParamLoader,
 	)
+	defer settingsCache.Close()
 
 	// Cache with multiple parameters
 	settings, err := settingsCache.Get("user", "preferences")
@@ -140,6 +142,7 @@ func main() {
 		10*time.Minute,
 		configLoader,
 	)
+	defer configCache.Close()
 
 	appConfig, err := configCache.Get("app")
 	if err != nil {
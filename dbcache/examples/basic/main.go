@@ -26,6 +26,7 @@ func main() {
 		10*time.Minute, // Cleanup interval for expired items
 		nil,            // Use default SQL loader
 	)
+	defer nameCache.Close()
 
 	// First call queries the database
 	name1, err := nameCache.Get(123)
@@ -51,6 +52,7 @@ func main() {
 		30*time.Minute,
 		nil,
 	)
+	defer ageCache.Close()
 
 	age, err := ageCache.Get(456)
 	if err != nil {
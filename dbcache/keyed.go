@@ -0,0 +1,283 @@
+package dbcache
+
+import (
+	"database/sql"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+	"weak"
+)
+
+// KeyedCache is a DBCache variant keyed by a typed, comparable key instead of
+// variadic params. Keys are compared by value, so distinct keys never collide
+// the way differently typed params can after fmt.Sprintf("%v", ...) formatting
+// (e.g. Get(1) and Get("1") share an entry in DBCache).
+//
+// Migrating from DBCache: replace New[T] with NewKeyed[K, T], change the loader
+// from func(...any) (T, error) to func(K) (T, error), and call Get(key) with a
+// single key value. Multi-param lookups become a struct key:
+//
+//	type userKey struct{ Tenant string; ID int }
+//	c := dbcache.NewKeyed[userKey, string](nil, "", time.Minute, time.Minute,
+//	    func(k userKey) (string, error) { return loadName(db, k.Tenant, k.ID) })
+//
+// Snapshots are keyed by K rather than by formatted strings, so they cannot be
+// exchanged with DBCache snapshots.
+//
+// KeyedCache is also the engine of DBCache, which is a KeyedCache[string, T]
+// keyed by the formatted params, so both support the same options.
+type KeyedCache[K comparable, V any] struct {
+	mu          sync.RWMutex
+	items       map[K]keyedItem[V]
+	defaultExp  time.Duration      // Default cache expiration; <= 0 never expires
+	loadFunc    func(K) (V, error) // Loader called on a miss; nil for the DBCache engine
+	stop        *stopper           // Stops the janitor on Close or when the cache is collected
+	keyStats    *keyStats          // Per-key access counts; nil unless WithKeyStats
+	shouldCache func(V) bool       // Cache predicate, guarded by mu; nil caches every value
+}
+
+// stopper stops a janitor once. It is kept apart from the cache so that the
+// cleanup run when an unclosed cache is collected can reach it.
+type stopper struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+// stop closes ch; it is safe to call more than once.
+func (s *stopper) stop() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+// keyedItem is a cached value with its expiration in UnixNano (0: never).
+type keyedItem[V any] struct {
+	value      V
+	expiration int64
+}
+
+// NewKeyed creates a typed-key cache; callers should call Close when done with
+// it so the background cleanup goroutine is stopped promptly. If loader is nil, the
+// key is passed as the single query parameter ($1) of sqlTemplate, which
+// suits scalar keys; struct keys need a custom loader. It accepts the same
+// options as New, and SetCachePredicate works the same way.
+func NewKeyed[K comparable, V any](
+	db *sql.DB,
+	sqlTemplate string,
+	defaultExp, cleanupInterval time.Duration,
+	loader func(K) (V, error),
+	opts ...Option,
+) *KeyedCache[K, V] {
+	if loader == nil {
+		loader = func(key K) (V, error) {
+			var result V
+			err := db.QueryRow(sqlTemplate, key).Scan(&result)
+			if err == sql.ErrNoRows {
+				return result, nil
+			}
+			return result, err
+		}
+	}
+
+	c := newEngine[K, V](defaultExp, cleanupInterval, opts)
+	c.loadFunc = loader
+	return c
+}
+
+// newEngine creates a KeyedCache without a loader, applying opts and starting
+// the janitor; NewKeyed and New complete it.
+func newEngine[K comparable, V any](defaultExp, cleanupInterval time.Duration, opts []Option) *KeyedCache[K, V] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	c := &KeyedCache[K, V]{
+		items:      make(map[K]keyedItem[V]),
+		defaultExp: defaultExp,
+		stop:       &stopper{ch: make(chan struct{})},
+	}
+	if o.keyStatsCapacity > 0 {
+		c.keyStats = newKeyStats(o.keyStatsCapacity)
+	}
+	if cleanupInterval > 0 {
+		// The janitor holds the cache only weakly, so a cache dropped without
+		// Close is still collected; the cleanup then stops the janitor.
+		go janitor(weak.Make(c), c.stop.ch, cleanupInterval)
+		runtime.AddCleanup(c, (*stopper).stop, c.stop)
+	}
+	return c
+}
+
+// janitor deletes expired items of the cache every interval until it is
+// closed or collected.
+func janitor[K comparable, V any](wc weak.Pointer[KeyedCache[K, V]], stop <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c := wc.Value()
+			if c == nil {
+				return
+			}
+			c.deleteExpired()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// deleteExpired removes all expired items.
+func (c *KeyedCache[K, V]) deleteExpired() {
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, item := range c.items {
+		if item.expired(now) {
+			delete(c.items, k)
+		}
+	}
+}
+
+// expired reports whether the item has expired at now (UnixNano).
+func (item keyedItem[V]) expired(now int64) bool {
+	return item.expiration > 0 && now > item.expiration
+}
+
+// Close stops the background cleanup goroutine. Cached values remain readable,
+// but expired items are no longer purged. Close is safe to call more than once.
+// A cache that is dropped without Close has its goroutine stopped once the
+// garbage collector reclaims it, which may take a while.
+func (c *KeyedCache[K, V]) Close() {
+	c.stop.stop()
+}
+
+// Get returns the cached value for key, loading and caching it on a miss.
+func (c *KeyedCache[K, V]) Get(key K) (V, error) {
+	return c.getOrLoad(key, func() (V, error) { return c.loadFunc(key) })
+}
+
+// getOrLoad returns the cached value for key, calling load on a miss and
+// caching its result unless the cache predicate rejects it.
+func (c *KeyedCache[K, V]) getOrLoad(key K, load func() (V, error)) (V, error) {
+	if c.keyStats != nil {
+		c.keyStats.record(statKey(key))
+	}
+
+	c.mu.RLock()
+	item, found := c.items[key]
+	shouldCache := c.shouldCache
+	c.mu.RUnlock()
+	if found && !item.expired(time.Now().UnixNano()) {
+		return item.value, nil
+	}
+
+	result, err := load()
+	if err != nil {
+		return result, err
+	}
+
+	if shouldCache == nil || shouldCache(result) {
+		c.set(key, result, c.defaultExp)
+	}
+	return result, nil
+}
+
+// SetCachePredicate makes Get cache a loaded value only when fn returns true
+// for it; other values are returned but not stored, so the next Get loads them
+// again. Use it to keep empty or fallback results out of the cache. A nil fn
+// restores the default of caching every value. Entries already cached are
+// kept.
+func (c *KeyedCache[K, V]) SetCachePredicate(fn func(V) bool) {
+	c.mu.Lock()
+	c.shouldCache = fn
+	c.mu.Unlock()
+}
+
+// statKey returns key as reported by TopKeys: strings as they are, other
+// keys formatted with %v.
+func statKey[K comparable](key K) string {
+	if s, ok := any(key).(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", key)
+}
+
+// set stores value under key for d (<= 0: never expires).
+func (c *KeyedCache[K, V]) set(key K, value V, d time.Duration) {
+	item := keyedItem[V]{value: value}
+	if d > 0 {
+		item.expiration = time.Now().Add(d).UnixNano()
+	}
+	c.mu.Lock()
+	c.items[key] = item
+	c.mu.Unlock()
+}
+
+// Items returns a copy of the current unexpired entries.
+func (c *KeyedCache[K, V]) Items() map[K]V {
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[K]V, len(c.items))
+	for k, item := range c.items {
+		if !item.expired(now) {
+			result[k] = item.value
+		}
+	}
+	return result
+}
+
+// Snapshot returns the current unexpired entries with their expiration times,
+// suitable for reloading via Restore. A zero Expiration means never expires.
+func (c *KeyedCache[K, V]) Snapshot() map[K]Entry[V] {
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	result := make(map[K]Entry[V], len(c.items))
+	for k, item := range c.items {
+		if item.expired(now) {
+			continue
+		}
+		e := Entry[V]{Value: item.value}
+		if item.expiration > 0 {
+			e.Expiration = time.Unix(0, item.expiration)
+		}
+		result[k] = e
+	}
+	return result
+}
+
+// Restore loads entries produced by Snapshot, keeping each entry's original
+// expiration time. Entries that have expired in the meantime are skipped;
+// existing entries with the same key are overwritten.
+func (c *KeyedCache[K, V]) Restore(entries map[K]Entry[V]) {
+	now := time.Now()
+	for k, e := range entries {
+		switch {
+		case e.Expiration.IsZero():
+			c.set(k, e.Value, 0)
+		case e.Expiration.After(now):
+			c.set(k, e.Value, e.Expiration.Sub(now))
+		}
+	}
+}
+
+// Clear removes all entries from the cache.
+func (c *KeyedCache[K, V]) Clear() {
+	c.mu.Lock()
+	c.items = make(map[K]keyedItem[V])
+	c.mu.Unlock()
+}
+
+// TopKeys returns up to n of the most accessed keys, most accessed first, or
+// nil unless the cache was created with WithKeyStats. Keys are formatted with
+// %v. A negative n returns all tracked keys. Counts are approximate once more
+// distinct keys have been accessed than the tracking capacity (see
+// WithKeyStats).
+func (c *KeyedCache[K, V]) TopKeys(n int) []KeyStat {
+	if c.keyStats == nil {
+		return nil
+	}
+	return c.keyStats.top(n)
+}
@@ -0,0 +1,132 @@
+package dbcache_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kaichao/gopkg/dbcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedCache_IntKey(t *testing.T) {
+	var loads atomic.Int32
+	c := dbcache.NewKeyed[int, string](nil, "", time.Minute, time.Minute,
+		func(id int) (string, error) {
+			loads.Add(1)
+			return fmt.Sprintf("user-%d", id), nil
+		})
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		v, err := c.Get(7)
+		require.NoError(t, err)
+		assert.Equal(t, "user-7", v)
+	}
+	assert.Equal(t, int32(1), loads.Load(), "hits must not reload")
+
+	v, err := c.Get(8)
+	require.NoError(t, err)
+	assert.Equal(t, "user-8", v)
+	assert.Equal(t, map[int]string{7: "user-7", 8: "user-8"}, c.Items())
+
+	c.Clear()
+	assert.Empty(t, c.Items())
+}
+
+func TestKeyedCache_StructKey(t *testing.T) {
+	type key struct {
+		Tenant string
+		ID     int
+	}
+	var loads atomic.Int32
+	c := dbcache.NewKeyed[key, string](nil, "", time.Minute, 0,
+		func(k key) (string, error) {
+			loads.Add(1)
+			return k.Tenant + "/" + fmt.Sprint(k.ID), nil
+		})
+	defer c.Close()
+
+	// Keys are compared field by field, never by their formatted text.
+	v1, err := c.Get(key{"a b", 1})
+	require.NoError(t, err)
+	v2, err := c.Get(key{"a", 1})
+	require.NoError(t, err)
+	assert.Equal(t, "a b/1", v1)
+	assert.Equal(t, "a/1", v2)
+
+	_, err = c.Get(key{"a b", 1})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), loads.Load())
+
+	// Loader errors are returned and not cached.
+	failing := dbcache.NewKeyed[key, string](nil, "", time.Minute, 0,
+		func(k key) (string, error) { return "", fmt.Errorf("no %v", k) })
+	defer failing.Close()
+	_, err = failing.Get(key{"x", 1})
+	assert.Error(t, err)
+	assert.Empty(t, failing.Items())
+}
+
+func TestKeyedCache_ExpirationAndSnapshot(t *testing.T) {
+	var loads atomic.Int32
+	loader := func(id int) (int, error) {
+		loads.Add(1)
+		return id * 10, nil
+	}
+	c := dbcache.NewKeyed[int, int](nil, "", 30*time.Millisecond, 5*time.Millisecond, loader)
+	defer c.Close()
+
+	_, err := c.Get(1)
+	require.NoError(t, err)
+	snap := c.Snapshot()
+	require.Contains(t, snap, 1)
+	assert.Equal(t, 10, snap[1].Value)
+	assert.False(t, snap[1].Expiration.IsZero())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Empty(t, c.Items(), "expired entry should be purged")
+	_, err = c.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), loads.Load(), "expired entry must be reloaded")
+
+	// Restore into a fresh cache keeps live entries and skips expired ones.
+	restored := dbcache.NewKeyed[int, int](nil, "", 0, 0, loader)
+	defer restored.Close()
+	restored.Restore(map[int]dbcache.Entry[int]{
+		2: {Value: 20},
+		3: {Value: 30, Expiration: time.Now().Add(-time.Second)},
+		4: {Value: 40, Expiration: time.Now().Add(time.Minute)},
+	})
+	assert.Equal(t, map[int]int{2: 20, 4: 40}, restored.Items())
+}
+
+func TestKeyedCache_Options(t *testing.T) {
+	var loads atomic.Int32
+	c := dbcache.NewKeyed[int, string](nil, "", time.Minute, 0,
+		func(id int) (string, error) {
+			loads.Add(1)
+			if id == 0 {
+				return "", nil // Empty result, not worth caching
+			}
+			return fmt.Sprintf("user-%d", id), nil
+		},
+		dbcache.WithKeyStats(10))
+	defer c.Close()
+	c.SetCachePredicate(func(v string) bool { return v != "" })
+
+	for i := 0; i < 3; i++ {
+		_, _ = c.Get(0)
+		_, _ = c.Get(7)
+	}
+	_, _ = c.Get(8)
+	assert.Equal(t, int32(5), loads.Load(), "values failing the predicate are reloaded")
+	assert.Equal(t, map[int]string{7: "user-7", 8: "user-8"}, c.Items())
+
+	top := c.TopKeys(2)
+	require.Len(t, top, 2)
+	assert.ElementsMatch(t, []string{"0", "7"}, []string{top[0].Key, top[1].Key})
+	assert.Equal(t, int64(3), top[0].Accesses)
+}
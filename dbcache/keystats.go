@@ -0,0 +1,105 @@
+package dbcache
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// KeyStat is the access count of a single cache key, as reported by TopKeys.
+type KeyStat struct {
+	Key      string `json:"key"`
+	Accesses int64  `json:"accesses"` // Get calls for the key, hits and misses
+}
+
+// keyStats tracks the most accessed keys in bounded memory using the
+// Space-Saving algorithm: when a new key arrives and all slots are taken, it
+// replaces the least counted key and inherits that count plus one. Counts of
+// frequently accessed keys are therefore exact or slightly overestimated, and
+// any key accessed more than total/capacity times is guaranteed to be tracked.
+type keyStats struct {
+	mu       sync.Mutex
+	capacity int
+	counts   keyCountHeap
+	index    map[string]*keyCount
+}
+
+// keyCount is a tracked key; pos is its index in the heap.
+type keyCount struct {
+	key   string
+	count int64
+	pos   int
+}
+
+func newKeyStats(capacity int) *keyStats {
+	return &keyStats{capacity: capacity, index: make(map[string]*keyCount, capacity)}
+}
+
+// record counts one access of key.
+func (s *keyStats) record(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kc, ok := s.index[key]; ok {
+		kc.count++
+		heap.Fix(&s.counts, kc.pos)
+		return
+	}
+	if len(s.counts) < s.capacity {
+		kc := &keyCount{key: key, count: 1}
+		heap.Push(&s.counts, kc)
+		s.index[key] = kc
+		return
+	}
+	// Evict the least counted key; the newcomer inherits its count.
+	min := s.counts[0]
+	delete(s.index, min.key)
+	min.key = key
+	min.count++
+	s.index[key] = min
+	heap.Fix(&s.counts, 0)
+}
+
+// top returns up to n tracked keys, most accessed first.
+func (s *keyStats) top(n int) []KeyStat {
+	s.mu.Lock()
+	stats := make([]KeyStat, len(s.counts))
+	for i, kc := range s.counts {
+		stats[i] = KeyStat{Key: kc.key, Accesses: kc.count}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Accesses != stats[j].Accesses {
+			return stats[i].Accesses > stats[j].Accesses
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if n >= 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// keyCountHeap is a min-heap of keyCount ordered by count.
+type keyCountHeap []*keyCount
+
+func (h keyCountHeap) Len() int           { return len(h) }
+func (h keyCountHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h keyCountHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *keyCountHeap) Push(x any) {
+	kc := x.(*keyCount)
+	kc.pos = len(*h)
+	*h = append(*h, kc)
+}
+
+func (h *keyCountHeap) Pop() any {
+	old := *h
+	kc := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return kc
+}
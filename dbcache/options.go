@@ -0,0 +1,18 @@
+package dbcache
+
+// Option configures optional DBCache and KeyedCache behavior at construction.
+type Option func(*options)
+
+// options holds settings applied by Option values.
+type options struct {
+	keyStatsCapacity int
+}
+
+// WithKeyStats enables per-key access tracking for TopKeys, keeping counts for
+// at most capacity keys. Tracking costs a mutex-guarded heap update per Get and
+// memory proportional to capacity; it is off by default.
+func WithKeyStats(capacity int) Option {
+	return func(o *options) {
+		o.keyStatsCapacity = capacity
+	}
+}
@@ -0,0 +1,102 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// WithCompactOutput collapses runs of consecutive identical output lines
+// before they are captured, so repetitive noise (progress dots, repeated
+// warnings) does not evict the meaningful tail from the output buffer. A run
+// of n identical lines is captured as the line once, followed by
+//
+//	[previous line repeated n-1 more times]
+//
+// This changes the captured stdout and stderr (and the Defaults.Stdout and
+// Defaults.Stderr mirrors); it does not affect what the command itself sees.
+// Lines are compared byte for byte, including any trailing "\r".
+func WithCompactOutput() Option {
+	return func(o *runOptions) {
+		o.compactOutput = true
+	}
+}
+
+// lineCompactor is an io.Writer that forwards complete lines to w, collapsing
+// consecutive duplicates. Flush must be called after the last Write.
+type lineCompactor struct {
+	w       io.Writer
+	partial []byte // Incomplete line awaiting its newline
+	last    []byte // Last complete line written, without newline
+	repeats int    // Duplicates of last seen since it was written
+	started bool   // Whether last is valid
+}
+
+func newLineCompactor(w io.Writer) *lineCompactor {
+	return &lineCompactor{w: w}
+}
+
+// Write implements io.Writer.
+func (c *lineCompactor) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			c.partial = append(c.partial, p...)
+			break
+		}
+		var line []byte
+		if len(c.partial) > 0 {
+			line = append(c.partial, p[:i]...)
+			c.partial = c.partial[:0]
+		} else {
+			line = p[:i]
+		}
+		if err := c.writeLine(line); err != nil {
+			return n, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// writeLine handles one complete line.
+func (c *lineCompactor) writeLine(line []byte) error {
+	if c.started && bytes.Equal(line, c.last) {
+		c.repeats++
+		return nil
+	}
+	if err := c.flushRepeats(); err != nil {
+		return err
+	}
+	c.last = append(c.last[:0], line...)
+	c.started = true
+	if _, err := c.w.Write(line); err != nil {
+		return err
+	}
+	_, err := c.w.Write([]byte{'\n'})
+	return err
+}
+
+// flushRepeats writes the repeat note for the current run, if any.
+func (c *lineCompactor) flushRepeats() error {
+	if c.repeats == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(c.w, "[previous line repeated %d more times]\n", c.repeats)
+	c.repeats = 0
+	return err
+}
+
+// Flush writes the pending repeat note and any trailing incomplete line.
+func (c *lineCompactor) Flush() error {
+	if err := c.flushRepeats(); err != nil {
+		return err
+	}
+	if len(c.partial) > 0 {
+		_, err := c.w.Write(c.partial)
+		c.partial = c.partial[:0]
+		return err
+	}
+	return nil
+}
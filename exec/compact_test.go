@@ -0,0 +1,22 @@
+package exec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineCompactor(t *testing.T) {
+	var buf bytes.Buffer
+	c := newLineCompactor(&buf)
+
+	// 行跨越多次 Write 调用
+	for _, chunk := range []string{"ab", "c\nabc", "\nab", "c\nx", "y\n", "xy\nz"} {
+		c.Write([]byte(chunk))
+	}
+	assert.Equal(t, "abc\n[previous line repeated 2 more times]\nxy\n", buf.String())
+
+	c.Flush()
+	assert.Equal(t, "abc\n[previous line repeated 2 more times]\nxy\n[previous line repeated 1 more times]\nz", buf.String())
+}
@@ -0,0 +1,57 @@
+package exec
+
+import "io"
+
+// Options holds package-level settings consulted by the Run functions when the
+// corresponding per-call value is zero. Explicit per-call values always win.
+type Options struct {
+	// Timeout in seconds used when a call passes timeout 0. A negative per-call
+	// timeout disables the timeout even if a default is set.
+	Timeout int
+	// Shell used to run local commands (default "/bin/bash"). It is invoked as
+	// `<Shell> -c <command>`.
+	Shell string
+	// Stdout and Stderr, if set, receive a copy of command output as it is
+	// produced, in addition to the captured return values. Shared writers must
+	// be safe for concurrent use.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Quiet silences the package's output passthrough for every call: the
+	// Stdout/Stderr mirrors above are skipped and RunWithRetries no longer
+	// echoes each attempt's command and output to os.Stdout/os.Stderr. Output
+	// is still captured and returned.
+	Quiet bool
+}
+
+// Defaults is consulted by RunReturnAll, RunSSHCommand and RunWithRetries.
+// Set it once during program initialization; it is not guarded for
+// concurrent modification.
+var Defaults Options
+
+// defaultShell is used when Defaults.Shell is empty.
+const defaultShell = "/bin/bash"
+
+// resolveTimeout returns the effective timeout for a per-call value.
+func resolveTimeout(timeout int) int {
+	if timeout == 0 {
+		return Defaults.Timeout
+	}
+	return timeout
+}
+
+// resolveShell returns the shell used for local commands.
+func resolveShell() string {
+	if Defaults.Shell != "" {
+		return Defaults.Shell
+	}
+	return defaultShell
+}
+
+// mirrorWriter returns dst, also copying to mirror when it is non-nil and
+// Defaults.Quiet is not set.
+func mirrorWriter(dst, mirror io.Writer) io.Writer {
+	if mirror == nil || Defaults.Quiet {
+		return dst
+	}
+	return io.MultiWriter(dst, mirror)
+}
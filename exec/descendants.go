@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+)
+
+// runIDEnv is set to a value unique to each RunReturnAll call in the
+// environment of its shell. Children inherit it, so the processes a command
+// leaves behind can still be found after they have left its process group,
+// e.g. by daemonizing with a double fork and setsid.
+const runIDEnv = "GOPKG_EXEC_RUN_ID"
+
+// runSeq numbers the RunReturnAll calls of this process for newRunID.
+var runSeq atomic.Int64
+
+// runIDPrefix makes the IDs of this process unique: the PID alone is reused,
+// e.g. by every process that runs as PID 1 of a container sharing /proc
+// with others, and after a restart while leftovers of the last run survive.
+var runIDPrefix = newRunIDPrefix()
+
+// newRunIDPrefix returns the PID followed by random bytes.
+func newRunIDPrefix() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%d-%s", os.Getpid(), hex.EncodeToString(b))
+}
+
+// WithKillDescendants makes RunReturnAll kill, once the command has returned,
+// the processes it started that are still running, also ones that daemonized
+// (double-forked into their own session) and so escaped its process group.
+// Without it they are only killed on timeout or cancellation. Use it for
+// commands that must not leave anything running behind; it also kills
+// background jobs started on purpose.
+//
+// It is best effort: descendants are recognized by a variable RunReturnAll
+// adds to the command's environment (GOPKG_EXEC_RUN_ID), read from /proc, so
+// it works on Linux only and misses processes that were started with a
+// cleared environment or run as another user.
+func WithKillDescendants() Option {
+	return func(o *runOptions) {
+		o.killDescendants = true
+	}
+}
+
+// newRunID returns a value for runIDEnv that no other call, in this process
+// or another, uses.
+func newRunID() string {
+	return fmt.Sprintf("%s-%d", runIDPrefix, runSeq.Add(1))
+}
+
+// killTagged sends SIGKILL to every process whose environment carries runID,
+// returning how many it signalled. Processes whose environment cannot be read
+// are skipped.
+func killTagged(runID string) int {
+	environs, _ := filepath.Glob("/proc/[0-9]*/environ")
+	tag := []byte(runIDEnv + "=" + runID)
+	n := 0
+	for _, path := range environs {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, kv := range bytes.Split(data, []byte{0}) {
+			if !bytes.Equal(kv, tag) {
+				continue
+			}
+			pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+			if err == nil && pid != os.Getpid() && syscall.Kill(pid, syscall.SIGKILL) == nil {
+				n++
+			}
+			break
+		}
+	}
+	return n
+}
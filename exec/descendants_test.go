@@ -0,0 +1,75 @@
+package exec_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kaichao/gopkg/errors"
+	gexec "github.com/kaichao/gopkg/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// detachCmd 启动一个脱离进程组与会话的子进程 (模拟 daemon 的 double-fork), 并输出其 PID
+const detachCmd = "setsid sleep 30 </dev/null >/dev/null 2>&1 & echo $!"
+
+func TestWithKillDescendants(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid not available")
+	}
+
+	// 默认: 命令正常返回后脱离的子进程继续运行
+	out, _, err := gexec.RunReturnAll(detachCmd, 5)
+	require.NoError(t, err)
+	pid := detachedPID(t, out)
+	assert.True(t, isRunning(pid))
+	syscall.Kill(pid, syscall.SIGKILL)
+
+	// WithKillDescendants: 返回时一并杀死
+	out, _, err = gexec.RunReturnAll(detachCmd, 5, gexec.WithKillDescendants())
+	require.NoError(t, err)
+	pid = detachedPID(t, out)
+	assert.True(t, waitStopped(pid, time.Second), "detached child %d still running", pid)
+
+	// 超时: 无需该选项, 进程组之外的子进程同样被杀死
+	out, _, err = gexec.RunReturnAll(detachCmd+"; sleep 10", 1)
+	assert.Equal(t, 124, errors.GetCode(err))
+	pid = detachedPID(t, out)
+	assert.True(t, waitStopped(pid, time.Second), "detached child %d still running", pid)
+}
+
+// detachedPID 解析 detachCmd 输出的 PID
+func detachedPID(t *testing.T, out string) int {
+	t.Helper()
+	pid, err := strconv.Atoi(strings.TrimSpace(out))
+	require.NoError(t, err, "output: %q", out)
+	return pid
+}
+
+// isRunning 判断进程是否存在且不是僵尸进程 (容器中 init 可能不回收僵尸)
+func isRunning(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// waitStopped 等待进程结束, 超时返回 false
+func waitStopped(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isRunning(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
@@ -11,6 +11,8 @@
 // - Circular buffering for large output (10MB limit)
 // - Process group management for proper termination
 // - Background process support for SSH commands
+// - Interactive PTY sessions over SSH (Send/Expect)
+// - Command templates with shell-quoted arguments (RunTemplate)
 //
 // Usage Examples:
 //
@@ -35,9 +37,57 @@
 //
 // Key Functions:
 //
-//	RunReturnAll(command string, timeout int) (stdout string, stderr string, err error)
+//	RunReturnAll(command string, timeout int, opts ...Option) (stdout string, stderr string, err error)
+//	RunReturnAllContext(ctx context.Context, command string, timeout int, opts ...Option) (string, string, error) // Also stops when ctx is done
 //	RunSSHCommand(config SSHConfig, command string, timeout int) (stdout string, stderr string, err error)
+//	RunSSHScript(config SSHConfig, scriptPath string, args []string, timeout int) (stdout string, stderr string, err error)
 //	RunWithRetries(cmd string, numRetries int, timeout int) (int, error)
+//	RunCheck(command string, timeout int) error
+//	RunLines(command string, timeout int) ([]string, error) // Trimmed, non-empty stdout lines
+//	RunTemplate(tmpl string, args map[string]string, timeout int) (string, string, error) // {{name}} -> shell-quoted args[name]
+//	RunExpect(command string, timeout int, expect func(code int, stdout, stderr string) error) error
+//	ExpectCode(n int) / ExpectStdoutContains(s string) // Predicates for RunExpect
+//	NewInteractive(config SSHConfig) (*Interactive, error)
+//	(it *Interactive) Send(line string) error
+//	(it *Interactive) Expect(pattern string, timeout time.Duration) (string, error)
+//
+// Local Run Options:
+//
+//	WithOnStart(fn func(pid int)) Option // Called with the shell PID (= process group ID) after start
+//	WithResult(r *Result) Option         // Store exit code, signal, core dump flag and Go panic text in r
+//	WithEnvFile(path string) Option      // Add KEY=VALUE pairs from a .env file to the command environment
+//	WithCompactOutput() Option           // Collapse consecutive identical output lines (changes captured output)
+//	WithHeartbeat(interval time.Duration, fn func(elapsed time.Duration)) Option // Periodic callback while the command runs
+//	WithKillDescendants() Option // Kill leftover (also daemonized) children when the command returns; Linux, best effort
+//
+// Crash Diagnostics:
+//
+//	var res exec.Result
+//	_, _, err := exec.RunReturnAll("exec ./flaky-tool", 60, exec.WithResult(&res))
+//	if res.Crashed() {
+//		log.Printf("crashed: signal=%v core=%v\n%s", res.Signal, res.CoreDumped, res.PanicText)
+//	}
+//
+// Signal and CoreDumped describe the shell process, so a crashing program is
+// only seen there when the shell execs it; PanicText is taken from stderr.
+//
+// Package Defaults:
+// exec.Defaults is consulted when per-call values are zero, so programs that make
+// many calls with the same settings can configure them once:
+//
+//	exec.Defaults = exec.Options{
+//		Timeout: 60,        // used when a call passes timeout 0
+//		Shell:   "/bin/sh", // local shell (default "/bin/bash")
+//		Stdout:  os.Stdout, // mirror command output while capturing it
+//		Stderr:  os.Stderr,
+//	}
+//
+// Explicit per-call values always override the defaults; a negative timeout
+// disables the default timeout for that call.
+//
+// Setting exec.Defaults.Quiet silences all output passthrough globally: the
+// Stdout/Stderr mirrors are skipped and RunWithRetries stops echoing each
+// attempt to os.Stdout/os.Stderr. It affects every call in the process.
 //
 // Exit Code Convention:
 //   - 0: Command executed successfully
@@ -58,12 +108,28 @@
 //		Password   string // Optional: SSH password
 //		Background bool   // Optional: Run command in background mode
 //		UseHomeTmp bool   // Optional: Use ${HOME}/tmp instead of /tmp
+//		TOFUKnownHostsPath string // Optional: Trust-on-first-use known_hosts file
+//		HostKeyCallback ssh.HostKeyCallback // Optional: custom host key check, overrides TOFUKnownHostsPath
+//		MaxLineSize int // Optional: longest output line buffered for DEBUG filtering (default 1 MiB)
 //	}
 //
+// SSH Connection Errors:
+// When an SSH connection cannot be established (code 125), the error wraps one
+// of ErrSSHAuth, ErrSSHUnreachable or ErrSSHHostKey if the cause is recognized:
+//
+//	_, _, err := exec.RunSSHCommand(config, "uptime", 30)
+//	if errors.Is(err, exec.ErrSSHUnreachable) {
+//		// network problem, worth retrying later
+//	}
+//
+// Authentication and host key failures are not retried internally.
+//
 // Output Handling:
 // - Standard output and error are captured using circular buffers (10MB limit)
-// - Output is not automatically printed to os.Stdout/os.Stderr; it is returned to the caller
+// - Output is returned to the caller; set Defaults.Stdout/Defaults.Stderr to also mirror it
 // - Background SSH commands return PID instead of output
+// - SSH lines longer than SSHConfig.MaxLineSize (1 MiB) are kept whole, without DEBUG filtering
+// - The PID is that of the login shell ($SHELL -c) running the whole command, also for compound commands
 //
 // Error Handling:
 // - All functions return consistent error types following gopkg/errors conventions
@@ -74,6 +140,8 @@
 // - SSH private keys should have 600 permissions
 // - Avoid hardcoding passwords in source code
 // - Validate and sanitize command inputs to prevent injection
+// - Set SSHConfig.TOFUKnownHostsPath to verify host keys (trust-on-first-use)
+// - Or set SSHConfig.HostKeyCallback, e.g. TOFUHostKeyCallback(path) wrapped to log new keys
 //
 // Dependencies:
 // - golang.org/x/crypto/ssh for SSH functionality
@@ -0,0 +1,115 @@
+package exec
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// envKeyRe matches a valid environment variable name.
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// WithEnvFile loads KEY=VALUE pairs from the file at path and adds them to the
+// command's environment, overriding inherited variables of the same name.
+//
+// The file format follows common .env conventions:
+//   - blank lines and lines starting with # are ignored
+//   - an optional "export " prefix is accepted
+//   - double-quoted values support \n, \t, \" and \\ escapes
+//   - single-quoted values are taken literally
+//   - unquoted values are trimmed and may end with a " # comment"
+//
+// RunReturnAll fails with exit code 125 if the file is missing or malformed.
+func WithEnvFile(path string) Option {
+	return func(o *runOptions) {
+		o.envFile = path
+	}
+}
+
+// loadEnvFile parses the env file at path and returns its entries as
+// "KEY=VALUE" strings in file order.
+func loadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WrapE(err, 125, "open env file failed", "file", path)
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envKeyRe.MatchString(key) {
+			return nil, errors.E(125, "malformed env file line", "file", path, "line", lineNo)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, errors.WrapE(err, 125, "malformed env file line", "file", path, "line", lineNo)
+		}
+		env = append(env, key+"="+value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WrapE(err, 125, "read env file failed", "file", path)
+	}
+	return env, nil
+}
+
+// parseEnvValue decodes the value part of an env file line.
+func parseEnvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch quote := raw[0]; quote {
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", errors.E("unterminated single quote")
+		}
+		return raw[1 : end+1], trailingComment(raw[end+2:])
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			switch {
+			case c == '"':
+				return sb.String(), trailingComment(raw[i+1:])
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(raw[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", errors.E("unterminated double quote")
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// trailingComment checks that only whitespace or a comment follows a quoted value.
+func trailingComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return errors.E("unexpected text after quoted value", "text", rest)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package exec_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaichao/gopkg/errors"
+	"github.com/kaichao/gopkg/exec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write env file failed: %v", err)
+		}
+		return path
+	}
+
+	// 变量传递给命令, 支持注释、export 前缀和引号
+	path := writeFile("app.env", `# 应用配置
+APP_NAME=demo
+export APP_PORT = 8080  # 行尾注释
+APP_GREETING="hello \"world\"\n"
+APP_RAW='$HOME #not-a-comment'
+APP_EMPTY=
+HOME=/override
+`)
+	out, _, err := exec.RunReturnAll(`printf '%s|%s|%s|%s|%s|%s' "$APP_NAME" "$APP_PORT" "$APP_GREETING" "$APP_RAW" "$APP_EMPTY" "$HOME"`, 5, exec.WithEnvFile(path))
+	assert.Nil(t, err)
+	assert.Equal(t, "demo|8080|hello \"world\"\n|$HOME #not-a-comment||/override", out)
+
+	// 继承的环境变量仍然可见
+	t.Setenv("EXEC_ENVFILE_INHERITED", "yes")
+	out, _, err = exec.RunReturnAll(`echo -n "$EXEC_ENVFILE_INHERITED"`, 5, exec.WithEnvFile(path))
+	assert.Nil(t, err)
+	assert.Equal(t, "yes", out)
+
+	// 文件不存在
+	_, _, err = exec.RunReturnAll("true", 5, exec.WithEnvFile(filepath.Join(dir, "missing.env")))
+	assert.Equal(t, 125, errors.GetCode(err))
+
+	// 格式错误
+	for _, content := range []string{"NO_EQUALS_SIGN\n", "1BAD=x\n", "A=\"unterminated\n", "A='x' trailing\n"} {
+		_, _, err = exec.RunReturnAll("true", 5, exec.WithEnvFile(writeFile("bad.env", content)))
+		assert.Equal(t, 125, errors.GetCode(err), content)
+		assert.Contains(t, err.Error(), "malformed env file line", content)
+	}
+}
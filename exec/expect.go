@@ -0,0 +1,47 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// RunExpect executes a command and checks the outcome with expect, returning
+// the error expect returns. expect receives the exit code (errors.GetCode of
+// the run error, e.g. 124 on timeout) along with the captured output.
+//
+// Params:
+//   - command: the command string to execute
+//   - timeout: timeout in seconds (0 uses Defaults.Timeout, negative for no timeout)
+//   - expect: predicate on (code, stdout, stderr), e.g. ExpectCode or ExpectStdoutContains
+func RunExpect(command string, timeout int, expect func(code int, stdout, stderr string) error) error {
+	stdout, stderr, err := RunReturnAll(command, timeout)
+	return expect(errors.GetCode(err), stdout, stderr)
+}
+
+// ExpectCode returns a RunExpect predicate requiring exit code n.
+func ExpectCode(n int) func(code int, stdout, stderr string) error {
+	return func(code int, stdout, stderr string) error {
+		if code != n {
+			return errors.E(fmt.Sprintf("expected exit code %d, got %d", n, code),
+				"stderr", stderrTail(stderr, maxCheckStderr))
+		}
+		return nil
+	}
+}
+
+// ExpectStdoutContains returns a RunExpect predicate requiring exit code 0 and
+// stdout containing s.
+func ExpectStdoutContains(s string) func(code int, stdout, stderr string) error {
+	return func(code int, stdout, stderr string) error {
+		if err := ExpectCode(0)(code, stdout, stderr); err != nil {
+			return err
+		}
+		if !strings.Contains(stdout, s) {
+			return errors.E(fmt.Sprintf("stdout does not contain %q", s),
+				"stdout", stderrTail(stdout, maxCheckStderr))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package exec
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithHeartbeat makes RunReturnAll call fn with the elapsed time every
+// interval while the command runs, e.g. to log "still running after 2m0s" for
+// long commands. The ticker starts with the command and stops when it exits,
+// including on timeout; fn is never called after RunReturnAll returns. A nil
+// fn logs the elapsed time at info level. A non-positive interval disables it.
+func WithHeartbeat(interval time.Duration, fn func(elapsed time.Duration)) Option {
+	return func(o *runOptions) {
+		o.heartbeatInterval = interval
+		o.heartbeat = fn
+	}
+}
+
+// startHeartbeat calls fn every interval until the returned stop function is
+// called; stop waits for an in-progress fn call to return.
+func startHeartbeat(command string, interval time.Duration, fn func(elapsed time.Duration)) (stop func()) {
+	if fn == nil {
+		fn = func(elapsed time.Duration) {
+			logrus.Infof("command still running after %v: %s", elapsed.Round(time.Second), command)
+		}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn(time.Since(start))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
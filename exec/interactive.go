@@ -0,0 +1,165 @@
+package exec
+
+import (
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/kaichao/gopkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// Interactive drives a PTY-backed remote shell, for tools that prompt and
+// expect responses. Output is accumulated internally and consumed by Expect.
+//
+// An Interactive is not safe for concurrent Expect calls.
+type Interactive struct {
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+
+	mu     sync.Mutex
+	buf    []byte
+	notify chan struct{} // signalled when buf grows or reading stops
+	eof    bool
+}
+
+// NewInteractive opens an SSH connection, requests a PTY and starts a login shell.
+// The caller must call Close when done.
+func NewInteractive(config SSHConfig) (*Interactive, error) {
+	client, _, cancel, err := createSSHClient(config, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cancel != nil {
+		cancel()
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, errors.WrapE(err, 125, "ssh: create session failed")
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          0, // don't echo input back into the output stream
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", 40, 200, modes); err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.WrapE(err, 125, "ssh: request pty failed")
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.WrapE(err, 125, "capture stdin pipe failed")
+	}
+	// With a PTY, stderr is merged into stdout by the remote side.
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.WrapE(err, 125, "capture stdout pipe failed")
+	}
+
+	if err := session.Shell(); err != nil {
+		session.Close()
+		client.Close()
+		return nil, errors.WrapE(err, 125, "ssh: start shell failed")
+	}
+
+	it := &Interactive{
+		client:  client,
+		session: session,
+		stdin:   stdin,
+		notify:  make(chan struct{}, 1),
+	}
+	go it.readLoop(stdout)
+	return it, nil
+}
+
+// readLoop appends remote output to the internal buffer until the session ends.
+func (it *Interactive) readLoop(r io.Reader) {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		it.mu.Lock()
+		if n > 0 {
+			it.buf = append(it.buf, chunk[:n]...)
+		}
+		if err != nil {
+			it.eof = true
+		}
+		it.mu.Unlock()
+		it.signal()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (it *Interactive) signal() {
+	select {
+	case it.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Send writes line followed by a newline to the remote shell.
+func (it *Interactive) Send(line string) error {
+	if _, err := io.WriteString(it.stdin, line+"\n"); err != nil {
+		return errors.WrapE(err, 125, "send to interactive session failed")
+	}
+	return nil
+}
+
+// Expect waits until the output received since the previous Expect matches the
+// regular expression pattern, then returns all output up to and including the
+// match. Output after the match is kept for the next Expect.
+//
+// Returns an error with code 124 if the pattern does not appear within timeout,
+// or code 125 if the session ends first or the pattern is invalid.
+func (it *Interactive) Expect(pattern string, timeout time.Duration) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", errors.WrapE(err, 125, "invalid expect pattern", "pattern", pattern)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		it.mu.Lock()
+		if loc := re.FindIndex(it.buf); loc != nil {
+			out := string(it.buf[:loc[1]])
+			it.buf = it.buf[loc[1]:]
+			it.mu.Unlock()
+			return out, nil
+		}
+		eof := it.eof
+		pending := string(it.buf)
+		it.mu.Unlock()
+
+		if eof {
+			return pending, errors.E(125, "interactive session closed before pattern matched", "pattern", pattern)
+		}
+
+		select {
+		case <-it.notify:
+		case <-timer.C:
+			return pending, errors.E(124, "expect timed out", "pattern", pattern)
+		}
+	}
+}
+
+// Close ends the remote shell and releases the SSH connection.
+func (it *Interactive) Close() error {
+	it.stdin.Close()
+	it.session.Close()
+	return it.client.Close()
+}
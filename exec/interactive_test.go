@@ -0,0 +1,49 @@
+package exec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kaichao/gopkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInteractive(t *testing.T) {
+	config := SSHConfig{
+		User:     testSSHUser,
+		Host:     testSSHServer,
+		Port:     testSSHPort,
+		KeyPath:  testSSHKey,
+		Password: testPassword,
+	}
+
+	if config.KeyPath == "" && config.Password == "" {
+		t.Skip("SSH authentication not configured: must set either KeyPath or Password")
+	}
+
+	it, err := NewInteractive(config)
+	require.NoError(t, err)
+	defer it.Close()
+
+	// A read-and-echo script that prompts twice.
+	script := `read -p "name? " n; echo "hello $n"; read -p "again? " a; echo "bye $a"`
+	require.NoError(t, it.Send(script))
+
+	_, err = it.Expect(`name\? `, 5*time.Second)
+	require.NoError(t, err)
+	require.NoError(t, it.Send("alice"))
+
+	out, err := it.Expect(`again\? `, 5*time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, out, "hello alice")
+	require.NoError(t, it.Send("bob"))
+
+	out, err = it.Expect(`bye \w+`, 5*time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, out, "bye bob")
+
+	// Pattern that never appears times out with code 124.
+	_, err = it.Expect("never-printed", 500*time.Millisecond)
+	assert.Equal(t, 124, errors.GetCode(err))
+}
@@ -0,0 +1,67 @@
+package exec
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/kaichao/gopkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMu serializes known_hosts access within this process.
+// Cross-process safety is provided by flock on the file itself.
+var knownHostsMu sync.Mutex
+
+// TOFUHostKeyCallback returns a trust-on-first-use HostKeyCallback backed by
+// the given known_hosts file. Unknown hosts are appended to the file and
+// accepted; a host whose key differs from the recorded one is rejected. It is
+// what SSHConfig.TOFUKnownHostsPath uses, and can be wrapped, e.g. to log new
+// keys, and passed as SSHConfig.HostKeyCallback.
+func TOFUHostKeyCallback(knownHostsPath string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		if err := os.MkdirAll(filepath.Dir(knownHostsPath), 0700); err != nil {
+			return errors.WrapE(err, 125, "create known_hosts dir failed", "path", knownHostsPath)
+		}
+		f, err := os.OpenFile(knownHostsPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return errors.WrapE(err, 125, "open known_hosts failed", "path", knownHostsPath)
+		}
+		defer f.Close()
+
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+			return errors.WrapE(err, 125, "lock known_hosts failed", "path", knownHostsPath)
+		}
+		defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+		// Re-read the file under lock so entries appended by other
+		// processes since the last connection are honoured.
+		callback, err := knownhosts.New(knownHostsPath)
+		if err != nil {
+			return errors.WrapE(err, 125, "parse known_hosts failed", "path", knownHostsPath)
+		}
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			// Revoked key or changed key: never trust silently.
+			return errors.WrapE(err, 125, "host key verification failed", "host", hostname)
+		}
+
+		// First use: record the key and accept it.
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			return errors.WrapE(err, 125, "append known_hosts failed", "path", knownHostsPath)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package exec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func TestTOFUHostKeyCallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	callback := TOFUHostKeyCallback(path)
+
+	key := newTestHostKey(t)
+
+	t.Run("first connect records key", func(t *testing.T) {
+		assert.NoError(t, callback("10.0.0.1:22", remote, key))
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(content), "\n"))
+		assert.Contains(t, string(content), "10.0.0.1")
+	})
+
+	t.Run("same key is accepted without duplicate entry", func(t *testing.T) {
+		assert.NoError(t, callback("10.0.0.1:22", remote, key))
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, 1, strings.Count(string(content), "\n"))
+	})
+
+	t.Run("changed key is rejected", func(t *testing.T) {
+		err := callback("10.0.0.1:22", remote, newTestHostKey(t))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "host key verification failed")
+	})
+
+	t.Run("concurrent first connects", func(t *testing.T) {
+		other := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 22}
+		otherKey := newTestHostKey(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, callback("10.0.0.2:22", other, otherKey))
+			}()
+		}
+		wg.Wait()
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, 2, strings.Count(string(content), "\n"))
+	})
+}
@@ -0,0 +1,71 @@
+package exec
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Result describes how a local command terminated, for post-mortem diagnostics.
+// It is filled in by RunReturnAll when WithResult is given.
+type Result struct {
+	ExitCode   int            // Same as errors.GetCode(err)
+	Signal     syscall.Signal // Signal that killed the shell process, 0 if it exited normally
+	CoreDumped bool           // Whether the killed process dumped core
+	PanicText  string         // Go panic or fatal error text found in stderr, if any
+}
+
+// crashSignals are the signals raised by faults in the process itself, as
+// opposed to being killed from outside (SIGKILL, SIGTERM, ...).
+var crashSignals = map[syscall.Signal]bool{
+	syscall.SIGSEGV: true,
+	syscall.SIGBUS:  true,
+	syscall.SIGILL:  true,
+	syscall.SIGFPE:  true,
+	syscall.SIGABRT: true,
+	syscall.SIGTRAP: true,
+	syscall.SIGSYS:  true,
+}
+
+// Crashed reports whether the command crashed: it was killed by a fault signal
+// such as SIGSEGV, dumped core, or printed a Go panic.
+func (r Result) Crashed() bool {
+	return crashSignals[r.Signal] || r.CoreDumped || r.PanicText != ""
+}
+
+// WithResult makes RunReturnAll store termination details in r once the
+// command has finished, including when it failed or timed out.
+func WithResult(r *Result) Option {
+	return func(o *runOptions) {
+		o.result = r
+	}
+}
+
+// newResult builds a Result from the process state of a finished command.
+// The signal fields apply to the shell process itself: a crashing child only
+// shows up here if the shell execs it (e.g. "exec ./prog").
+func newResult(state *os.ProcessState, exitCode int, stderr string) Result {
+	r := Result{ExitCode: exitCode, PanicText: extractPanic(stderr)}
+	if state == nil {
+		return r
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		r.Signal = status.Signal()
+		r.CoreDumped = status.CoreDump()
+	}
+	return r
+}
+
+// extractPanic returns the Go runtime panic report in stderr, from its first
+// "panic: " or "fatal error: " line to the end, or "" if there is none.
+func extractPanic(stderr string) string {
+	for _, marker := range []string{"panic: ", "fatal error: "} {
+		if strings.HasPrefix(stderr, marker) {
+			return stderr
+		}
+		if i := strings.Index(stderr, "\n"+marker); i >= 0 {
+			return stderr[i+1:]
+		}
+	}
+	return ""
+}
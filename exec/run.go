@@ -3,113 +3,176 @@ package exec
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/kaichao/gopkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
+// outputDrainDelay is how long RunReturnAll keeps reading output after the
+// shell exits, for children still holding its pipes.
+const outputDrainDelay = 100 * time.Millisecond
+
+// Option configures a single local command run.
+type Option func(*runOptions)
+
+// runOptions holds per-call settings applied by Option values.
+type runOptions struct {
+	onStart       func(pid int)
+	result        *Result
+	envFile       string
+	compactOutput bool
+
+	killDescendants bool
+
+	heartbeatInterval time.Duration
+	heartbeat         func(elapsed time.Duration)
+}
+
+// WithOnStart registers fn to be called with the PID of the shell right after
+// the command starts. The shell leads its own process group, so the PID is also
+// the process group ID of any children it spawns.
+func WithOnStart(fn func(pid int)) Option {
+	return func(o *runOptions) {
+		o.onStart = fn
+	}
+}
+
 // RunReturnAll executes a command and returns stdout, stderr, and any error.
 // The error code can be retrieved via errors.GetCode(err).
 //
 // Params:
 //   - command: the command string to execute
-//   - timeout: timeout in seconds (0 for no timeout)
+//   - timeout: timeout in seconds (0 uses Defaults.Timeout, negative for no timeout)
+//   - opts: optional per-call settings (e.g. WithOnStart, WithResult, WithEnvFile, WithHeartbeat)
 //
 // Returns: (stdout, stderr, err)
 //   - stdout: standard output
 //   - stderr: standard error
 //   - err: error with embedded exit code, retrievable via errors.GetCode(err)
-func RunReturnAll(command string, timeout int) (string, string, error) {
+//
+// On timeout the shell's process group is killed, and with it every child
+// that stayed in it. A command that daemonizes (double-forks into its own
+// session) and returns quickly is reported as finished while its daemon keeps
+// running; such processes are killed on timeout as well, best effort (see
+// WithKillDescendants), and after a normal exit only with WithKillDescendants.
+func RunReturnAll(command string, timeout int, opts ...Option) (string, string, error) {
+	return RunReturnAllContext(context.Background(), command, timeout, opts...)
+}
+
+// RunReturnAllContext is like RunReturnAll, but the command also stops when
+// ctx is done, so callers can cancel it from outside or tie it to a request
+// context. The command runs until ctx or the timeout, whichever fires first,
+// ends it; its whole process group is then killed. A deadline, of ctx or the
+// timeout, gives exit code 124 as for a timeout; a cancelled ctx gives exit
+// code 125 with an error wrapping ctx.Err(). The output captured so far is
+// returned in both cases.
+func RunReturnAllContext(ctx context.Context, command string, timeout int, opts ...Option) (string, string, error) {
 	if command == "" {
 		return "", "", errors.E(125, "start command failed: empty command")
 	}
 
-	baseCtx := context.Background()
-	ctx := baseCtx
-	var cancel context.CancelFunc
+	var o runOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	timeout = resolveTimeout(timeout)
+
 	if timeout > 0 {
-		ctx, cancel = context.WithTimeout(baseCtx, time.Duration(timeout)*time.Second)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
 		defer cancel()
 	}
 
 	// Create command with process group support
 	// Enable strict mode in bash and clean up only child processes on EXIT while preserving original exit code
 	// Note: Add "|| true" to pkill to avoid failure (no child processes) interrupting trap
+	// The prelude uses bash-only options, so it is skipped for other shells
+	shell := resolveShell()
 	bashCmd := command
-	if os.Getenv("STRICT_BASH_MODE") == "yes" {
+	if os.Getenv("STRICT_BASH_MODE") == "yes" && filepath.Base(shell) == "bash" {
 		bashCmd = `
 			set -euo pipefail
 			trap 'rc=$?; echo "[cleanup] bash exit rc=$rc" >&2; pkill -TERM -P $$ || true; exit $rc' EXIT
 		` + command
 	}
-	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", bashCmd)
+	cmd := exec.CommandContext(ctx, shell, "-c", bashCmd)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	// Get output pipes
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", "", errors.WrapE(err, 125, "capture stdout pipe failed")
+	// Terminate the whole process group after timeout or cancellation, and
+	// the descendants that left it
+	runID := newRunID()
+	cmd.Cancel = func() error {
+		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		killTagged(runID)
+		return err
 	}
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return "", "", errors.WrapE(err, 125, "capture stderr pipe failed")
+	cmd.Env = os.Environ()
+	if o.envFile != "" {
+		env, err := loadEnvFile(o.envFile)
+		if err != nil {
+			return "", "", err
+		}
+		cmd.Env = append(cmd.Env, env...)
 	}
+	cmd.Env = append(cmd.Env, runIDEnv+"="+runID)
 
 	// Use circular buffer to capture output
 	const maxOutputSize = 10 * 1024 * 1024 // 10MB
 	stdoutBuf := newCircularBuffer(maxOutputSize)
 	stderrBuf := newCircularBuffer(maxOutputSize)
 
-	// Capture output asynchronously
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		_, err := io.Copy(stdoutBuf, stdoutPipe)
-		if err != nil && !errors.Is(err, os.ErrClosed) {
-			logrus.Errorf("copy stdout failed: %v", err)
-		}
-	}()
-	go func() {
-		defer wg.Done()
-		_, err := io.Copy(stderrBuf, stderrPipe)
-		if err != nil && !errors.Is(err, os.ErrClosed) {
-			logrus.Errorf("copy stderr failed: %v", err)
-		}
-	}()
+	// Output is copied by os/exec, and Wait returns once it is all read. Children
+	// left running in the background may keep the pipes open; they are closed
+	// outputDrainDelay after the shell exits.
+	stdoutW := mirrorWriter(stdoutBuf, Defaults.Stdout)
+	stderrW := mirrorWriter(stderrBuf, Defaults.Stderr)
+	var compactors []*lineCompactor
+	if o.compactOutput {
+		stdoutC, stderrC := newLineCompactor(stdoutW), newLineCompactor(stderrW)
+		compactors = append(compactors, stdoutC, stderrC)
+		stdoutW, stderrW = stdoutC, stderrC
+	}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+	cmd.WaitDelay = outputDrainDelay
 
 	// Start command
 	if err := cmd.Start(); err != nil {
 		return "", "", errors.WrapE(err, 125, "start command failed")
 	}
-
-	// Terminate process group after timeout
-	if timeout > 0 {
-		go func() {
-			<-ctx.Done()
-			if ctx.Err() == context.DeadlineExceeded && cmd.Process != nil {
-				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-			}
-		}()
+	if o.onStart != nil {
+		o.onStart(cmd.Process.Pid)
+	}
+	stopHeartbeat := func() {}
+	if o.heartbeatInterval > 0 {
+		stopHeartbeat = startHeartbeat(command, o.heartbeatInterval, o.heartbeat)
 	}
 
-	// Wait for command to finish
+	// Wait for command to finish and output copying to complete
 	waitErr := cmd.Wait()
-	// Ensure output copying is complete
-	wg.Wait()
+	if errors.Is(waitErr, exec.ErrWaitDelay) {
+		waitErr = nil // Exited successfully, only the pipes were left open
+	}
+	if o.killDescendants {
+		killTagged(runID)
+	}
+	stopHeartbeat()
+	for _, c := range compactors {
+		c.Flush()
+	}
 
 	// Get data from buffers
 	stdoutBytes := stdoutBuf.Bytes()
 	stderrBytes := stderrBuf.Bytes()
 
 	if waitErr == nil {
+		if o.result != nil {
+			*o.result = newResult(cmd.ProcessState, 0, string(stderrBytes))
+		}
 		return string(stdoutBytes), string(stderrBytes), nil
 	}
 
@@ -119,6 +182,9 @@ func RunReturnAll(command string, timeout int) (string, string, error) {
 	if ctx.Err() == context.DeadlineExceeded {
 		exitCode = 124
 		retErr = errors.E(124, "command timed out")
+	} else if ctx.Err() != nil {
+		exitCode = 125
+		retErr = errors.WrapE(ctx.Err(), 125, "context cancelled")
 	} else if exitErr, ok := waitErr.(*exec.ExitError); ok {
 		exitCode = exitErr.ExitCode()
 		// Handle signal termination
@@ -138,19 +204,27 @@ func RunReturnAll(command string, timeout int) (string, string, error) {
 	if retErr == nil && exitCode > 0 {
 		retErr = errors.E(exitCode, "exit-code not zero")
 	}
+	if o.result != nil {
+		*o.result = newResult(cmd.ProcessState, exitCode, string(stderrBytes))
+	}
 	return string(stdoutBytes), string(stderrBytes), retErr
 }
 
 // RunWithRetries executes a command up to numRetries times until success.
+// Each attempt's output is echoed to os.Stdout/os.Stderr unless Defaults.Quiet
+// is set.
 // Returns 0 on success, or the last exit code if all retries are exhausted.
 // An error is returned if RunReturnAll encounters a non-exit-code error (e.g., timeout).
 func RunWithRetries(cmd string, numRetries int, timeout int) (int, error) {
+	timeout = resolveTimeout(timeout)
 	delay := 10 * time.Second
 	var lastCode int
 	for i := 0; i < numRetries; i++ {
 		stdout, stderr, err := RunReturnAll(cmd, timeout)
-		fmt.Printf("exec command:%s\n stdout:\n%s\n", cmd, stdout)
-		fmt.Fprintf(os.Stderr, "exec command: %s\n stderr:\n%s\n", cmd, stderr)
+		if !Defaults.Quiet {
+			fmt.Printf("exec command:%s\n stdout:\n%s\n", cmd, stdout)
+			fmt.Fprintf(os.Stderr, "exec command: %s\n stderr:\n%s\n", cmd, stderr)
+		}
 		code := errors.GetCode(err)
 		if err != nil {
 			return code, err
@@ -159,7 +233,9 @@ func RunWithRetries(cmd string, numRetries int, timeout int) (int, error) {
 			return code, nil
 		}
 		lastCode = code
-		fmt.Printf("num-of-retries:%d,cmd=%s\n", i+1, cmd)
+		if !Defaults.Quiet {
+			fmt.Printf("num-of-retries:%d,cmd=%s\n", i+1, cmd)
+		}
 		time.Sleep(delay)
 		delay *= 2
 		timeout *= 2
@@ -167,6 +243,65 @@ func RunWithRetries(cmd string, numRetries int, timeout int) (int, error) {
 	return lastCode, nil
 }
 
+// maxCheckStderr bounds the stderr tail that RunCheck includes in its error.
+const maxCheckStderr = 2048
+
+// RunCheck executes a command and reports only whether it succeeded.
+// On failure the returned error keeps the exit code (errors.GetCode) and its
+// message includes the last maxCheckStderr bytes of stderr, prefixed with
+// "..." when truncated.
+//
+// Params:
+//   - command: the command string to execute
+//   - timeout: timeout in seconds (0 uses Defaults.Timeout, negative for no timeout)
+func RunCheck(command string, timeout int) error {
+	_, stderr, err := RunReturnAll(command, timeout)
+	return checkError(command, stderr, err)
+}
+
+// RunLines executes a command and returns its stdout as lines: each line is
+// trimmed of surrounding whitespace (including a trailing "\r") and empty
+// lines are dropped, so a command without output gives an empty slice. On
+// failure the lines printed so far are returned with an error like RunCheck's.
+//
+// Params:
+//   - command: the command string to execute
+//   - timeout: timeout in seconds (0 uses Defaults.Timeout, negative for no timeout)
+func RunLines(command string, timeout int) ([]string, error) {
+	stdout, stderr, err := RunReturnAll(command, timeout)
+	lines := []string{}
+	for _, line := range strings.Split(stdout, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, checkError(command, stderr, err)
+}
+
+// checkError turns the run error of command into RunCheck's error, carrying
+// the exit code and the tail of stderr. A nil err gives nil.
+func checkError(command, stderr string, err error) error {
+	if err == nil {
+		return nil
+	}
+	code := errors.GetCode(err)
+	msg := fmt.Sprintf("command failed with exit code %d", code)
+	if tail := stderrTail(stderr, maxCheckStderr); tail != "" {
+		msg += ": " + tail
+	}
+	return errors.WrapE(err, code, msg, "command", command)
+}
+
+// stderrTail returns the trimmed last n bytes of s, prefixed with "..." if
+// anything was cut off.
+func stderrTail(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return "..." + strings.TrimSpace(s[len(s)-n:])
+}
+
 // circularBuffer implements a fixed-size circular buffer, safe for concurrent use.
 type circularBuffer struct {
 	mu     sync.RWMutex
@@ -17,7 +17,42 @@ import (
 )
 
 // RunSSHCommand executes command via SSH with full lifecycle management
+// A zero timeout uses Defaults.Timeout; output of synchronous commands is also
+// copied to Defaults.Stdout/Defaults.Stderr when set.
 func RunSSHCommand(config SSHConfig, command string, timeout int) (string, string, error) {
+	return runSSH(config, command, nil, timeout)
+}
+
+// RunSSHScript runs the local script file at scriptPath on the remote host by
+// piping it to `bash -s` over stdin, so the script needs no quoting or upload
+// and leaves no temp file behind. args are passed as positional parameters
+// ($1, $2, ...), each single-quoted for the remote shell. Results and exit
+// codes follow RunSSHCommand. Background mode is not supported.
+func RunSSHScript(config SSHConfig, scriptPath string, args []string, timeout int) (string, string, error) {
+	if config.Background {
+		return "", "", errors.E(125, "background mode not supported for scripts")
+	}
+	script, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return "", "", errors.WrapE(err, 125, "read script failed", "script", scriptPath)
+	}
+
+	command := "bash -s --"
+	for _, arg := range args {
+		command += " " + shellQuote(arg)
+	}
+	return runSSH(config, command, bytes.NewReader(script), timeout)
+}
+
+// shellQuote quotes s as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runSSH implements RunSSHCommand; a non-nil stdin is fed to a synchronous
+// command's standard input.
+func runSSH(config SSHConfig, command string, stdin io.Reader, timeout int) (string, string, error) {
+	timeout = resolveTimeout(timeout)
 	client, ctx, cancel, err := createSSHClient(config, timeout)
 	if err != nil {
 		return "", "", err
@@ -43,7 +78,7 @@ func RunSSHCommand(config SSHConfig, command string, timeout int) (string, strin
 
 	if config.Background {
 		wrappedCmd, marker := wrapCommand(command, config.UseHomeTmp)
-		stdoutBuf, stderrBuf, wg = captureOutput(ctx, session)
+		stdoutBuf, stderrBuf, wg = captureOutput(ctx, session, config.MaxLineSize, nil, nil)
 
 		if err := session.Start(wrappedCmd); err != nil {
 			// Clean up any processes that may have started
@@ -105,7 +140,10 @@ func RunSSHCommand(config SSHConfig, command string, timeout int) (string, strin
 	}
 
 	// Normal synchronous command execution
-	stdoutBuf, stderrBuf, wg = captureOutput(ctx, session)
+	stdoutBuf, stderrBuf, wg = captureOutput(ctx, session, config.MaxLineSize, Defaults.Stdout, Defaults.Stderr)
+	if stdin != nil {
+		session.Stdin = stdin
+	}
 	if err := session.Start(command); err != nil {
 		return "", "", errors.WrapE(err, 125, "start command failed")
 	}
@@ -178,10 +216,17 @@ func createSSHClient(config SSHConfig, timeout int) (*ssh.Client, context.Contex
 		return nil, nil, nil, err
 	}
 
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if config.HostKeyCallback != nil {
+		hostKeyCallback = config.HostKeyCallback
+	} else if config.TOFUKnownHostsPath != "" {
+		hostKeyCallback = TOFUHostKeyCallback(config.TOFUKnownHostsPath)
+	}
+
 	clientConfig := &ssh.ClientConfig{
 		User:            config.User,
 		Auth:            []ssh.AuthMethod{authMethod},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback,
 		Timeout:         30 * time.Second,
 	}
 
@@ -198,7 +243,7 @@ func createSSHClient(config SSHConfig, timeout int) (*ssh.Client, context.Contex
 		if cancel != nil {
 			cancel()
 		}
-		return nil, nil, nil, errors.WrapE(err, 125, "ssh dial failed")
+		return nil, nil, nil, errors.WrapE(classifyDialError(err), 125, "ssh dial failed")
 	}
 
 	return client, ctx, cancel, nil
@@ -206,9 +251,13 @@ func createSSHClient(config SSHConfig, timeout int) (*ssh.Client, context.Contex
 
 // wrapCommand wraps command with PID marker for background execution.
 // Uses `nohup` directly in the SSH command line (no temp script, no
-// heredoc, no quoting issues). The command backgrounds the real command
-// with nohup, then immediately echoes its PID and exit code.
-// session.Wait() returns immediately after the echo.
+// heredoc). The command, quoted as one word, runs in its own instance of the
+// user's login shell ("$SHELL -c", as set by sshd; sh if unset), so bash
+// features keep working where sh is dash. That shell is backgrounded with
+// nohup; the wrapper then immediately echoes its PID. That PID is the shell
+// running the whole command, also for compound ones ("a; b", "a | b",
+// "cd d && ./server"), and for a simple command usually the command itself,
+// as the shell execs it. session.Wait() returns immediately after the echo.
 // If useHomeTmp is true, output is redirected to ${HOME}/tmp/nohup.out
 // instead of /dev/null, allowing debugging of background command output.
 func wrapCommand(command string, useHomeTmp bool) (string, string) {
@@ -216,20 +265,25 @@ func wrapCommand(command string, useHomeTmp bool) (string, string) {
 	var wrapper string
 	if useHomeTmp {
 		wrapper = fmt.Sprintf(
-			"mkdir -p ${HOME}/tmp; nohup %s >${HOME}/tmp/nohup.out 2>&1 & echo \"$! %s\"",
-			command, marker)
+			"mkdir -p ${HOME}/tmp; nohup \"${SHELL:-sh}\" -c %s >${HOME}/tmp/nohup.out 2>&1 & echo \"$! %s\"",
+			shellQuote(command), marker)
 	} else {
 		wrapper = fmt.Sprintf(
-			"nohup %s >/dev/null 2>&1 & echo \"$! %s\"",
-			command, marker)
+			"nohup \"${SHELL:-sh}\" -c %s >/dev/null 2>&1 & echo \"$! %s\"",
+			shellQuote(command), marker)
 	}
 	return wrapper, marker
 }
 
+// defaultMaxLineSize is the line length limit of captureOutput when
+// SSHConfig.MaxLineSize is 0.
+const defaultMaxLineSize = 1 << 20
+
 // captureOutput captures stdout and stderr from SSH session with DEBUG line filtering.
-// Reading is done via bufio.Scanner which handles line boundaries and properly
-// terminates when the pipe is closed (on session end/cancel).
-func captureOutput(ctx context.Context, session *ssh.Session) (*bytes.Buffer, *bytes.Buffer, *sync.WaitGroup) {
+// Reading is done by copyLines, which handles line boundaries and properly
+// terminates when the pipe is closed (on session end/cancel). Kept lines are also
+// written to the non-nil mirror writers.
+func captureOutput(ctx context.Context, session *ssh.Session, maxLine int, stdoutMirror, stderrMirror io.Writer) (*bytes.Buffer, *bytes.Buffer, *sync.WaitGroup) {
 	stdoutPipe, err := session.StdoutPipe()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "capture stdout pipe failed: %v\n", err)
@@ -245,26 +299,21 @@ func captureOutput(ctx context.Context, session *ssh.Session) (*bytes.Buffer, *b
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	copyData := func(dest *bytes.Buffer, src io.Reader) {
+	if maxLine <= 0 {
+		maxLine = defaultMaxLineSize
+	}
+	copyData := func(dest io.Writer, src io.Reader) {
 		defer wg.Done()
-		scanner := bufio.NewScanner(src)
-		for scanner.Scan() {
-			line := scanner.Bytes()
-			// Skip debug lines to reduce noise
-			if !bytes.Contains(line, []byte("DEBUG:")) {
-				dest.Write(line)
-				dest.WriteByte('\n')
-			}
-		}
+		copyLines(dest, src, maxLine)
 	}
 
 	if stdoutPipe != nil {
-		go copyData(&stdoutBuf, stdoutPipe)
+		go copyData(mirrorWriter(&stdoutBuf, stdoutMirror), stdoutPipe)
 	} else {
 		wg.Done()
 	}
 	if stderrPipe != nil {
-		go copyData(&stderrBuf, stderrPipe)
+		go copyData(mirrorWriter(&stderrBuf, stderrMirror), stderrPipe)
 	} else {
 		wg.Done()
 	}
@@ -272,6 +321,57 @@ func captureOutput(ctx context.Context, session *ssh.Session) (*bytes.Buffer, *b
 	return &stdoutBuf, &stderrBuf, &wg
 }
 
+// copyLines copies the lines of src to dest until EOF or a read error,
+// skipping lines that contain "DEBUG:" to reduce noise. Like bufio.ScanLines
+// it drops a trailing "\r" and ends the last line with a newline. A line
+// longer than maxLine bytes is not buffered for filtering but copied through
+// unchanged as it arrives, so no output is dropped or truncated.
+func copyLines(dest io.Writer, src io.Reader, maxLine int) {
+	r := bufio.NewReaderSize(src, min(maxLine, 64*1024))
+	var line []byte
+	long := false // Copying an over-long line through
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !long && len(line)+len(chunk) > maxLine {
+			dest.Write(line)
+			line, long = line[:0], true
+		}
+		if long {
+			dest.Write(chunk)
+		} else {
+			line = append(line, chunk...)
+		}
+		if n := len(chunk); n > 0 && chunk[n-1] == '\n' {
+			if !long {
+				writeLine(dest, line)
+			}
+			line, long = line[:0], false
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if len(line) > 0 {
+				writeLine(dest, line)
+			} else if long {
+				dest.Write([]byte{'\n'})
+			}
+			return
+		}
+	}
+}
+
+// writeLine writes line, with its line ending normalized to "\n", to dest
+// unless it contains "DEBUG:".
+func writeLine(dest io.Writer, line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if !bytes.Contains(line, []byte("DEBUG:")) {
+		dest.Write(line)
+		dest.Write([]byte{'\n'})
+	}
+}
+
 // defaultSSHKeyPath returns the default SSH key path, preferring id_ed25519 over id_rsa.
 func defaultSSHKeyPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
@@ -321,7 +421,8 @@ func getAuthMethod(config SSHConfig) (ssh.AuthMethod, error) {
 	return nil, errors.E(125, "no authentication method provided")
 }
 
-// sshDialWithRetry establishes SSH connection with retry logic
+// sshDialWithRetry establishes SSH connection with retry logic. Authentication
+// and host key failures are not retried, since another attempt cannot succeed.
 func sshDialWithRetry(ctx context.Context, config SSHConfig, clientConfig *ssh.ClientConfig, attempts int) (*ssh.Client, error) {
 	var client *ssh.Client
 	var err error
@@ -331,6 +432,9 @@ func sshDialWithRetry(ctx context.Context, config SSHConfig, clientConfig *ssh.C
 		if err == nil {
 			return client, nil
 		}
+		if category := dialErrorCategory(err); category == ErrSSHAuth || category == ErrSSHHostKey {
+			return nil, err
+		}
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
@@ -451,4 +555,19 @@ type SSHConfig struct {
 	Password   string // Optional, if using password auth
 	Background bool   // If true, run command in background and return PID
 	UseHomeTmp bool   // If true, use ${HOME}/tmp instead of /tmp for temporary files
+
+	// TOFUKnownHostsPath enables trust-on-first-use host key verification
+	// against the given known_hosts file: unknown hosts are recorded and
+	// accepted, changed keys are rejected. Empty keeps InsecureIgnoreHostKey.
+	TOFUKnownHostsPath string
+
+	// HostKeyCallback verifies the server's host key, taking precedence over
+	// TOFUKnownHostsPath, e.g. TOFUHostKeyCallback wrapped to log new keys or
+	// one from knownhosts.New for pre-provisioned files.
+	HostKeyCallback ssh.HostKeyCallback
+
+	// MaxLineSize is the longest output line, in bytes, that is buffered to
+	// filter "DEBUG:" lines; 0 uses defaultMaxLineSize (1 MiB). Longer lines
+	// are still captured whole, copied through unfiltered.
+	MaxLineSize int
 }
@@ -0,0 +1,250 @@
+package exec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kaichao/gopkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// loginShell is the SHELL the test server exports to commands, as sshd does
+// with the user's login shell: bash when available, sh otherwise.
+var loginShell = func() string {
+	if _, err := os.Stat("/bin/bash"); err == nil {
+		return "/bin/bash"
+	}
+	return "/bin/sh"
+}()
+
+// startExecSSHServer starts an SSH server on localhost that accepts the
+// password "secret" and runs "exec" requests locally with /bin/sh, wiring the
+// channel to the command's stdin, stdout and stderr. It returns the port.
+func startExecSSHServer(t *testing.T) int {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected")
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveExecConn(conn, config)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// serveExecConn handles the session channels of one SSH connection.
+func serveExecConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					req.Reply(false, nil)
+					return
+				}
+				req.Reply(true, nil)
+
+				// 与 sshd 一样将 SHELL 设为登录 shell
+				cmd := osexec.Command("/bin/sh", "-c", payload.Command)
+				cmd.Env = append(os.Environ(), "SHELL="+loginShell)
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+				status := make([]byte, 4)
+				if err := cmd.Run(); err != nil {
+					code := 255
+					if exitErr, ok := err.(*osexec.ExitError); ok {
+						code = exitErr.ExitCode()
+					}
+					binary.BigEndian.PutUint32(status, uint32(code))
+				}
+				ch.SendRequest("exit-status", false, status)
+				return
+			}
+		}()
+	}
+}
+
+func TestRunSSHScript(t *testing.T) {
+	port := startExecSSHServer(t)
+	config := SSHConfig{Host: "127.0.0.1", Port: port, User: "test", Password: "secret"}
+
+	dir := t.TempDir()
+	writeScript := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	// 多行脚本, 参数含空格和引号
+	script := writeScript("greet.sh", `#!/bin/bash
+set -e
+name="$1"
+for i in 1 2; do
+  echo "hello $name #$i"
+done
+echo "args=$#" >&2
+echo "quoted=$2"
+`)
+	out, errOut, err := RunSSHScript(config, script, []string{"big world", `it's "ok"; $HOME`}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, "hello big world #1\nhello big world #2\nquoted=it's \"ok\"; $HOME\n", out)
+	assert.Equal(t, "args=2\n", errOut)
+
+	// 退出码传递
+	_, _, err = RunSSHScript(config, writeScript("fail.sh", "echo oops >&2\nexit 7\n"), nil, 5)
+	assert.Equal(t, 7, errors.GetCode(err))
+
+	// 本地脚本不存在
+	_, _, err = RunSSHScript(config, filepath.Join(dir, "missing.sh"), nil, 5)
+	assert.Equal(t, 125, errors.GetCode(err))
+
+	// 不支持后台模式
+	bg := config
+	bg.Background = true
+	_, _, err = RunSSHScript(bg, script, nil, 5)
+	assert.Equal(t, 125, errors.GetCode(err))
+}
+
+func TestSSHConfigHostKeyCallback(t *testing.T) {
+	port := startExecSSHServer(t)
+	path := filepath.Join(t.TempDir(), "known_hosts")
+
+	// 包装 TOFU 回调以记录首次出现的主机
+	var seen []string
+	tofu := TOFUHostKeyCallback(path)
+	config := SSHConfig{
+		Host: "127.0.0.1", Port: port, User: "test", Password: "secret",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			seen = append(seen, hostname)
+			return tofu(hostname, remote, key)
+		},
+	}
+
+	// 首次连接记录主机密钥
+	out, _, err := RunSSHCommand(config, "echo first", 5)
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", out)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "127.0.0.1")
+
+	// 再次连接按已记录的密钥验证
+	out, _, err = RunSSHCommand(config, "echo second", 5)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", out)
+	assert.Len(t, seen, 2)
+
+	// 另一台服务器 (新密钥) 使用同一地址记录时被拒绝
+	other := startExecSSHServer(t)
+	line := fmt.Sprintf("[127.0.0.1]:%d", other)
+	existing, err := os.ReadFile(path)
+	require.NoError(t, err)
+	firstKey := strings.SplitN(strings.TrimSpace(string(existing)), " ", 2)[1]
+	require.NoError(t, os.WriteFile(path, []byte(line+" "+firstKey+"\n"), 0600))
+	config.Port = other
+	_, _, err = RunSSHCommand(config, "echo third", 5)
+	assert.Error(t, err)
+}
+
+func TestBackgroundPID(t *testing.T) {
+	port := startExecSSHServer(t)
+	config := SSHConfig{
+		Host: "127.0.0.1", Port: port, User: "test", Password: "secret",
+		Background: true,
+	}
+
+	// 简单命令、带重定向或命令替换的命令以及复合命令: PID 都对应整个命令, 且无警告
+	for _, command := range []string{
+		"sleep 30",
+		"sleep 30 2>&1",
+		"sleep 30 >/dev/null 2>&1",
+		"echo $(date) >/dev/null; sleep 30",
+		"sleep 30; true", // 旧的包装方式会在前台运行 sleep 30
+		"true && sleep 30 | cat",
+	} {
+		start := time.Now()
+		pid, stderr, err := RunSSHCommand(config, command, 5)
+		require.NoError(t, err, command)
+		assert.Empty(t, stderr, command)
+		assert.Less(t, time.Since(start), 5*time.Second, command)
+
+		n, err := strconv.Atoi(pid)
+		require.NoError(t, err, "pid %q of %q", pid, command)
+		assert.NoError(t, syscall.Kill(n, 0), "process %d of %q not running", n, command)
+		osexec.Command("pkill", "-KILL", "-P", pid).Run()
+		syscall.Kill(n, syscall.SIGKILL)
+	}
+}
+
+func TestBackgroundLoginShell(t *testing.T) {
+	if loginShell != "/bin/bash" {
+		t.Skip("bash not available")
+	}
+	port := startExecSSHServer(t)
+	config := SSHConfig{
+		Host: "127.0.0.1", Port: port, User: "test", Password: "secret",
+		Background: true,
+	}
+
+	// 后台命令在登录 shell (bash) 中运行, 即使 sh 是 dash 也能使用 bash 特性
+	out := filepath.Join(t.TempDir(), "out")
+	command := fmt.Sprintf("set -o pipefail; arr=(a b); [[ ${arr[1]} == b ]] && echo ok > %s", out)
+	_, _, err := RunSSHCommand(config, command, 5)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(out)
+		return string(data) == "ok\n"
+	}, 5*time.Second, 10*time.Millisecond, "bash-only background command did not run")
+}
@@ -57,6 +57,12 @@ func TestWrapCommand(t *testing.T) {
 	})
 }
 
+func TestWrapCommandQuoting(t *testing.T) {
+	// 整个命令作为一个单词交给登录 shell 的 -c, 其中的操作符和引号原样保留
+	wrapper, marker := wrapCommand(`echo 'a; b' "c" 2>&1 | cat`, false)
+	assert.Equal(t, `nohup "${SHELL:-sh}" -c 'echo '\''a; b'\'' "c" 2>&1 | cat' >/dev/null 2>&1 & echo "$! `+marker+`"`, wrapper)
+}
+
 func TestRunSingularityCommand(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -1,8 +1,15 @@
 package exec_test
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -143,4 +150,294 @@ func TestRunReturnAll(t *testing.T) {
 		assert.NotNil(t, err)
 		assert.Equal(t, 124, errors.GetCode(err))
 	})
+
+	// 14. 启动回调获取 PID
+	t.Run("on start callback receives pid", func(t *testing.T) {
+		var pid int
+		out, _, err := exec.RunReturnAll("echo $$", 2, exec.WithOnStart(func(p int) { pid = p }))
+		assert.Nil(t, err)
+		assert.Greater(t, pid, 0)
+		assert.Contains(t, out, strconv.Itoa(pid))
+	})
+
+	// 15. 崩溃诊断: 信号、core dump 与 Go panic 文本
+	t.Run("crash diagnostics", func(t *testing.T) {
+		var res exec.Result
+		_, _, err := exec.RunReturnAll("ulimit -c 0; exec sh -c 'kill -SEGV $$'", 5, exec.WithResult(&res))
+		assert.Equal(t, 128+int(syscall.SIGSEGV), errors.GetCode(err))
+		assert.Equal(t, 128+int(syscall.SIGSEGV), res.ExitCode)
+		assert.Equal(t, syscall.SIGSEGV, res.Signal)
+		assert.False(t, res.CoreDumped) // core 文件大小限制为 0
+		assert.True(t, res.Crashed())
+
+		// 被外部 SIGKILL 终止不算崩溃
+		_, _, err = exec.RunReturnAll("exec sh -c 'kill -KILL $$'", 5, exec.WithResult(&res))
+		assert.Equal(t, 137, errors.GetCode(err))
+		assert.Equal(t, syscall.SIGKILL, res.Signal)
+		assert.False(t, res.Crashed())
+
+		// Go 子进程的 panic 输出
+		_, _, err = exec.RunReturnAll(`echo starting >&2; printf 'panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n' >&2; exit 2`,
+			5, exec.WithResult(&res))
+		assert.Equal(t, 2, errors.GetCode(err))
+		assert.Equal(t, syscall.Signal(0), res.Signal)
+		assert.True(t, strings.HasPrefix(res.PanicText, "panic: boom\n"))
+		assert.Contains(t, res.PanicText, "goroutine 1 [running]")
+		assert.True(t, res.Crashed())
+
+		// 正常退出
+		_, _, err = exec.RunReturnAll("true", 5, exec.WithResult(&res))
+		assert.Nil(t, err)
+		assert.Equal(t, exec.Result{}, res)
+	})
+
+	// 16. 包级默认值: 超时、shell、输出镜像
+	t.Run("package defaults", func(t *testing.T) {
+		saved := exec.Defaults
+		defer func() { exec.Defaults = saved }()
+
+		var mirror bytes.Buffer
+		exec.Defaults = exec.Options{Timeout: 1, Shell: "/bin/sh", Stdout: &mirror}
+
+		// 超时为 0 时使用默认超时
+		start := time.Now()
+		_, _, err := exec.RunReturnAll("sleep 5", 0)
+		assert.Equal(t, 124, errors.GetCode(err))
+		assert.Less(t, time.Since(start), 4*time.Second)
+
+		// 显式超时优先于默认值
+		_, _, err = exec.RunReturnAll("sleep 2", 5)
+		assert.Nil(t, err)
+
+		// 负数超时禁用默认超时
+		_, _, err = exec.RunReturnAll("sleep 2", -1)
+		assert.Nil(t, err)
+
+		// 使用默认 shell
+		out, _, err := exec.RunReturnAll("echo $0", 0)
+		assert.Nil(t, err)
+		assert.Equal(t, "/bin/sh\n", out)
+
+		// 输出同时写入镜像 writer
+		out, _, err = exec.RunReturnAll("echo mirrored", 0)
+		assert.Nil(t, err)
+		assert.Equal(t, "mirrored\n", out)
+		assert.Contains(t, mirror.String(), "mirrored\n")
+	})
+
+	// 17. Quiet: 全局关闭输出透传
+	t.Run("quiet", func(t *testing.T) {
+		saved := exec.Defaults
+		defer func() { exec.Defaults = saved }()
+
+		var mirror bytes.Buffer
+		exec.Defaults = exec.Options{Stdout: &mirror, Stderr: &mirror, Quiet: true}
+
+		// 镜像 writer 被跳过, 输出仍被捕获
+		out, errOut, err := exec.RunReturnAll("echo out; echo err >&2", 5)
+		assert.Nil(t, err)
+		assert.Equal(t, "out\n", out)
+		assert.True(t, strings.HasPrefix(errOut, "err\n"))
+		assert.Empty(t, mirror.String())
+
+		// RunWithRetries 不再回显到 os.Stdout/os.Stderr
+		r, w, err := os.Pipe()
+		assert.Nil(t, err)
+		savedStdout, savedStderr := os.Stdout, os.Stderr
+		os.Stdout, os.Stderr = w, w
+		code, err := exec.RunWithRetries("echo noisy", 1, 5)
+		os.Stdout, os.Stderr = savedStdout, savedStderr
+		w.Close()
+		echoed, _ := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, code)
+		assert.Empty(t, string(echoed))
+	})
+}
+
+func TestRunCheck(t *testing.T) {
+	// 成功返回 nil
+	assert.Nil(t, exec.RunCheck("echo ok", 5))
+
+	// 失败时错误包含退出码和 stderr
+	err := exec.RunCheck("echo 'disk full' >&2; exit 3", 5)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, errors.GetCode(err))
+	assert.Contains(t, err.Error(), "exit code 3")
+	assert.Contains(t, err.Error(), "disk full")
+
+	// 超长 stderr 只保留末尾部分
+	err = exec.RunCheck("head -c 100000 /dev/zero | tr '\\0' x >&2; echo END >&2; exit 1", 5)
+	assert.Equal(t, 1, errors.GetCode(err))
+	assert.Less(t, len(err.Error()), 2200)
+	assert.Contains(t, err.Error(), "END")
+	assert.Contains(t, err.Error(), "...")
+
+	// 超时返回 124
+	err = exec.RunCheck("sleep 5", 1)
+	assert.Equal(t, 124, errors.GetCode(err))
+}
+
+func TestRunLines(t *testing.T) {
+	// 去除首尾空白, 丢弃空行
+	lines, err := exec.RunLines("printf ' alpha \\n\\n\\tbeta\\r\\n  \\ngamma'", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"alpha", "beta", "gamma"}, lines)
+
+	// 无输出时返回空切片
+	lines, err = exec.RunLines("true", 5)
+	assert.Nil(t, err)
+	assert.Empty(t, lines)
+
+	// 非零退出码返回错误, 同时保留已输出的行
+	lines, err = exec.RunLines("echo one; echo oops >&2; exit 2", 5)
+	assert.Equal(t, 2, errors.GetCode(err))
+	assert.Contains(t, err.Error(), "oops")
+	assert.Equal(t, []string{"one"}, lines)
+}
+
+func TestWithCompactOutput(t *testing.T) {
+	// 大量重复行被折叠, 不同行保留原样
+	cmd := `echo start; for i in $(seq 1000); do echo .; done; echo middle; echo warn >&2; echo warn >&2; echo end; printf tail`
+	out, errOut, err := exec.RunReturnAll(cmd, 5, exec.WithCompactOutput())
+	assert.Nil(t, err)
+	assert.Equal(t, "start\n.\n[previous line repeated 999 more times]\nmiddle\nend\ntail", out)
+	assert.True(t, strings.HasPrefix(errOut, "warn\n[previous line repeated 1 more times]\n"), errOut)
+
+	// 未启用时输出不变
+	out, _, err = exec.RunReturnAll(`for i in 1 2 3; do echo x; done`, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "x\nx\nx\n", out)
+}
+
+func TestRunExpect(t *testing.T) {
+	// 退出码符合预期
+	assert.Nil(t, exec.RunExpect("exit 2", 5, exec.ExpectCode(2)))
+	err := exec.RunExpect("exit 1", 5, exec.ExpectCode(0))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "expected exit code 0, got 1")
+
+	// stdout 包含指定内容
+	assert.Nil(t, exec.RunExpect("echo hello world", 5, exec.ExpectStdoutContains("world")))
+	err = exec.RunExpect("echo hello", 5, exec.ExpectStdoutContains("world"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `"world"`)
+
+	// 命令失败时 stdout 断言也失败
+	err = exec.RunExpect("echo world; exit 3", 5, exec.ExpectStdoutContains("world"))
+	assert.Contains(t, err.Error(), "got 3")
+
+	// 自定义断言的错误原样返回
+	custom := errors.E("custom")
+	err = exec.RunExpect("echo x >&2", 5, func(code int, stdout, stderr string) error {
+		if strings.HasPrefix(stderr, "x") {
+			return custom
+		}
+		return nil
+	})
+	assert.Equal(t, custom, err)
+}
+
+func TestWithHeartbeat(t *testing.T) {
+	var mu sync.Mutex
+	var beats []time.Duration
+	record := func(elapsed time.Duration) {
+		mu.Lock()
+		beats = append(beats, elapsed)
+		mu.Unlock()
+	}
+
+	// 3 秒命令, 1 秒心跳
+	_, _, err := exec.RunReturnAll("sleep 3", 10, exec.WithHeartbeat(time.Second, record))
+	assert.Nil(t, err)
+	mu.Lock()
+	n := len(beats)
+	assert.True(t, n >= 2 && n <= 3, "beats: %v", beats)
+	for i, b := range beats {
+		assert.InDelta(t, float64(i+1), b.Seconds(), 0.5)
+	}
+	mu.Unlock()
+
+	// 命令结束后不再触发
+	time.Sleep(1500 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, n, len(beats))
+	mu.Unlock()
+
+	// 超时时同样停止
+	beats = nil
+	_, _, err = exec.RunReturnAll("sleep 10", 1, exec.WithHeartbeat(300*time.Millisecond, record))
+	assert.Equal(t, 124, errors.GetCode(err))
+	mu.Lock()
+	n = len(beats)
+	mu.Unlock()
+	time.Sleep(700 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, n, len(beats))
+	mu.Unlock()
+}
+
+func TestRunReturnAllContext(t *testing.T) {
+	// 外部取消: 返回码 125, 错误包装 context.Canceled, 已捕获的输出保留
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var pid int
+		time.AfterFunc(300*time.Millisecond, cancel)
+		start := time.Now()
+		out, _, err := exec.RunReturnAllContext(ctx, "echo started; sleep 10 & sleep 10", 30,
+			exec.WithOnStart(func(p int) { pid = p }))
+		assert.True(t, time.Since(start) < 2*time.Second)
+		assert.Equal(t, 125, errors.GetCode(err))
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Contains(t, out, "started")
+
+		// 整个进程组 (包括后台子进程) 被杀死
+		deadline := time.Now().Add(time.Second)
+		for liveInGroup(pid) > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, 0, liveInGroup(pid))
+	})
+
+	// ctx 的截止时间早于 timeout: 按超时处理, 返回码 124
+	t.Run("ctx deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err := exec.RunReturnAllContext(ctx, "sleep 10", 30)
+		assert.True(t, time.Since(start) < 2*time.Second)
+		assert.Equal(t, 124, errors.GetCode(err))
+	})
+
+	// timeout 早于 ctx
+	t.Run("timeout first", func(t *testing.T) {
+		_, _, err := exec.RunReturnAllContext(context.Background(), "sleep 10", 1)
+		assert.Equal(t, 124, errors.GetCode(err))
+	})
+
+	// 正常完成
+	t.Run("success", func(t *testing.T) {
+		out, _, err := exec.RunReturnAllContext(context.Background(), "echo ok", 5)
+		assert.Nil(t, err)
+		assert.Equal(t, "ok\n", out)
+	})
+}
+
+// liveInGroup counts the processes of process group pgid that are still
+// running; zombies are left out, since init may not reap them in a container.
+func liveInGroup(pgid int) int {
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	n := 0
+	for _, path := range stats {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// 格式: pid (comm) state ppid pgrp ...
+		fields := strings.Fields(string(data[bytes.LastIndexByte(data, ')')+1:]))
+		if len(fields) > 2 && fields[0] != "Z" && fields[2] == strconv.Itoa(pgid) {
+			n++
+		}
+	}
+	return n
 }
@@ -0,0 +1,52 @@
+package exec
+
+import (
+	stderrors "errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Categories of SSH connection failures. Errors returned by RunSSHCommand and
+// NewInteractive wrap one of these when the cause can be identified, so callers
+// can test with errors.Is, e.g. to retry only on ErrSSHUnreachable.
+var (
+	// ErrSSHAuth means the server rejected every offered credential.
+	ErrSSHAuth = stderrors.New("ssh: authentication failed")
+	// ErrSSHUnreachable means no connection could be established: DNS
+	// failure, connection refused, network timeout and the like.
+	ErrSSHUnreachable = stderrors.New("ssh: host unreachable")
+	// ErrSSHHostKey means the server's host key was rejected, e.g. it
+	// changed since it was recorded in known_hosts.
+	ErrSSHHostKey = stderrors.New("ssh: host key verification failed")
+)
+
+// classifyDialError wraps err with the matching ErrSSH* category, keeping
+// err in the chain. Errors that fit no category are returned unchanged.
+func classifyDialError(err error) error {
+	if category := dialErrorCategory(err); category != nil {
+		return fmt.Errorf("%w: %w", category, err)
+	}
+	return err
+}
+
+// dialErrorCategory returns the ErrSSH* category of an ssh.Dial error, or nil.
+func dialErrorCategory(err error) error {
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case stderrors.As(err, &keyErr), stderrors.As(err, &revokedErr):
+		return ErrSSHHostKey
+	case strings.Contains(err.Error(), "ssh: unable to authenticate"):
+		// x/crypto/ssh reports authentication failure as an untyped error
+		return ErrSSHAuth
+	case stderrors.As(err, &netErr):
+		return ErrSSHUnreachable
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package exec
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaichao/gopkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// startRejectingSSHServer starts an SSH server on localhost that rejects every
+// authentication attempt, and returns its port and host key.
+func startRejectingSSHServer(t *testing.T) (int, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("password rejected")
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, signer.PublicKey()
+}
+
+func TestSSHDialErrorClassification(t *testing.T) {
+	port, _ := startRejectingSSHServer(t)
+	config := SSHConfig{Host: "127.0.0.1", Port: port, User: "test", Password: "wrong"}
+
+	t.Run("auth rejected", func(t *testing.T) {
+		_, _, err := RunSSHCommand(config, "true", 5)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrSSHAuth)
+		assert.NotErrorIs(t, err, ErrSSHUnreachable)
+		assert.Equal(t, 125, errors.GetCode(err))
+	})
+
+	t.Run("host key changed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "known_hosts")
+		host := knownhosts.Normalize(fmt.Sprintf("127.0.0.1:%d", port))
+		line := knownhosts.Line([]string{host}, newTestHostKey(t))
+		require.NoError(t, os.WriteFile(path, []byte(line+"\n"), 0600))
+
+		cfg := config
+		cfg.TOFUKnownHostsPath = path
+		_, _, err := RunSSHCommand(cfg, "true", 5)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrSSHHostKey)
+		assert.NotErrorIs(t, err, ErrSSHAuth)
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedPort := ln.Addr().(*net.TCPAddr).Port
+		ln.Close()
+
+		cfg := config
+		cfg.Port = closedPort
+		_, _, err = RunSSHCommand(cfg, "true", 5)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrSSHUnreachable)
+		assert.NotErrorIs(t, err, ErrSSHAuth)
+	})
+
+	t.Run("unclassified", func(t *testing.T) {
+		assert.NoError(t, classifyDialError(nil))
+		err := fmt.Errorf("ssh: handshake failed: EOF")
+		assert.Equal(t, err, classifyDialError(err))
+	})
+}
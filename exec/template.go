@@ -0,0 +1,80 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// RunTemplate runs the command built from tmpl by replacing each {{name}}
+// placeholder with args[name] quoted as a single shell word, so values from
+// untrusted input cannot break out of their argument. It returns the same
+// results as RunReturnAll.
+//
+// Quoting rules:
+//   - a value is wrapped in single quotes, each ' in it closing the quotes,
+//     adding an escaped \' and reopening them, so the shell takes it
+//     literally: no expansion, globbing, word splitting or command separators
+//     (e.g. "x; rm -rf /" stays one argument)
+//   - a placeholder may be joined to other text of its word (--name={{name}})
+//     but must not be inside quotes, where the added quotes would change the
+//     meaning; RunTemplate fails with exit code 125 if it is
+//   - \{{ outside single quotes keeps a literal "{{" (the shell drops the \)
+//   - placeholder names may be surrounded by spaces ({{ name }}); a
+//     placeholder without a value in args, or an unterminated "{{", also fails
+//     with exit code 125
+//   - the rest of tmpl is passed to the shell unchanged and must be trusted
+//
+// Params:
+//   - tmpl: the command template
+//   - args: the values of the placeholders
+//   - timeout: timeout in seconds (0 uses Defaults.Timeout, negative for no timeout)
+func RunTemplate(tmpl string, args map[string]string, timeout int) (string, string, error) {
+	command, err := renderCommand(tmpl, args)
+	if err != nil {
+		return "", "", err
+	}
+	return RunReturnAll(command, timeout)
+}
+
+// renderCommand substitutes the placeholders of tmpl as described for
+// RunTemplate.
+func renderCommand(tmpl string, args map[string]string) (string, error) {
+	var sb strings.Builder
+	var quote byte
+	escaped := false
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case c == '{' && strings.HasPrefix(tmpl[i:], "{{"):
+			end := strings.Index(tmpl[i+2:], "}}")
+			if end < 0 {
+				return "", errors.E(125, "unterminated placeholder in command template", "offset", i)
+			}
+			name := strings.TrimSpace(tmpl[i+2 : i+2+end])
+			if quote != 0 {
+				return "", errors.E(125, fmt.Sprintf("placeholder %q inside quotes in command template", name))
+			}
+			value, ok := args[name]
+			if !ok {
+				return "", errors.E(125, fmt.Sprintf("placeholder %q has no value", name))
+			}
+			sb.WriteString(shellQuote(value))
+			i += 2 + end + 1 // Skip to the second '}'
+			continue
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String(), nil
+}
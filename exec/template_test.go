@@ -0,0 +1,47 @@
+package exec_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaichao/gopkg/errors"
+	"github.com/kaichao/gopkg/exec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunTemplate(t *testing.T) {
+	// 注入的命令分隔符被当作普通参数, 不会执行
+	marker := filepath.Join(t.TempDir(), "injected")
+	out, _, err := exec.RunTemplate("printf '%s|' {{name}} --opt={{ opt }}", map[string]string{
+		"name": "x; touch " + marker + "; echo pwned",
+		"opt":  `it's $(whoami) "quoted" *`,
+	}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "x; touch "+marker+"; echo pwned|--opt=it's $(whoami) \"quoted\" *|", out)
+	_, statErr := os.Stat(marker)
+	assert.True(t, os.IsNotExist(statErr), "injected command was executed")
+
+	// 典型的 "; rm -rf /" 只是一个参数
+	out, _, err = exec.RunTemplate("echo {{path}}", map[string]string{"path": "/tmp/a; rm -rf /"}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/a; rm -rf /\n", out)
+
+	// 模板其余部分保持不变, \{{ 保留字面量, 空值也是一个参数
+	out, _, err = exec.RunTemplate(`printf '[%s]' "a b" \{{empty}} {{empty}}`, map[string]string{"empty": ""}, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "[a b][{{empty}}][]", out)
+
+	// 引号内的占位符会改变含义, 返回 125
+	for _, tmpl := range []string{`echo "{{x}}"`, `echo '{{x}}'`} {
+		_, _, err = exec.RunTemplate(tmpl, map[string]string{"x": "v"}, 5)
+		assert.Equal(t, 125, errors.GetCode(err), tmpl)
+	}
+
+	// 缺少参数或占位符未闭合时返回 125, 不执行命令
+	_, _, err = exec.RunTemplate("echo {{missing}}", nil, 5)
+	assert.Equal(t, 125, errors.GetCode(err))
+	assert.Contains(t, err.Error(), "missing")
+	_, _, err = exec.RunTemplate("echo {{name", map[string]string{"name": "x"}, 5)
+	assert.Equal(t, 125, errors.GetCode(err))
+}
@@ -0,0 +1,110 @@
+package pgbulk
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxBindParams is PostgreSQL's limit on bind parameters per statement.
+const maxBindParams = 65535
+
+// paramOverheadBytes is the estimated per-value cost beyond the value itself:
+// the 4-byte length word of the bind message plus the "$NNNNN," placeholder.
+const paramOverheadBytes = 12
+
+// CountBatches returns how many statements the chunking bulk functions
+// (InsertIgnoreConflicts, InsertSavepoint) split rowCount rows of paramsPerRow
+// values into, using the same maxBindParams/paramsPerRow rows per statement.
+// It runs nothing, so it suits sizing progress bars. A non-positive rowCount
+// gives 0; a non-positive paramsPerRow is treated as 1.
+func CountBatches(rowCount, paramsPerRow int) int {
+	if rowCount <= 0 {
+		return 0
+	}
+	n := rowsPerBatch(paramsPerRow)
+	return (rowCount + n - 1) / n
+}
+
+// CountDataBatches is CountBatches for data, taking the parameters per row
+// from its first row. With WithMaxBatchBytes it also applies the byte limit,
+// estimating each value as its length for strings and []byte, its fixed size
+// for numbers, bools and time.Time, and the length of its fmt.Sprint form
+// otherwise, plus a fixed per-value overhead.
+func CountDataBatches(data [][]interface{}, opts ...Option) int {
+	if len(data) == 0 {
+		return 0
+	}
+	maxBytes := applyOptions(opts).maxBatchBytes
+	if maxBytes <= 0 {
+		return CountBatches(len(data), len(data[0]))
+	}
+	return len(batchEnds(data, len(data[0]), maxBytes))
+}
+
+// rowsPerBatch returns how many rows of paramsPerRow values fit in one
+// statement, at least 1.
+func rowsPerBatch(paramsPerRow int) int {
+	if paramsPerRow < 1 {
+		paramsPerRow = 1
+	}
+	return max(1, maxBindParams/paramsPerRow)
+}
+
+// batchEnds splits data into statements of at most rowsPerBatch(numCols) rows
+// and, if maxBytes is positive, at most maxBytes estimated bytes, and returns
+// the exclusive end index of each statement. Every statement holds at least
+// one row.
+func batchEnds(data [][]interface{}, numCols, maxBytes int) []int {
+	batchRows := rowsPerBatch(numCols)
+	var ends []int
+	start, size := 0, 0
+	for i, row := range data {
+		rowSize := 0
+		if maxBytes > 0 {
+			rowSize = estimateRowBytes(row)
+		}
+		if i > start && (i-start == batchRows || (maxBytes > 0 && size+rowSize > maxBytes)) {
+			ends = append(ends, i)
+			start, size = i, 0
+		}
+		size += rowSize
+	}
+	if len(data) > 0 {
+		ends = append(ends, len(data))
+	}
+	return ends
+}
+
+// estimateRowBytes returns an upper estimate of the bytes row adds to a
+// statement.
+func estimateRowBytes(row []interface{}) int {
+	n := 0
+	for _, v := range row {
+		n += paramOverheadBytes + estimateValueBytes(v)
+	}
+	return n
+}
+
+// estimateValueBytes returns an upper estimate of the encoded size of v.
+func estimateValueBytes(v interface{}) int {
+	switch x := v.(type) {
+	case nil, null:
+		return 0
+	case string:
+		return len(x)
+	case []byte:
+		return len(x)
+	case bool, int8, uint8:
+		return 1
+	case int16, uint16:
+		return 2
+	case int32, uint32, float32:
+		return 4
+	case int, int64, uint, uint64, float64:
+		return 8
+	case time.Time:
+		return 8
+	default:
+		return len(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,57 @@
+package pgbulk_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountBatches(t *testing.T) {
+	// 3 columns: 21845 rows per statement
+	assert.Equal(t, 0, pgbulk.CountBatches(0, 3))
+	assert.Equal(t, 1, pgbulk.CountBatches(1, 3))
+	assert.Equal(t, 1, pgbulk.CountBatches(21845, 3))
+	assert.Equal(t, 2, pgbulk.CountBatches(21846, 3))
+	assert.Equal(t, 2, pgbulk.CountBatches(43690, 3))
+	assert.Equal(t, 3, pgbulk.CountBatches(43691, 3))
+
+	// 1 column: exactly the bind parameter limit per statement
+	assert.Equal(t, 1, pgbulk.CountBatches(65535, 1))
+	assert.Equal(t, 2, pgbulk.CountBatches(65536, 1))
+
+	// Rows wider than the limit still go one per statement
+	assert.Equal(t, 5, pgbulk.CountBatches(5, 70000))
+	assert.Equal(t, 1, pgbulk.CountBatches(10, 0))
+
+	data := make([][]interface{}, 21846)
+	for i := range data {
+		data[i] = []interface{}{i, "name", true}
+	}
+	assert.Equal(t, 2, pgbulk.CountDataBatches(data))
+	assert.Equal(t, 0, pgbulk.CountDataBatches(nil))
+}
+
+func TestCountDataBatchesMaxBytes(t *testing.T) {
+	// 100 rows with a 10 KB text value: far below the parameter limit
+	large := strings.Repeat("x", 10*1024)
+	data := make([][]interface{}, 100)
+	for i := range data {
+		data[i] = []interface{}{i, large}
+	}
+	assert.Equal(t, 1, pgbulk.CountDataBatches(data))
+
+	// A 64 KB byte limit fits 6 rows (each about 10 KB) per statement
+	assert.Equal(t, 17, pgbulk.CountDataBatches(data, pgbulk.WithMaxBatchBytes(64*1024)))
+
+	// A row above the limit still goes alone
+	assert.Equal(t, 100, pgbulk.CountDataBatches(data, pgbulk.WithMaxBatchBytes(1024)))
+
+	// The parameter limit still applies when the byte limit is not reached
+	small := make([][]interface{}, 21846)
+	for i := range small {
+		small[i] = []interface{}{i, "name", true}
+	}
+	assert.Equal(t, 2, pgbulk.CountDataBatches(small, pgbulk.WithMaxBatchBytes(1<<30)))
+}
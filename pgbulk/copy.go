@@ -2,36 +2,35 @@ package pgbulk
 
 import (
 	"context"
-	"regexp"
-	"strings"
 
 	pgx "github.com/jackc/pgx/v5"
 	"github.com/kaichao/gopkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Copy performs a batch insert into PostgreSQL using pgx's CopyFrom
+// Copy performs a batch insert into PostgreSQL using pgx's CopyFrom, which
+// always uses the binary COPY format (COPY ... FROM STDIN BINARY); values are
+// encoded according to the target column types, so numeric and timestamp data
+// avoid text formatting and parsing.
+// Table and column names in sqlTemplate must be plain identifiers
+// (optionally schema-qualified); anything else is rejected.
 func Copy(conn *pgx.Conn, sqlTemplate string, data [][]interface{}) (int, error) {
 	if len(data) == 0 {
 		return 0, nil
 	}
 
-	re := regexp.MustCompile(`INSERT\s+INTO\s+(\w+)\s*\(([^)]*)\)`)
-	matches := re.FindStringSubmatch(sqlTemplate)
-	if len(matches) != 3 {
-		return 0, errors.E("invalid sqlTemplate format")
-	}
-	tableName := matches[1]
-	columns := strings.Split(matches[2], ",")
-	for i := range columns {
-		columns[i] = strings.TrimSpace(columns[i])
+	tableName, columns, err := parseSQLTemplate(sqlTemplate)
+	if err != nil {
+		return 0, err
 	}
 
 	copyCount, err := conn.CopyFrom(
 		context.Background(),
-		pgx.Identifier{tableName},
+		tableName,
 		columns,
-		pgx.CopyFromRows(data),
+		pgx.CopyFromSlice(len(data), func(i int) ([]interface{}, error) {
+			return appendArgs(make([]interface{}, 0, len(data[i])), data[i]), nil
+		}),
 	)
 	if err != nil {
 		return 0, errors.WrapE(err, "pgx.CopyFrom", "sql-template", sqlTemplate)
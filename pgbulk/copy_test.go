@@ -3,6 +3,7 @@ package pgbulk_test
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"testing"
@@ -133,3 +134,137 @@ func TestCopy_RealPostgres(t *testing.T) {
 
 	assert.Equal(t, len(data), readCount)
 }
+
+func TestCopy_BinaryNumericAndTimestamp(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_copy_binary", `
+		CREATE TABLE test_copy_binary (
+			id INT PRIMARY KEY,
+			amount NUMERIC(20,6),
+			ratio DOUBLE PRECISION,
+			big BIGINT,
+			created TIMESTAMPTZ,
+			local_ts TIMESTAMP
+		)
+	`)
+	defer cleanup()
+
+	created := time.Date(2024, 2, 29, 23, 59, 59, 123456000, time.UTC)
+	data := [][]interface{}{
+		{1, "12345678901234.123456", 0.125, int64(9007199254740993), created, created},
+		{2, 42, -1.5e-10, int64(-1), created.Add(-time.Hour), created.Add(time.Hour)},
+	}
+	n, err := pgbulk.Copy(conn, "INSERT INTO test_copy_binary (id, amount, ratio, big, created, local_ts)", data)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	var amount string
+	var ratio float64
+	var big int64
+	var gotCreated, gotLocal time.Time
+	err = conn.QueryRow(ctx, "SELECT amount::text, ratio, big, created, local_ts FROM test_copy_binary WHERE id = 1").
+		Scan(&amount, &ratio, &big, &gotCreated, &gotLocal)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678901234.123456", amount)
+	assert.Equal(t, 0.125, ratio)
+	assert.Equal(t, int64(9007199254740993), big)
+	assert.True(t, created.Equal(gotCreated), "timestamptz: got %v", gotCreated)
+	assert.Equal(t, created, gotLocal.UTC())
+
+	err = conn.QueryRow(ctx, "SELECT amount::text, ratio, big FROM test_copy_binary WHERE id = 2").
+		Scan(&amount, &ratio, &big)
+	assert.NoError(t, err)
+	assert.Equal(t, "42.000000", amount)
+	assert.Equal(t, -1.5e-10, ratio)
+	assert.Equal(t, int64(-1), big)
+}
+
+func TestCopyWithTypes_Numeric(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_copy_types", `
+		CREATE TABLE test_copy_types (
+			id BIGINT PRIMARY KEY,
+			amount NUMERIC(30,10),
+			label TEXT
+		)
+	`)
+	defer cleanup()
+
+	sqlTemplate := "INSERT INTO test_copy_types (id, amount, label)"
+	data := [][]interface{}{
+		{"1", json.Number("12345678901234567890.0123456789"), 42},
+		{2, json.Number("0.5"), 1.5},
+		{3, nil, pgbulk.Null},
+	}
+
+	// Without hints pgx cannot encode an int into a text column.
+	_, err := pgbulk.Copy(conn, sqlTemplate, data)
+	assert.Error(t, err)
+
+	n, err := pgbulk.CopyWithTypes(conn, sqlTemplate, data, []string{"bigint", "numeric", "text"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	var amount, label string
+	err = conn.QueryRow(ctx, "SELECT amount::text, label FROM test_copy_types WHERE id = 1").Scan(&amount, &label)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678901234567890.0123456789", amount)
+	assert.Equal(t, "42", label)
+
+	var nullAmount *string
+	err = conn.QueryRow(ctx, "SELECT amount::text FROM test_copy_types WHERE id = 3").Scan(&nullAmount)
+	assert.NoError(t, err)
+	assert.Nil(t, nullAmount)
+
+	// Hint count must match the template columns; unknown names are rejected.
+	_, err = pgbulk.CopyWithTypes(conn, sqlTemplate, data, []string{"numeric"})
+	assert.Error(t, err)
+	_, err = pgbulk.CopyWithTypes(conn, sqlTemplate, data, []string{"", "money2", ""})
+	assert.Error(t, err)
+
+	// A value the hinted type cannot parse fails before anything is written.
+	_, err = pgbulk.CopyWithTypes(conn, sqlTemplate, [][]interface{}{{4, "abc", "x"}}, []string{"", "numeric", ""})
+	assert.Error(t, err)
+}
+
+// BenchmarkBulkLoad compares Copy (binary COPY protocol) with Insert
+// (multi-row INSERT with bind parameters) for numeric and timestamp rows.
+func BenchmarkBulkLoad(b *testing.B) {
+	conn := getTestConn(b)
+	cleanup := setupTestTable(b, conn, "bench_bulk_load", `
+		CREATE TABLE bench_bulk_load (
+			amount NUMERIC,
+			ratio DOUBLE PRECISION,
+			created TIMESTAMPTZ
+		)
+	`)
+	defer cleanup()
+
+	const rows = 5000
+	data := make([][]interface{}, rows)
+	now := time.Now()
+	for i := range data {
+		data[i] = []interface{}{i, float64(i) / 3, now.Add(time.Duration(i) * time.Second)}
+	}
+	sqlTemplate := "INSERT INTO bench_bulk_load (amount, ratio, created)"
+
+	b.Run("Copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := pgbulk.Copy(conn, sqlTemplate, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Insert", func(b *testing.B) {
+		// rows*3 parameters stay under PostgreSQL's 65535 bind parameter limit
+		for i := 0; i < b.N; i++ {
+			if err := pgbulk.Insert(conn, sqlTemplate, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
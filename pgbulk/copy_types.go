@@ -0,0 +1,145 @@
+package pgbulk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pgx "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/kaichao/gopkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// typeAliases maps SQL-standard type names to the PostgreSQL internal names
+// registered in pgx's type map.
+var typeAliases = map[string]string{
+	"smallint":                    "int2",
+	"integer":                     "int4",
+	"int":                         "int4",
+	"bigint":                      "int8",
+	"real":                        "float4",
+	"double precision":            "float8",
+	"decimal":                     "numeric",
+	"boolean":                     "bool",
+	"character varying":           "varchar",
+	"timestamp with time zone":    "timestamptz",
+	"timestamp without time zone": "timestamp",
+}
+
+// CopyWithTypes is Copy with an explicit type hint per column. columnTypes must
+// have one entry per template column; an empty entry leaves that column to
+// pgx's own inference. A hint is a PostgreSQL type name as registered in the
+// connection's type map (int2, int4, int8, float4, float8, numeric, text,
+// varchar, bool, date, timestamp, timestamptz, json, jsonb, uuid, arrays as
+// _int4 etc.); the SQL-standard spellings smallint, integer, bigint, real,
+// double precision, decimal, boolean, character varying and timestamp with/
+// without time zone are accepted as aliases.
+//
+// A value the hinted type cannot encode directly is converted through its text
+// form: json.Number and decimal strings become pgtype.Numeric for numeric
+// (exact, no float64 rounding), numbers become strings for text, and strings
+// are parsed by the hinted type. Strings
+// must therefore use PostgreSQL's text format (e.g. "2024-01-02 03:04:05Z" for
+// timestamptz). A value that cannot be converted fails with the row index and
+// column name instead of an opaque encode error from COPY.
+func CopyWithTypes(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, columnTypes []string) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	tableName, columns, err := parseSQLTemplate(sqlTemplate)
+	if err != nil {
+		return 0, err
+	}
+	if len(columnTypes) != len(columns) {
+		return 0, errors.E("column type count mismatch",
+			"columns", len(columns), "column-types", len(columnTypes))
+	}
+
+	m := conn.TypeMap()
+	types := make([]*pgtype.Type, len(columnTypes))
+	for i, name := range columnTypes {
+		if name == "" {
+			continue
+		}
+		if types[i], err = resolveColumnType(m, name); err != nil {
+			return 0, err
+		}
+	}
+
+	copyCount, err := conn.CopyFrom(
+		context.Background(),
+		tableName,
+		columns,
+		pgx.CopyFromSlice(len(data), func(i int) ([]interface{}, error) {
+			row := make([]interface{}, 0, len(data[i]))
+			for j, v := range data[i] {
+				if isNull(v) {
+					row = append(row, nil)
+					continue
+				}
+				if j >= len(types) || types[j] == nil {
+					row = append(row, v)
+					continue
+				}
+				cv, err := convertCopyValue(m, types[j], v)
+				if err != nil {
+					return nil, errors.WrapE(err, "convert column value",
+						"row", i, "column", columns[j], "type", columnTypes[j])
+				}
+				row = append(row, cv)
+			}
+			return row, nil
+		}),
+	)
+	if err != nil {
+		return 0, errors.WrapE(err, "pgx.CopyFrom", "sql-template", sqlTemplate)
+	}
+
+	logrus.Tracef("Total copied: %d rows.", copyCount)
+	return int(copyCount), nil
+}
+
+// resolveColumnType looks up a type hint in m, accepting the aliases in typeAliases.
+func resolveColumnType(m *pgtype.Map, name string) (*pgtype.Type, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := typeAliases[key]; ok {
+		key = alias
+	}
+	t, ok := m.TypeForName(key)
+	if !ok {
+		return nil, errors.E("unknown column type", "type", name)
+	}
+	return t, nil
+}
+
+// convertCopyValue returns v unchanged if t can binary-encode it; otherwise it
+// renders v as text and parses that text as t.
+func convertCopyValue(m *pgtype.Map, t *pgtype.Type, v interface{}) (interface{}, error) {
+	if _, err := m.Encode(t.OID, pgtype.BinaryFormatCode, v, nil); err == nil {
+		return v, nil
+	}
+
+	var text []byte
+	switch x := v.(type) {
+	case string:
+		text = []byte(x)
+	case []byte:
+		text = x
+	default:
+		if vt, ok := m.TypeForValue(v); ok {
+			if buf, err := m.Encode(vt.OID, pgtype.TextFormatCode, v, nil); err == nil {
+				text = buf
+				break
+			}
+		}
+		text = []byte(fmt.Sprint(v))
+	}
+
+	var out interface{}
+	if err := m.Scan(t.OID, pgtype.TextFormatCode, text, &out); err != nil {
+		return nil, errors.WrapE(err, "parse value as column type", "value", string(text))
+	}
+	return out, nil
+}
@@ -77,23 +77,131 @@
 //
 // Available Functions:
 //
-//	// Copy performs a batch insert using PostgreSQL's COPY command
+//	// Copy performs a batch insert using PostgreSQL's binary COPY protocol
 //	func Copy(conn *pgx.Conn, sqlTemplate string, data [][]interface{}) (int, error)
 //
+//	// CopyWithTypes is Copy with an explicit PostgreSQL type name per column
+//	func CopyWithTypes(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, columnTypes []string) (int, error)
+//
 //	// Insert inserts data into database using provided SQL template and data
 //	func Insert(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, onConflict ...string) error
 //
 //	// InsertReturningID inserts data and returns IDs of inserted rows
 //	func InsertReturningID(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, returningColumnAndOnConflict ...string) ([]int, error)
 //
+//	// InsertReturningIDOpts is InsertReturningID in a transaction, honoring WithIsolationLevel and WithOnConflict
+//	func InsertReturningIDOpts(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, returning string, opts ...Option) ([]int, error)
+//
 //	// Update performs a bulk update using the provided SQL template, data, and ids
-//	func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]interface{}) ([][]interface{}, error)
+//	func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]interface{}, opts ...Option) ([][]interface{}, error)
+//
+//	// InsertReturning inserts data and returns the returning columns of each inserted row (composite/UUID keys)
+//	func InsertReturning(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, returning string, onConflict ...string) ([][]interface{}, error)
+//
+//	// Exec runs a statement once per parameter set in one batch and transaction, returning rows affected
+//	func Exec(ctx context.Context, conn *pgx.Conn, sqlTemplate string, paramSets [][]interface{}, opts ...Option) (int64, error)
+//
+//	// WithIsolationLevel sets the transaction isolation level (Update, Exec, InsertIgnoreConflicts, InsertReturningIDOpts)
+//	func WithIsolationLevel(level pgx.TxIsoLevel) Option
+//
+//	// WithOnConflict sets the ON CONFLICT clause of InsertReturningIDOpts
+//	func WithOnConflict(clause string) Option
+//
+//	// IsSerializationFailure reports SQLSTATE 40001/40P01, which are resolved by retrying
+//	func IsSerializationFailure(err error) bool
+//
+//	// InsertSavepoint inserts inside tx under a named savepoint; on error it rolls back to it, keeping tx usable
+//	func InsertSavepoint(tx pgx.Tx, name, sqlTemplate string, data [][]interface{}, opts ...Option) error
+//
+//	// QueryBatched streams the rows of a SELECT in chunks of batchSize; drain batches
+//	// (or cancel ctx to stop early), then read errs
+//	func QueryBatched(ctx context.Context, conn *pgx.Conn, query string, batchSize int, args ...interface{}) (<-chan [][]interface{}, <-chan error)
+//
+//	// CountBatches returns how many statements the chunking functions split rows into (65535/paramsPerRow rows each)
+//	func CountBatches(rowCount, paramsPerRow int) int
+//	func CountDataBatches(data [][]interface{}, opts ...Option) int
+//
+//	// PlanBulkInsert returns the statement plan (rows, params per row, rows per statement) without executing
+//	func PlanBulkInsert(sqlTemplate string, data [][]interface{}, opts ...Option) (BulkPlan, error)
+//
+//	// ValidateData checks data against the table's column types before a bulk load
+//	func ValidateData(conn *pgx.Conn, table string, columns []string, data [][]interface{}) error
+//
+//	// InsertIgnoreConflicts inserts with ON CONFLICT DO NOTHING, returning inserted and skipped counts
+//	func InsertIgnoreConflicts(conn *pgx.Conn, table string, columns, conflictColumns []string, data [][]interface{}, opts ...Option) (inserted, skipped int, err error)
+//
+//	// TableColumns returns a table's columns (name, type, nullability, default) in ordinal order
+//	func TableColumns(conn *pgx.Conn, table string) ([]ColumnInfo, error)
+//
+//	// ResetSequence sets a serial/identity column's sequence to MAX(id) after loading explicit ids
+//	func ResetSequence(conn *pgx.Conn, table, idColumn string) error
+//
+// Available Options:
+//
+//	// WithContinueOnError makes Update execute every statement independently (no
+//	// transaction) and return the ids of all failed statements
+//	func WithContinueOnError() Option
+//
+//	// WithWhere appends " AND (condition)" with per-row parameters to Update's
+//	// statements, e.g. an optimistic concurrency guard "version = $1"
+//	func WithWhere(condition string, args [][]interface{}) Option
+//
+//	// WithRowsAffected receives the rows each Update statement changed
+//	func WithRowsAffected(counts *[]int64) Option
+//
+//	// WithMaxBatchBytes also closes a multi-row statement at an estimated size of n
+//	// bytes (InsertIgnoreConflicts, InsertSavepoint, CountDataBatches, PlanBulkInsert)
+//	func WithMaxBatchBytes(n int) Option
+//
+// Naming:
+// Functions are named without a Bulk prefix, since the package name already
+// says it, and take the package's *pgx.Conn or pgx.Tx. Where a function was
+// proposed under another name or signature, it deviates as follows:
+//   - Exec (proposed as BulkExec(conn, sqlTemplate, paramSets)): the Bulk
+//     prefix is dropped, and a leading ctx bounds the whole batch in place of
+//     a fixed timeout, as in QueryBatched.
+//   - InsertIgnoreConflicts (proposed as BulkInsertIgnoreConflicts(db *sql.DB,
+//     ...)): takes a *pgx.Conn, as every other function here does; pgbulk
+//     depends on pgx only, and a database/sql pool cannot run pgx batches.
+//     Callers holding a *sql.DB backed by pgx's stdlib driver can reach the
+//     connection with (*sql.Conn).Raw and stdlib.Conn.
+//   - InsertSavepoint (proposed as BulkInsertSavepoint(tx, name, sqlTemplate,
+//     data)): the Bulk prefix is dropped, and trailing options are accepted
+//     for WithMaxBatchBytes; the requested arguments are accepted as they are.
 //
 // Dependencies:
 // - github.com/jackc/pgx/v5
 // - github.com/kaichao/gopkg/errors
 // - github.com/sirupsen/logrus (for trace logging in Copy function)
 //
+// Template Validation:
+// Copy extracts table and column names from the template; each must match
+// ^[a-zA-Z_][a-zA-Z0-9_]*$ (the table may be schema-qualified). Templates with
+// spaces, quotes, semicolons or comment sequences in identifiers are rejected.
+//
+// COPY Format:
+// Copy always uses the binary COPY format through pgx, the fastest path for
+// large numeric/timestamp datasets; there is no text-format mode to opt out of.
+// Every column type must support binary encoding in pgx, which holds for all
+// built-in types. BenchmarkBulkLoad compares Copy with Insert.
+//
+// Column Type Hints:
+// CopyWithTypes takes one PostgreSQL type name per column ("" = no hint) and
+// converts each value the hinted type cannot encode through its text form, so
+// e.g. json.Number or a decimal string loads exactly into numeric and an int
+// loads into text. Names are those of pgx's type map (int2, int4, int8,
+// float4, float8, numeric, text, varchar, bool, date, timestamp, timestamptz,
+// json, jsonb, uuid, _int4 for arrays, ...); smallint, integer, int, bigint,
+// real, double precision, decimal, boolean, character varying and
+// timestamp with/without time zone are accepted as aliases. Unknown names and
+// a hint count that differs from the column count are errors.
+//
+// NULL Values:
+// A Go nil in data[i][j] is written as SQL NULL by Insert, InsertReturningID,
+// Update and Copy, for any column type. pgbulk.Null is an explicit equivalent;
+// use it where a typed nil (e.g. a nil map for a jsonb column) could otherwise be
+// encoded as a value.
+//
 // Error Handling:
 // All functions use github.com/kaichao/gopkg/errors for enhanced error tracing and context.
 //
@@ -0,0 +1,51 @@
+package pgbulk
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// Exec runs sqlTemplate once per parameter set, queued in a single pgx.Batch
+// (one round trip) inside one transaction, and returns the total number of
+// rows affected. It generalizes Update's batch mechanism to any statement,
+// e.g. a DELETE or an UPDATE with a CASE expression. On the first failure the
+// transaction is rolled back and the error carries the failing set's index.
+// The whole batch runs under ctx, so its deadline bounds the batch; there is
+// no timeout of its own. Honors WithIsolationLevel.
+func Exec(ctx context.Context, conn *pgx.Conn, sqlTemplate string, paramSets [][]interface{}, opts ...Option) (int64, error) {
+	if len(paramSets) == 0 {
+		return 0, nil
+	}
+
+	tx, err := conn.BeginTx(ctx, applyOptions(opts).txOptions())
+	if err != nil {
+		return 0, errors.WrapE(err, "start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	for _, params := range paramSets {
+		batch.Queue(sqlTemplate, appendArgs(nil, params)...)
+	}
+
+	br := tx.SendBatch(ctx, batch)
+	var total int64
+	for i := 0; i < batch.Len(); i++ {
+		tag, err := br.Exec()
+		if err != nil {
+			br.Close()
+			return 0, errors.WrapE(err, "batch execution", "record-num", i, "sql-template", sqlTemplate)
+		}
+		total += tag.RowsAffected()
+	}
+
+	if err := br.Close(); err != nil {
+		return 0, errors.WrapE(err, "close batch")
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, errors.WrapE(err, "commit transaction")
+	}
+	return total, nil
+}
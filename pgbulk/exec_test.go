@@ -0,0 +1,101 @@
+package pgbulk_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+)
+
+func TestExec(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_bulk_exec", `
+		CREATE TABLE test_bulk_exec (
+			id INT PRIMARY KEY,
+			score INT,
+			grade TEXT
+		)
+	`)
+	defer cleanup()
+
+	if _, err := conn.Exec(ctx, `INSERT INTO test_bulk_exec (id, score)
+		SELECT g, g * 10 FROM generate_series(1, 100) g`); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	t.Run("Custom Update", func(t *testing.T) {
+		sqlTemplate := `UPDATE test_bulk_exec
+			SET grade = CASE WHEN score >= $1 THEN 'high' ELSE 'low' END
+			WHERE id BETWEEN $2 AND $3`
+		var paramSets [][]interface{}
+		for start := 1; start <= 100; start += 10 {
+			paramSets = append(paramSets, []interface{}{500, start, start + 9})
+		}
+
+		affected, err := pgbulk.Exec(ctx, conn, sqlTemplate, paramSets)
+		if err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+		if affected != 100 {
+			t.Errorf("Expected 100 rows affected, got %d", affected)
+		}
+
+		var high int
+		if err := conn.QueryRow(ctx, "SELECT count(*) FROM test_bulk_exec WHERE grade = 'high'").Scan(&high); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if high != 51 {
+			t.Errorf("Expected 51 high grades, got %d", high)
+		}
+	})
+
+	t.Run("Rollback On Error", func(t *testing.T) {
+		paramSets := [][]interface{}{
+			{1},
+			{"not-an-int"},
+		}
+		if _, err := pgbulk.Exec(ctx, conn, "DELETE FROM test_bulk_exec WHERE id = $1", paramSets); err == nil {
+			t.Fatal("Expected error for invalid parameter")
+		}
+
+		var count int
+		if err := conn.QueryRow(ctx, "SELECT count(*) FROM test_bulk_exec").Scan(&count); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if count != 100 {
+			t.Errorf("Expected rollback to keep 100 rows, got %d", count)
+		}
+	})
+
+	t.Run("No Fixed Timeout", func(t *testing.T) {
+		paramSets := [][]interface{}{{3}, {3}}
+		if _, err := pgbulk.Exec(ctx, conn, "SELECT pg_sleep($1)", paramSets); err != nil {
+			t.Fatalf("Exec of a batch longer than 5s failed: %v", err)
+		}
+	})
+
+	t.Run("Cancelled Context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := pgbulk.Exec(ctx, conn, "DELETE FROM test_bulk_exec WHERE id = $1", [][]interface{}{{1}}); err == nil {
+			t.Fatal("Expected error for cancelled context")
+		}
+
+		var count int
+		if err := conn.QueryRow(context.Background(), "SELECT count(*) FROM test_bulk_exec").Scan(&count); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if count != 100 {
+			t.Errorf("Expected 100 rows, got %d", count)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		affected, err := pgbulk.Exec(ctx, conn, "DELETE FROM test_bulk_exec WHERE id = $1", nil)
+		if err != nil || affected != 0 {
+			t.Errorf("Expected (0, nil), got (%d, %v)", affected, err)
+		}
+	})
+}
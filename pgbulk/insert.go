@@ -43,7 +43,7 @@ func Insert(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, onConflict
 	// Prepare parameters
 	var args []interface{}
 	for _, row := range data {
-		args = append(args, row...)
+		args = appendArgs(args, row)
 	}
 
 	// Execute SQL statement
@@ -0,0 +1,121 @@
+package pgbulk
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// InsertIgnoreConflicts inserts data into table with ON CONFLICT DO NOTHING and
+// reports how many rows were actually inserted and how many were skipped as
+// conflicts (len(data) - inserted). Rows are sent in multi-row INSERT statements
+// sized to stay within the bind parameter limit, all inside one transaction.
+// Parameters:
+//   - table: table name, optionally schema-qualified
+//   - columns: target columns; every row in data must have the same length
+//   - conflictColumns: conflict target; if empty, any unique violation is skipped
+//   - opts: WithIsolationLevel and WithMaxBatchBytes are honored
+func InsertIgnoreConflicts(conn *pgx.Conn, table string, columns, conflictColumns []string, data [][]interface{}, opts ...Option) (inserted, skipped int, err error) {
+	if len(data) == 0 {
+		return 0, 0, nil
+	}
+	tableIdent, err := parseTableName(table)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(columns) == 0 {
+		return 0, 0, errors.E("no columns specified", "table", table)
+	}
+	cols, err := sanitizeColumns(columns)
+	if err != nil {
+		return 0, 0, err
+	}
+	conflictTarget := ""
+	if len(conflictColumns) > 0 {
+		targets, err := sanitizeColumns(conflictColumns)
+		if err != nil {
+			return 0, 0, err
+		}
+		conflictTarget = "(" + strings.Join(targets, ",") + ") "
+	}
+	for i, row := range data {
+		if len(row) != len(columns) {
+			return 0, 0, errors.E("row length does not match columns",
+				"row", i, "row-length", len(row), "num-columns", len(columns))
+		}
+	}
+
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ",
+		tableIdent.Sanitize(), strings.Join(cols, ","))
+	suffix := " ON CONFLICT " + conflictTarget + "DO NOTHING RETURNING 1"
+	o := applyOptions(opts)
+
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, o.txOptions())
+	if err != nil {
+		return 0, 0, errors.WrapE(err, "begin transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	start := 0
+	for _, end := range batchEnds(data, len(columns), o.maxBatchBytes) {
+		fullSQL := prefix + valuesPlaceholders(end-start, len(columns)) + suffix
+
+		var args []interface{}
+		for _, row := range data[start:end] {
+			args = appendArgs(args, row)
+		}
+
+		rows, err := tx.Query(ctx, fullSQL, args...)
+		if err != nil {
+			return 0, 0, errors.WrapE(err, "insert ignoring conflicts", "table", table, "batch-start", start)
+		}
+		for rows.Next() {
+			inserted++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, 0, errors.WrapE(err, "insert ignoring conflicts", "table", table, "batch-start", start)
+		}
+		start = end
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, errors.WrapE(err, "commit transaction")
+	}
+	return inserted, len(data) - inserted, nil
+}
+
+// sanitizeColumns validates plain column identifiers and returns them quoted.
+func sanitizeColumns(columns []string) ([]string, error) {
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		if !identifierRe.MatchString(c) {
+			return nil, errors.E("invalid column identifier", "column", c)
+		}
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return quoted, nil
+}
+
+// valuesPlaceholders builds "($1,$2),($3,$4),..." for numRows rows of numCols values.
+func valuesPlaceholders(numRows, numCols int) string {
+	var sb strings.Builder
+	for i := 0; i < numRows; i++ {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteByte('(')
+		for j := 0; j < numCols; j++ {
+			if j > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, "$%d", i*numCols+j+1)
+		}
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
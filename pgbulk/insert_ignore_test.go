@@ -0,0 +1,104 @@
+package pgbulk_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+)
+
+func TestInsertIgnoreConflicts(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_insert_ignore", `
+		CREATE TABLE test_insert_ignore (
+			event_id TEXT PRIMARY KEY,
+			payload TEXT
+		)
+	`)
+	defer cleanup()
+
+	columns := []string{"event_id", "payload"}
+	conflict := []string{"event_id"}
+
+	t.Run("Duplicates Skipped", func(t *testing.T) {
+		inserted, skipped, err := pgbulk.InsertIgnoreConflicts(conn, "test_insert_ignore", columns, conflict, [][]interface{}{
+			{"e1", "a"},
+			{"e2", "b"},
+		})
+		if err != nil {
+			t.Fatalf("First insert failed: %v", err)
+		}
+		if inserted != 2 || skipped != 0 {
+			t.Errorf("Expected 2 inserted, 0 skipped, got %d, %d", inserted, skipped)
+		}
+
+		// e1 already stored, e3 duplicated within the same call
+		inserted, skipped, err = pgbulk.InsertIgnoreConflicts(conn, "test_insert_ignore", columns, conflict, [][]interface{}{
+			{"e1", "a again"},
+			{"e3", "c"},
+			{"e3", "c again"},
+		})
+		if err != nil {
+			t.Fatalf("Second insert failed: %v", err)
+		}
+		if inserted != 1 || skipped != 2 {
+			t.Errorf("Expected 1 inserted, 2 skipped, got %d, %d", inserted, skipped)
+		}
+
+		var payload string
+		if err := conn.QueryRow(ctx, "SELECT payload FROM test_insert_ignore WHERE event_id = 'e1'").Scan(&payload); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if payload != "a" {
+			t.Errorf("Expected existing row unchanged, got payload %q", payload)
+		}
+	})
+
+	t.Run("Multiple Batches", func(t *testing.T) {
+		// 40000 rows x 2 columns exceeds the bind parameter limit
+		var data [][]interface{}
+		for i := 0; i < 40000; i++ {
+			data = append(data, []interface{}{fmt.Sprintf("bulk-%d", i%30000), "x"})
+		}
+		inserted, skipped, err := pgbulk.InsertIgnoreConflicts(conn, "test_insert_ignore", columns, conflict, data)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		if inserted != 30000 || skipped != 10000 {
+			t.Errorf("Expected 30000 inserted, 10000 skipped, got %d, %d", inserted, skipped)
+		}
+	})
+
+	t.Run("Max Batch Bytes", func(t *testing.T) {
+		// 50 rows of 100 KB text split into statements of about 1 MB
+		large := strings.Repeat("y", 100*1024)
+		var data [][]interface{}
+		for i := 0; i < 50; i++ {
+			data = append(data, []interface{}{fmt.Sprintf("large-%d", i), large})
+		}
+		opt := pgbulk.WithMaxBatchBytes(1 << 20)
+		if n := pgbulk.CountDataBatches(data, opt); n != 5 {
+			t.Errorf("Expected 5 batches, got %d", n)
+		}
+		inserted, skipped, err := pgbulk.InsertIgnoreConflicts(conn, "test_insert_ignore", columns, conflict, data, opt)
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+		if inserted != 50 || skipped != 0 {
+			t.Errorf("Expected 50 inserted, 0 skipped, got %d, %d", inserted, skipped)
+		}
+	})
+
+	t.Run("Invalid Input", func(t *testing.T) {
+		if _, _, err := pgbulk.InsertIgnoreConflicts(conn, "test_insert_ignore", columns, conflict, [][]interface{}{{"only-one"}}); err == nil {
+			t.Error("Expected error for row length mismatch")
+		}
+		if _, _, err := pgbulk.InsertIgnoreConflicts(conn, "test_insert_ignore", []string{"event_id; DROP"}, nil, [][]interface{}{{"x"}}); err == nil {
+			t.Error("Expected error for invalid column identifier")
+		}
+	})
+}
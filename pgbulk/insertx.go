@@ -28,36 +28,51 @@ func InsertReturningID(conn *pgx.Conn, sqlTemplate string, data [][]interface{},
 		onConflict = returningColumnAndOnConflict[1]
 	}
 
-	// Build VALUES part
-	var valuePlaceholders []string
-	for i := range data {
-		var placeholders []string
-		for j := 0; j < len(data[i]); j++ {
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i*len(data[i])+j+1))
-		}
-		valuePlaceholders = append(valuePlaceholders, "("+strings.Join(placeholders, ",")+")")
+	fullSQL, args := buildInsertReturning(sqlTemplate, data, returning, onConflict)
+
+	// Execute SQL statement and retrieve returned IDs
+	rows, err := conn.Query(context.Background(), fullSQL, args...)
+	if err != nil {
+		return nil, errors.WrapE(err, "insert", "full-sql", fullSQL)
 	}
-	valuesClause := strings.Join(valuePlaceholders, ",")
+	return scanIDs(rows)
+}
 
-	// Build complete SQL statement
-	var fullSQL string
-	if onConflict != "" {
-		fullSQL = fmt.Sprintf("%s VALUES %s %s RETURNING %s", sqlTemplate, valuesClause, onConflict, returning)
-	} else {
-		fullSQL = fmt.Sprintf("%s VALUES %s RETURNING %s", sqlTemplate, valuesClause, returning)
+// InsertReturningIDOpts is InsertReturningID taking options: it inserts data
+// in a transaction and returns the values of the returning column (e.g. "id")
+// of the inserted rows. An ON CONFLICT clause is given with WithOnConflict.
+// Honors WithIsolationLevel and WithOnConflict.
+func InsertReturningIDOpts(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, returning string, opts ...Option) ([]int, error) {
+	if strings.TrimSpace(returning) == "" {
+		return nil, errors.E("no returning column specified", "sql-template", sqlTemplate)
 	}
+	o := applyOptions(opts)
+	fullSQL, args := buildInsertReturning(sqlTemplate, data, returning, o.onConflict)
 
-	// Prepare parameters
-	var args []interface{}
-	for _, row := range data {
-		args = append(args, row...)
+	ctx := context.Background()
+	tx, err := conn.BeginTx(ctx, o.txOptions())
+	if err != nil {
+		return nil, errors.WrapE(err, "start transaction")
 	}
+	defer tx.Rollback(ctx)
 
-	// Execute SQL statement and retrieve returned IDs
-	rows, err := conn.Query(context.Background(), fullSQL, args...)
+	rows, err := tx.Query(ctx, fullSQL, args...)
 	if err != nil {
 		return nil, errors.WrapE(err, "insert", "full-sql", fullSQL)
 	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, errors.WrapE(err, "commit transaction")
+	}
+	return ids, nil
+}
+
+// scanIDs reads the single integer column of rows and closes them.
+func scanIDs(rows pgx.Rows) ([]int, error) {
 	defer rows.Close()
 
 	var ids []int
@@ -75,3 +90,73 @@ func InsertReturningID(conn *pgx.Conn, sqlTemplate string, data [][]interface{},
 
 	return ids, nil
 }
+
+// InsertReturning inserts data and returns the values of the returning columns
+// for each inserted row, one slice per row in column order. Use it for
+// composite, UUID or other non-serial keys; values keep the types pgx decodes
+// them to (e.g. [16]byte for uuid, string for text, int64 for bigint).
+// Parameters:
+//   - returning: comma-separated returning columns, e.g. "tenant_id, id"
+//   - onConflict: optional ON CONFLICT clause; rows skipped by it are not returned
+func InsertReturning(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, returning string, onConflict ...string) ([][]interface{}, error) {
+	if strings.TrimSpace(returning) == "" {
+		return nil, errors.E("no returning columns specified", "sql-template", sqlTemplate)
+	}
+	conflictClause := ""
+	if len(onConflict) > 0 {
+		conflictClause = onConflict[0]
+	}
+
+	fullSQL, args := buildInsertReturning(sqlTemplate, data, returning, conflictClause)
+
+	rows, err := conn.Query(context.Background(), fullSQL, args...)
+	if err != nil {
+		return nil, errors.WrapE(err, "insert", "full-sql", fullSQL)
+	}
+	defer rows.Close()
+
+	var result [][]interface{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, errors.WrapE(err, "rows.Values()")
+		}
+		result = append(result, values)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.WrapE(err, "rows.Next()")
+	}
+
+	return result, nil
+}
+
+// buildInsertReturning builds a multi-row INSERT ... RETURNING statement and its
+// arguments from sqlTemplate and data.
+func buildInsertReturning(sqlTemplate string, data [][]interface{}, returning, onConflict string) (string, []interface{}) {
+	// Build VALUES part
+	var valuePlaceholders []string
+	for i := range data {
+		var placeholders []string
+		for j := 0; j < len(data[i]); j++ {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", i*len(data[i])+j+1))
+		}
+		valuePlaceholders = append(valuePlaceholders, "("+strings.Join(placeholders, ",")+")")
+	}
+	valuesClause := strings.Join(valuePlaceholders, ",")
+
+	// Build complete SQL statement
+	var fullSQL string
+	if onConflict != "" {
+		fullSQL = fmt.Sprintf("%s VALUES %s %s RETURNING %s", sqlTemplate, valuesClause, onConflict, returning)
+	} else {
+		fullSQL = fmt.Sprintf("%s VALUES %s RETURNING %s", sqlTemplate, valuesClause, returning)
+	}
+
+	// Prepare parameters
+	var args []interface{}
+	for _, row := range data {
+		args = appendArgs(args, row)
+	}
+	return fullSQL, args
+}
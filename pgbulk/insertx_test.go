@@ -132,3 +132,66 @@ func TestInsertReturningIDWithOnConflict(t *testing.T) {
 		t.Errorf("Expected alice's updated name to be 'Alice Updated Again', got '%s'", aliceName)
 	}
 }
+
+func TestInsertReturning(t *testing.T) {
+	conn := getTestConn(t)
+
+	t.Run("UUID Key", func(t *testing.T) {
+		cleanup := setupTestTable(t, conn, "test_returning_uuid", `
+			CREATE TABLE test_returning_uuid (
+				id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+				name TEXT
+			)
+		`)
+		defer cleanup()
+
+		rows, err := pgbulk.InsertReturning(conn, "INSERT INTO test_returning_uuid (name)",
+			[][]interface{}{{"a"}, {"b"}}, "id, name")
+		if err != nil {
+			t.Fatalf("InsertReturning failed: %v", err)
+		}
+		if len(rows) != 2 {
+			t.Fatalf("Expected 2 rows, got %d", len(rows))
+		}
+		for i, want := range []string{"a", "b"} {
+			if _, ok := rows[i][0].([16]byte); !ok {
+				t.Errorf("Row %d: expected uuid as [16]byte, got %T", i, rows[i][0])
+			}
+			if rows[i][1] != want {
+				t.Errorf("Row %d: expected name %q, got %v", i, want, rows[i][1])
+			}
+		}
+	})
+
+	t.Run("Composite Key With Conflict", func(t *testing.T) {
+		cleanup := setupTestTable(t, conn, "test_returning_composite", `
+			CREATE TABLE test_returning_composite (
+				tenant TEXT,
+				seq INT,
+				value TEXT,
+				PRIMARY KEY (tenant, seq)
+			)
+		`)
+		defer cleanup()
+
+		sqlTemplate := "INSERT INTO test_returning_composite (tenant, seq, value)"
+		if _, err := pgbulk.InsertReturning(conn, sqlTemplate, [][]interface{}{{"t1", 1, "x"}}, "tenant, seq"); err != nil {
+			t.Fatalf("First insert failed: %v", err)
+		}
+
+		rows, err := pgbulk.InsertReturning(conn, sqlTemplate,
+			[][]interface{}{{"t1", 1, "dup"}, {"t1", 2, "y"}}, "tenant, seq", "ON CONFLICT DO NOTHING")
+		if err != nil {
+			t.Fatalf("Insert with conflict failed: %v", err)
+		}
+		if len(rows) != 1 || rows[0][0] != "t1" || rows[0][1] != int32(2) {
+			t.Errorf("Expected [[t1 2]], got %v", rows)
+		}
+	})
+
+	t.Run("Empty Returning", func(t *testing.T) {
+		if _, err := pgbulk.InsertReturning(conn, "INSERT INTO t (a)", [][]interface{}{{1}}, " "); err == nil {
+			t.Error("Expected error for empty returning clause")
+		}
+	})
+}
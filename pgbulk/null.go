@@ -0,0 +1,36 @@
+package pgbulk
+
+import "database/sql/driver"
+
+// Null is an explicit SQL NULL for use in bulk data. An untyped Go nil in
+// data[i][j] already maps to NULL for every column type in Insert,
+// InsertReturningID, Update and Copy; Null is provided for callers that want the
+// intent spelled out, or that build rows through code where a bare nil would be
+// ambiguous (e.g. a nil map, which a jsonb column may encode as the JSON value
+// null rather than SQL NULL). The builders replace Null with nil before sending.
+var Null driver.Valuer = null{}
+
+type null struct{}
+
+// Value implements driver.Valuer, so Null also works when passed to pgx directly.
+func (null) Value() (driver.Value, error) { return nil, nil }
+
+// isNull reports whether v is nil or Null.
+func isNull(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	_, ok := v.(null)
+	return ok
+}
+
+// appendArgs appends row to args, translating Null to nil.
+func appendArgs(args []interface{}, row []interface{}) []interface{} {
+	for _, v := range row {
+		if isNull(v) {
+			v = nil
+		}
+		args = append(args, v)
+	}
+	return args
+}
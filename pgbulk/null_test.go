@@ -0,0 +1,79 @@
+package pgbulk_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNullValues(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_nulls", `
+		CREATE TABLE test_nulls (
+			id SERIAL PRIMARY KEY,
+			label TEXT NOT NULL,
+			txt TEXT,
+			num INT,
+			doc JSONB
+		)
+	`)
+	defer cleanup()
+
+	sqlTemplate := "INSERT INTO test_nulls (label, txt, num, doc)"
+	data := func(label string) [][]interface{} {
+		return [][]interface{}{
+			{label + "-nil", nil, nil, nil},
+			{label + "-null", pgbulk.Null, pgbulk.Null, pgbulk.Null},
+			{label + "-value", "x", 1, map[string]interface{}{"k": "v"}},
+		}
+	}
+
+	require.NoError(t, pgbulk.Insert(conn, sqlTemplate, data("insert")))
+	_, err := pgbulk.InsertReturningID(conn, sqlTemplate, data("returning"))
+	require.NoError(t, err)
+	n, err := pgbulk.Copy(conn, sqlTemplate, data("copy"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	for _, prefix := range []string{"insert", "returning", "copy"} {
+		for _, suffix := range []string{"-nil", "-null"} {
+			var txt sql.NullString
+			var num sql.NullInt64
+			var doc []byte
+			err := conn.QueryRow(ctx,
+				"SELECT txt, num, doc FROM test_nulls WHERE label = $1", prefix+suffix).Scan(&txt, &num, &doc)
+			require.NoError(t, err, prefix+suffix)
+			assert.False(t, txt.Valid, prefix+suffix)
+			assert.False(t, num.Valid, prefix+suffix)
+			assert.Nil(t, doc, prefix+suffix)
+		}
+	}
+
+	t.Run("update", func(t *testing.T) {
+		failed, err := pgbulk.Update(conn,
+			"UPDATE test_nulls SET txt = $1, num = $2, doc = $3 WHERE label = $4",
+			[][]interface{}{{pgbulk.Null, nil, pgbulk.Null}},
+			[][]interface{}{{"insert-value"}})
+		require.NoError(t, err)
+		assert.Empty(t, failed)
+
+		var nulls int
+		err = conn.QueryRow(ctx, `SELECT COUNT(*) FROM test_nulls
+			WHERE label = 'insert-value' AND txt IS NULL AND num IS NULL AND doc IS NULL`).Scan(&nulls)
+		require.NoError(t, err)
+		assert.Equal(t, 1, nulls)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		columns := []string{"label", "txt", "num", "doc"}
+		assert.NoError(t, pgbulk.ValidateData(conn, "test_nulls", columns, data("validate")))
+		err := pgbulk.ValidateData(conn, "test_nulls", columns, [][]interface{}{{pgbulk.Null, nil, nil, nil}})
+		assert.ErrorContains(t, err, "NOT NULL")
+	})
+}
@@ -0,0 +1,114 @@
+package pgbulk
+
+import (
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// Option configures a bulk operation. Functions document which options they honor.
+type Option func(*options)
+
+// options holds settings applied by Option values.
+type options struct {
+	continueOnError bool
+	isoLevel        pgx.TxIsoLevel
+	maxBatchBytes   int
+	where           string          // Extra Update condition; $n refer to whereArgs[i]
+	whereArgs       [][]interface{} // Parameters of where, one row per data row
+	rowsAffected    *[]int64        // Receives Update's per-row affected counts
+	onConflict      string          // ON CONFLICT clause of InsertReturningIDOpts
+}
+
+// WithContinueOnError makes Update run every statement on its own, outside a
+// transaction, instead of stopping at the first failure. Each statement commits
+// independently; all failures are collected. Honored by Update.
+func WithContinueOnError() Option {
+	return func(o *options) {
+		o.continueOnError = true
+	}
+}
+
+// WithIsolationLevel runs the operation's transaction at the given isolation
+// level (e.g. pgx.Serializable, pgx.ReadCommitted) instead of the connection
+// default. Honored by Update (except with WithContinueOnError, which uses no
+// transaction), Exec, InsertIgnoreConflicts and InsertReturningIDOpts. Under pgx.Serializable or
+// pgx.RepeatableRead, concurrent writers can fail with a serialization error;
+// detect it with IsSerializationFailure and retry the whole operation.
+func WithIsolationLevel(level pgx.TxIsoLevel) Option {
+	return func(o *options) {
+		o.isoLevel = level
+	}
+}
+
+// WithOnConflict adds an ON CONFLICT clause after the VALUES list, e.g.
+// "ON CONFLICT (code) DO NOTHING"; rows skipped by it return no id. Honored by
+// InsertReturningIDOpts.
+func WithOnConflict(clause string) Option {
+	return func(o *options) {
+		o.onConflict = clause
+	}
+}
+
+// WithMaxBatchBytes closes a multi-row statement once its estimated size
+// reaches n bytes, in addition to the bind parameter limit, so rows with large
+// text or bytea values do not build multi-megabyte statements. The size of a
+// row is estimated conservatively from its values (see CountDataBatches); a
+// single row larger than n still goes alone in its own statement. A
+// non-positive n means no byte limit. Honored by InsertIgnoreConflicts,
+// InsertSavepoint and CountDataBatches.
+func WithMaxBatchBytes(n int) Option {
+	return func(o *options) {
+		o.maxBatchBytes = n
+	}
+}
+
+// WithWhere adds a per-row guard to Update, e.g. for optimistic concurrency:
+// condition is appended to sqlTemplate as " AND (condition)", so the template
+// must end with its WHERE clause. The placeholders $1, $2, ... of condition
+// refer to args[i] for row i and are renumbered to follow that row's data and
+// id parameters. args must have one row per data row. A row whose guard fails
+// is not updated and is not an error; detect it with WithRowsAffected.
+//
+//	pgbulk.Update(conn, "UPDATE doc SET body = $1, version = version + 1 WHERE id = $2",
+//	    data, ids, pgbulk.WithWhere("version = $1", versions), pgbulk.WithRowsAffected(&counts))
+func WithWhere(condition string, args [][]interface{}) Option {
+	return func(o *options) {
+		o.where = condition
+		o.whereArgs = args
+	}
+}
+
+// WithRowsAffected stores in *counts the number of rows each Update statement
+// changed, indexed like data. Failed statements count 0, and so do all of
+// them when Update's transaction is rolled back. Honored by Update.
+func WithRowsAffected(counts *[]int64) Option {
+	return func(o *options) {
+		o.rowsAffected = counts
+	}
+}
+
+// txOptions returns the transaction options for the settings.
+func (o options) txOptions() pgx.TxOptions {
+	return pgx.TxOptions{IsoLevel: o.isoLevel}
+}
+
+// IsSerializationFailure reports whether err, or an error it wraps, is a
+// PostgreSQL serialization failure (SQLSTATE 40001) or deadlock (40P01).
+// Both abort the transaction and are resolved by retrying it.
+func IsSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+// applyOptions returns the settings resulting from opts.
+func applyOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
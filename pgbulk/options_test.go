@@ -0,0 +1,152 @@
+package pgbulk_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/pgbulk"
+)
+
+func TestWithIsolationLevel(t *testing.T) {
+	conn := getTestConn(t)
+	other := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_isolation", `
+		CREATE TABLE test_isolation (
+			id INT PRIMARY KEY,
+			value INT
+		)
+	`)
+	defer cleanup()
+	if _, err := conn.Exec(ctx, "INSERT INTO test_isolation VALUES (1, 0)"); err != nil {
+		t.Fatalf("Seed failed: %v", err)
+	}
+
+	// updateDuringConcurrentWrite runs Update while another transaction holds
+	// the row and then commits its own change to it.
+	updateDuringConcurrentWrite := func(opts ...pgbulk.Option) error {
+		tx, err := other.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		defer tx.Rollback(ctx)
+		if _, err := tx.Exec(ctx, "UPDATE test_isolation SET value = value + 1 WHERE id = 1"); err != nil {
+			t.Fatalf("Concurrent update failed: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := pgbulk.Update(conn, "UPDATE test_isolation SET value = $1 WHERE id = $2",
+				[][]interface{}{{100}}, [][]interface{}{{1}}, opts...)
+			done <- err
+		}()
+		time.Sleep(200 * time.Millisecond) // Let Update block on the row lock
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		return <-done
+	}
+
+	t.Run("Serializable Conflict", func(t *testing.T) {
+		err := updateDuringConcurrentWrite(pgbulk.WithIsolationLevel(pgx.Serializable))
+		if err == nil {
+			t.Fatal("Expected serialization failure under SERIALIZABLE")
+		}
+		if !pgbulk.IsSerializationFailure(err) {
+			t.Errorf("Expected IsSerializationFailure, got %v", err)
+		}
+	})
+
+	t.Run("Read Committed", func(t *testing.T) {
+		err := updateDuringConcurrentWrite(pgbulk.WithIsolationLevel(pgx.ReadCommitted))
+		if err != nil {
+			t.Fatalf("Expected success under READ COMMITTED, got %v", err)
+		}
+	})
+
+	t.Run("IsSerializationFailure", func(t *testing.T) {
+		if pgbulk.IsSerializationFailure(nil) || pgbulk.IsSerializationFailure(errors.New("40001")) {
+			t.Error("Expected false for non-PostgreSQL errors")
+		}
+	})
+}
+
+func TestInsertReturningIDOpts(t *testing.T) {
+	conn := getTestConn(t)
+	other := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_isolation_insert", `
+		CREATE TABLE test_isolation_insert (
+			id SERIAL PRIMARY KEY,
+			code TEXT UNIQUE
+		)
+	`)
+	defer cleanup()
+
+	// insertDuringConcurrentInsert runs InsertReturningIDOpts while another
+	// transaction has inserted the same code and then commits it.
+	insertDuringConcurrentInsert := func(code string, opts ...pgbulk.Option) ([]int, error) {
+		tx, err := other.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		defer tx.Rollback(ctx)
+		if _, err := tx.Exec(ctx, "INSERT INTO test_isolation_insert (code) VALUES ($1)", code); err != nil {
+			t.Fatalf("Concurrent insert failed: %v", err)
+		}
+
+		type result struct {
+			ids []int
+			err error
+		}
+		done := make(chan result, 1)
+		go func() {
+			opts = append(opts, pgbulk.WithOnConflict("ON CONFLICT (code) DO NOTHING"))
+			ids, err := pgbulk.InsertReturningIDOpts(conn, "INSERT INTO test_isolation_insert (code)",
+				[][]interface{}{{code}}, "id", opts...)
+			done <- result{ids, err}
+		}()
+		time.Sleep(200 * time.Millisecond) // Let the insert block on the unique index
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatalf("Commit failed: %v", err)
+		}
+		r := <-done
+		return r.ids, r.err
+	}
+
+	t.Run("Serializable Conflict", func(t *testing.T) {
+		_, err := insertDuringConcurrentInsert("a", pgbulk.WithIsolationLevel(pgx.Serializable))
+		if err == nil {
+			t.Fatal("Expected serialization failure under SERIALIZABLE")
+		}
+		if !pgbulk.IsSerializationFailure(err) {
+			t.Errorf("Expected IsSerializationFailure, got %v", err)
+		}
+	})
+
+	t.Run("Read Committed", func(t *testing.T) {
+		ids, err := insertDuringConcurrentInsert("b", pgbulk.WithIsolationLevel(pgx.ReadCommitted))
+		if err != nil {
+			t.Fatalf("Expected success under READ COMMITTED, got %v", err)
+		}
+		if len(ids) != 0 {
+			t.Errorf("Expected the conflicting row to be skipped, got ids %v", ids)
+		}
+	})
+
+	t.Run("Returns IDs", func(t *testing.T) {
+		ids, err := pgbulk.InsertReturningIDOpts(conn, "INSERT INTO test_isolation_insert (code)",
+			[][]interface{}{{"c"}, {"d"}}, "id", pgbulk.WithIsolationLevel(pgx.Serializable))
+		if err != nil {
+			t.Fatalf("InsertReturningIDOpts failed: %v", err)
+		}
+		if len(ids) != 2 {
+			t.Errorf("Expected 2 IDs, got %d", len(ids))
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package pgbulk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaichao/gopkg/errors"
+)
+
+// BulkPlan describes how a multi-row insert splits data into statements,
+// computed by PlanBulkInsert without executing anything.
+type BulkPlan struct {
+	Table        string   // Table from the template, as written (may be schema-qualified)
+	Columns      []string // Columns from the template
+	TotalRows    int      // Rows in data
+	ParamsPerRow int      // Bind parameters per row (= len(Columns))
+	TotalParams  int      // TotalRows * ParamsPerRow
+	MaxBatchRows int      // Rows per statement allowed by the bind parameter limit
+	BatchRows    []int    // Rows in each statement, in execution order
+	Statements   int      // Number of INSERT statements (= len(BatchRows))
+}
+
+// String summarizes the plan on one line, for logging before a large load.
+func (p BulkPlan) String() string {
+	largest := 0
+	for _, n := range p.BatchRows {
+		largest = max(largest, n)
+	}
+	return fmt.Sprintf("insert into %s: %d rows x %d params = %d params in %d statement(s), up to %d rows each (limit %d)",
+		p.Table, p.TotalRows, p.ParamsPerRow, p.TotalParams, p.Statements, largest, p.MaxBatchRows)
+}
+
+// PlanBulkInsert returns the statements a chunking insert of data with
+// sqlTemplate would execute, as InsertSavepoint splits them: at most
+// 65535/ParamsPerRow rows each and, with WithMaxBatchBytes, at most that many
+// estimated bytes (see CountDataBatches). It validates the template like the
+// Copy-based functions and checks that every row has one value per column,
+// but touches no database. Empty data gives a plan without statements.
+// Parameters:
+//   - sqlTemplate: "INSERT INTO table (col1, col2)"
+//   - opts: WithMaxBatchBytes is honored
+func PlanBulkInsert(sqlTemplate string, data [][]interface{}, opts ...Option) (BulkPlan, error) {
+	table, columns, err := parseSQLTemplate(sqlTemplate)
+	if err != nil {
+		return BulkPlan{}, err
+	}
+	numCols := len(columns)
+	for i, row := range data {
+		if len(row) != numCols {
+			return BulkPlan{}, errors.E("row length does not match the template columns",
+				"row", i, "row-length", len(row), "expected", numCols)
+		}
+	}
+
+	plan := BulkPlan{
+		Table:        strings.Join(table, "."),
+		Columns:      columns,
+		TotalRows:    len(data),
+		ParamsPerRow: numCols,
+		TotalParams:  len(data) * numCols,
+		MaxBatchRows: rowsPerBatch(numCols),
+		BatchRows:    []int{},
+	}
+	start := 0
+	for _, end := range batchEnds(data, numCols, applyOptions(opts).maxBatchBytes) {
+		plan.BatchRows = append(plan.BatchRows, end-start)
+		start = end
+	}
+	plan.Statements = len(plan.BatchRows)
+	return plan, nil
+}
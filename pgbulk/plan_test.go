@@ -0,0 +1,69 @@
+package pgbulk_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanBulkInsert(t *testing.T) {
+	rows := func(n int) [][]interface{} {
+		data := make([][]interface{}, n)
+		for i := range data {
+			data[i] = []interface{}{i, "name", true}
+		}
+		return data
+	}
+	const tmpl = "INSERT INTO app.users (id, name, active)"
+
+	// Below the parameter limit: a single statement
+	plan, err := pgbulk.PlanBulkInsert(tmpl, rows(1000))
+	assert.NoError(t, err)
+	assert.Equal(t, pgbulk.BulkPlan{
+		Table:        "app.users",
+		Columns:      []string{"id", "name", "active"},
+		TotalRows:    1000,
+		ParamsPerRow: 3,
+		TotalParams:  3000,
+		MaxBatchRows: 21845,
+		BatchRows:    []int{1000},
+		Statements:   1,
+	}, plan)
+
+	// Crossing the limit: full statements of 21845 rows, then the rest
+	plan, err = pgbulk.PlanBulkInsert(tmpl, rows(50000))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{21845, 21845, 6310}, plan.BatchRows)
+	assert.Equal(t, 3, plan.Statements)
+	assert.Equal(t, pgbulk.CountDataBatches(rows(50000)), plan.Statements)
+	assert.Equal(t, "insert into app.users: 50000 rows x 3 params = 150000 params in 3 statement(s), up to 21845 rows each (limit 21845)", plan.String())
+
+	// Exactly at the limit stays one statement
+	plan, err = pgbulk.PlanBulkInsert(tmpl, rows(21845))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{21845}, plan.BatchRows)
+
+	// The byte limit splits earlier
+	large := strings.Repeat("x", 1024)
+	data := make([][]interface{}, 10)
+	for i := range data {
+		data[i] = []interface{}{i, large, true}
+	}
+	plan, err = pgbulk.PlanBulkInsert(tmpl, data, pgbulk.WithMaxBatchBytes(4096))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 3, 3, 1}, plan.BatchRows)
+
+	// Empty data: no statements
+	plan, err = pgbulk.PlanBulkInsert(tmpl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, plan.Statements)
+	assert.Empty(t, plan.BatchRows)
+
+	// Invalid template and mismatched rows are rejected
+	_, err = pgbulk.PlanBulkInsert("INSERT INTO users (id; DROP TABLE x)", rows(1))
+	assert.Error(t, err)
+	_, err = pgbulk.PlanBulkInsert(tmpl, [][]interface{}{{1, "a", true}, {2, "b"}})
+	assert.Error(t, err)
+}
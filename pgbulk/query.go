@@ -0,0 +1,78 @@
+package pgbulk
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// QueryBatched runs query and streams its rows in chunks of batchSize (the
+// last one may be smaller), so large result sets are read without holding
+// them in memory. Rows are read incrementally from the connection as the
+// chunks are consumed; each row is its column values as from rows.Values.
+// The batch channel is closed when the rows are exhausted or on failure; the
+// error channel then yields at most one error and is closed. The caller must
+// drain the batch channel, or cancel ctx to stop early, and must not use conn
+// for anything else until the batch channel is closed. Cancelling ctx aborts
+// the query and yields ctx's error.
+func QueryBatched(ctx context.Context, conn *pgx.Conn, query string, batchSize int, args ...interface{}) (<-chan [][]interface{}, <-chan error) {
+	batches := make(chan [][]interface{}, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(batches)
+
+		if batchSize <= 0 {
+			errs <- errors.E("batchSize must be positive", "batch-size", batchSize)
+			return
+		}
+
+		rows, err := conn.Query(ctx, query, args...)
+		if err != nil {
+			errs <- errors.WrapE(err, "pgx query", "query", query)
+			return
+		}
+		defer rows.Close()
+
+		// send delivers batch unless ctx is done; a done ctx wins over a ready
+		// consumer, so a consumer that keeps draining still sees it stop.
+		send := func(batch [][]interface{}) bool {
+			if ctx.Err() == nil {
+				select {
+				case batches <- batch:
+					return true
+				case <-ctx.Done():
+				}
+			}
+			errs <- errors.WrapE(ctx.Err(), "query batches not consumed", "query", query)
+			return false
+		}
+
+		batch := make([][]interface{}, 0, batchSize)
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				errs <- errors.WrapE(err, "read row values", "query", query)
+				return
+			}
+			batch = append(batch, values)
+			if len(batch) == batchSize {
+				if !send(batch) {
+					return
+				}
+				batch = make([][]interface{}, 0, batchSize)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			errs <- errors.WrapE(err, "iterate rows", "query", query)
+			return
+		}
+		if len(batch) > 0 {
+			send(batch)
+		}
+	}()
+
+	return batches, errs
+}
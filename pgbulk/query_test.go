@@ -0,0 +1,75 @@
+package pgbulk_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryBatched(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_query_batched", `
+		CREATE TABLE test_query_batched (
+			id INT PRIMARY KEY,
+			name TEXT
+		)
+	`)
+	defer cleanup()
+
+	_, err := conn.Exec(ctx, `INSERT INTO test_query_batched
+		SELECT i, 'name' || i FROM generate_series(1, 10050) AS i`)
+	require.NoError(t, err)
+
+	batches, errs := pgbulk.QueryBatched(ctx, conn,
+		"SELECT id, name FROM test_query_batched WHERE id > $1 ORDER BY id", 1000, 0)
+	var sizes []int
+	next := int32(1)
+	for batch := range batches {
+		sizes = append(sizes, len(batch))
+		for _, row := range batch {
+			require.Len(t, row, 2)
+			assert.Equal(t, next, row[0])
+			next++
+		}
+	}
+	require.NoError(t, <-errs)
+	assert.Len(t, sizes, 11)
+	assert.Equal(t, 1000, sizes[0])
+	assert.Equal(t, 50, sizes[10])
+	assert.Equal(t, int32(10051), next)
+
+	t.Run("Query Error", func(t *testing.T) {
+		batches, errs := pgbulk.QueryBatched(ctx, conn, "SELECT * FROM no_such_table", 10)
+		for range batches {
+			t.Error("Expected no batches")
+		}
+		assert.Error(t, <-errs)
+	})
+
+	t.Run("Invalid Batch Size", func(t *testing.T) {
+		batches, errs := pgbulk.QueryBatched(ctx, conn, "SELECT 1", 0)
+		for range batches {
+		}
+		assert.Error(t, <-errs)
+	})
+
+	t.Run("Stop Early", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		batches, errs := pgbulk.QueryBatched(ctx, conn,
+			"SELECT id FROM test_query_batched ORDER BY id", 100)
+		<-batches
+		cancel()
+		for range batches {
+		}
+		assert.ErrorIs(t, <-errs, context.Canceled)
+
+		// The connection is usable again once the batch channel is closed.
+		var one int
+		require.NoError(t, conn.QueryRow(context.Background(), "SELECT 1").Scan(&one))
+	})
+}
@@ -0,0 +1,79 @@
+package pgbulk
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// InsertSavepoint inserts data inside the caller's transaction under a
+// savepoint named name, so a failure does not abort the outer transaction.
+// Rows are sent as multi-row "sqlTemplate VALUES ..." statements sized to stay
+// within the bind parameter limit. On error the work is rolled back to the
+// savepoint, which is then released, leaving tx usable, and the error is
+// returned. On success the savepoint is released and the rows remain part of
+// tx, to be committed or rolled back with it.
+// Parameters:
+//   - name: savepoint name, a plain identifier
+//   - sqlTemplate: "INSERT INTO table (col1, col2)"; every row must have one value per column
+//   - opts: WithMaxBatchBytes is honored
+func InsertSavepoint(tx pgx.Tx, name, sqlTemplate string, data [][]interface{}, opts ...Option) error {
+	if !identifierRe.MatchString(name) {
+		return errors.E("invalid savepoint name", "name", name)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	numCols := len(data[0])
+	if numCols == 0 {
+		return errors.E("empty row", "row", 0)
+	}
+	for i, row := range data {
+		if len(row) != numCols {
+			return errors.E("rows have different lengths", "row", i, "row-length", len(row), "expected", numCols)
+		}
+	}
+
+	ctx := context.Background()
+	savepoint := pgx.Identifier{name}.Sanitize()
+	if _, err := tx.Exec(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return errors.WrapE(err, "create savepoint", "name", name)
+	}
+
+	if err := insertChunks(ctx, tx, sqlTemplate, data, numCols, applyOptions(opts).maxBatchBytes); err != nil {
+		if _, rbErr := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+savepoint); rbErr != nil {
+			return errors.WrapE(err, "insert failed and rollback to savepoint failed",
+				"name", name, "rollback-error", rbErr.Error())
+		}
+		if _, relErr := tx.Exec(ctx, "RELEASE SAVEPOINT "+savepoint); relErr != nil {
+			return errors.WrapE(err, "insert failed and release savepoint failed",
+				"name", name, "release-error", relErr.Error())
+		}
+		return errors.WrapE(err, "insert rolled back to savepoint", "name", name)
+	}
+
+	if _, err := tx.Exec(ctx, "RELEASE SAVEPOINT "+savepoint); err != nil {
+		return errors.WrapE(err, "release savepoint", "name", name)
+	}
+	return nil
+}
+
+// insertChunks executes "sqlTemplate VALUES ..." for data in chunks that stay
+// within the bind parameter limit and, if positive, maxBytes.
+func insertChunks(ctx context.Context, tx pgx.Tx, sqlTemplate string, data [][]interface{}, numCols, maxBytes int) error {
+	start := 0
+	for _, end := range batchEnds(data, numCols, maxBytes) {
+		fullSQL := sqlTemplate + " VALUES " + valuesPlaceholders(end-start, numCols)
+
+		var args []interface{}
+		for _, row := range data[start:end] {
+			args = appendArgs(args, row)
+		}
+		if _, err := tx.Exec(ctx, fullSQL, args...); err != nil {
+			return errors.WrapE(err, "pgx insert", "sql-template", sqlTemplate, "batch-start", start)
+		}
+		start = end
+	}
+	return nil
+}
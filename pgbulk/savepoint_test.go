@@ -0,0 +1,73 @@
+package pgbulk_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+)
+
+func TestInsertSavepoint(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_savepoint", `
+		CREATE TABLE test_savepoint (
+			id INT PRIMARY KEY,
+			name TEXT
+		)
+	`)
+	defer cleanup()
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	sqlTemplate := "INSERT INTO test_savepoint (id, name)"
+	if err := pgbulk.InsertSavepoint(tx, "first", sqlTemplate, [][]interface{}{{1, "a"}, {2, "b"}}); err != nil {
+		t.Fatalf("First insert failed: %v", err)
+	}
+
+	// Duplicate key fails the whole bulk op, including the valid row 3
+	err = pgbulk.InsertSavepoint(tx, "second", sqlTemplate, [][]interface{}{{3, "c"}, {1, "dup"}})
+	if err == nil {
+		t.Fatal("Expected duplicate key error")
+	}
+
+	// The outer transaction is still usable
+	if _, err := tx.Exec(ctx, "INSERT INTO test_savepoint (id, name) VALUES (4, 'd')"); err != nil {
+		t.Fatalf("Outer transaction unusable after failed bulk op: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	var ids []int
+	rows, err := conn.Query(ctx, "SELECT id FROM test_savepoint ORDER BY id")
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 4 {
+		t.Errorf("Expected ids [1 2 4], got %v", ids)
+	}
+
+	t.Run("Invalid Savepoint Name", func(t *testing.T) {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			t.Fatalf("Begin failed: %v", err)
+		}
+		defer tx.Rollback(ctx)
+		if err := pgbulk.InsertSavepoint(tx, "bad; name", sqlTemplate, [][]interface{}{{9, "x"}}); err == nil {
+			t.Error("Expected error for invalid savepoint name")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package pgbulk
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// ColumnInfo describes one column of a table as reported by information_schema.
+type ColumnInfo struct {
+	Name     string  // Column name
+	DataType string  // SQL data type, e.g. "integer", "text", "ARRAY", "USER-DEFINED"
+	Nullable bool    // Whether the column accepts NULL
+	Default  *string // Default expression, nil if the column has none
+}
+
+// TableColumns returns the columns of table in ordinal order. The table name may
+// be schema-qualified ("schema.table"); otherwise the current schema is used.
+// An unknown table yields an error.
+func TableColumns(conn *pgx.Conn, table string) ([]ColumnInfo, error) {
+	ident, err := parseTableName(table)
+	if err != nil {
+		return nil, err
+	}
+	var schema *string
+	name := ident[0]
+	if len(ident) == 2 {
+		schema, name = &ident[0], ident[1]
+	}
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT column_name, data_type, is_nullable = 'YES', column_default
+		FROM information_schema.columns
+		WHERE table_schema = COALESCE($1, current_schema()) AND table_name = $2
+		ORDER BY ordinal_position`, schema, name)
+	if err != nil {
+		return nil, errors.WrapE(err, "query information_schema.columns", "table", table)
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var c ColumnInfo
+		if err := rows.Scan(&c.Name, &c.DataType, &c.Nullable, &c.Default); err != nil {
+			return nil, errors.WrapE(err, "rows.Scan()")
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.WrapE(err, "rows.Next()")
+	}
+	if len(columns) == 0 {
+		return nil, errors.E("table not found", "table", table)
+	}
+	return columns, nil
+}
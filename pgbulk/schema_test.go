@@ -0,0 +1,72 @@
+package pgbulk_test
+
+import (
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableColumns(t *testing.T) {
+	conn := getTestConn(t)
+
+	cleanup := setupTestTable(t, conn, "test_columns", `
+		CREATE TABLE test_columns (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			score NUMERIC DEFAULT 0,
+			tags TEXT[]
+		)
+	`)
+	defer cleanup()
+
+	for _, table := range []string{"test_columns", "public.test_columns"} {
+		t.Run(table, func(t *testing.T) {
+			columns, err := pgbulk.TableColumns(conn, table)
+			require.NoError(t, err)
+			require.Len(t, columns, 4)
+
+			names := []string{columns[0].Name, columns[1].Name, columns[2].Name, columns[3].Name}
+			assert.Equal(t, []string{"id", "name", "score", "tags"}, names)
+
+			assert.Equal(t, "integer", columns[0].DataType)
+			assert.False(t, columns[0].Nullable)
+			require.NotNil(t, columns[0].Default)
+			assert.Contains(t, *columns[0].Default, "nextval")
+
+			assert.Equal(t, "text", columns[1].DataType)
+			assert.False(t, columns[1].Nullable)
+			assert.Nil(t, columns[1].Default)
+
+			assert.Equal(t, "numeric", columns[2].DataType)
+			assert.True(t, columns[2].Nullable)
+			require.NotNil(t, columns[2].Default)
+			assert.Equal(t, "0", *columns[2].Default)
+
+			assert.Equal(t, "ARRAY", columns[3].DataType)
+		})
+	}
+
+	t.Run("unknown table", func(t *testing.T) {
+		_, err := pgbulk.TableColumns(conn, "no_such_table")
+		assert.ErrorContains(t, err, "table not found")
+	})
+
+	t.Run("mixed-case table", func(t *testing.T) {
+		cleanup := setupTestTable(t, conn, `"TestColumns"`, `CREATE TABLE "TestColumns" (name TEXT)`)
+		defer cleanup()
+
+		for _, table := range []string{"TestColumns", "public.TestColumns"} {
+			columns, err := pgbulk.TableColumns(conn, table)
+			require.NoError(t, err)
+			require.Len(t, columns, 1)
+			assert.Equal(t, "name", columns[0].Name)
+		}
+	})
+
+	t.Run("invalid identifier", func(t *testing.T) {
+		_, err := pgbulk.TableColumns(conn, "test_columns; DROP TABLE x")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,39 @@
+package pgbulk
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// ResetSequence moves the sequence behind a serial or identity column of
+// table to the current maximum of idColumn, so the next default value is
+// MAX(idColumn)+1 (1 for an empty table). It is only needed after loading rows
+// with explicit id values, which bypass the sequence and leave it behind; a
+// later insert relying on the default would otherwise collide. The table may
+// be schema-qualified; a column without an owned sequence yields an error.
+// Inserts taking default ids concurrently can race with it, so run it once the
+// load is done.
+func ResetSequence(conn *pgx.Conn, table, idColumn string) error {
+	ident, err := parseTableName(table)
+	if err != nil {
+		return err
+	}
+	if !identifierRe.MatchString(idColumn) {
+		return errors.E("invalid column identifier", "column", idColumn)
+	}
+	column := pgx.Identifier{idColumn}.Sanitize()
+
+	var next *int64
+	err = conn.QueryRow(context.Background(), `
+		SELECT setval(seq::regclass, COALESCE((SELECT MAX(`+column+`) FROM `+ident.Sanitize()+`), 0) + 1, false)
+		FROM pg_get_serial_sequence($1, $2) AS seq`, ident.Sanitize(), idColumn).Scan(&next)
+	if err != nil {
+		return errors.WrapE(err, "reset sequence", "table", table, "column", idColumn)
+	}
+	if next == nil {
+		return errors.E("column has no sequence", "table", table, "column", idColumn)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package pgbulk_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResetSequence(t *testing.T) {
+	conn := getTestConn(t)
+	ctx := context.Background()
+
+	cleanup := setupTestTable(t, conn, "test_reset_seq", `
+		CREATE TABLE test_reset_seq (
+			id SERIAL PRIMARY KEY,
+			name TEXT
+		)
+	`)
+	defer cleanup()
+
+	// Explicit ids bypass the sequence
+	data := [][]interface{}{{1, "a"}, {2, "b"}, {50, "c"}}
+	require.NoError(t, pgbulk.Insert(conn, "INSERT INTO test_reset_seq (id, name)", data))
+
+	require.NoError(t, pgbulk.ResetSequence(conn, "public.test_reset_seq", "id"))
+
+	// A serial insert now continues after the largest id
+	var id int
+	require.NoError(t, conn.QueryRow(ctx,
+		"INSERT INTO test_reset_seq (name) VALUES ('d') RETURNING id").Scan(&id))
+	assert.Equal(t, 51, id)
+
+	// Empty table: the next id is 1
+	_, err := conn.Exec(ctx, "TRUNCATE test_reset_seq")
+	require.NoError(t, err)
+	require.NoError(t, pgbulk.ResetSequence(conn, "test_reset_seq", "id"))
+	require.NoError(t, conn.QueryRow(ctx,
+		"INSERT INTO test_reset_seq (name) VALUES ('e') RETURNING id").Scan(&id))
+	assert.Equal(t, 1, id)
+
+	// Column without a sequence, invalid identifiers
+	assert.Error(t, pgbulk.ResetSequence(conn, "test_reset_seq", "name"))
+	assert.Error(t, pgbulk.ResetSequence(conn, "test_reset_seq", "id; DROP TABLE x"))
+	assert.Error(t, pgbulk.ResetSequence(conn, "bad table", "id"))
+}
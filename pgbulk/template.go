@@ -0,0 +1,57 @@
+package pgbulk
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+var (
+	// templateRe matches "INSERT INTO table (col1, col2, ...)".
+	templateRe = regexp.MustCompile(`(?i)^\s*INSERT\s+INTO\s+([^\s(]+)\s*\(([^)]*)\)`)
+	// identifierRe matches a plain, unquoted SQL identifier.
+	identifierRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// parseSQLTemplate extracts the table and column identifiers from an
+// "INSERT INTO table (col1, col2)" template. The table may be schema-qualified
+// ("schema.table"). Every identifier must match ^[a-zA-Z_][a-zA-Z0-9_]*$;
+// anything else (spaces, quotes, semicolons, comment sequences) is rejected
+// so that a careless or hostile template cannot inject SQL.
+func parseSQLTemplate(sqlTemplate string) (pgx.Identifier, []string, error) {
+	matches := templateRe.FindStringSubmatch(sqlTemplate)
+	if len(matches) != 3 {
+		return nil, nil, errors.E("invalid sqlTemplate format", "sql-template", sqlTemplate)
+	}
+
+	table, err := parseTableName(matches[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := strings.Split(matches[2], ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+		if !identifierRe.MatchString(columns[i]) {
+			return nil, nil, errors.E("invalid column identifier", "column", columns[i])
+		}
+	}
+	return table, columns, nil
+}
+
+// parseTableName validates a table name, optionally schema-qualified, and
+// returns it as a pgx.Identifier.
+func parseTableName(name string) (pgx.Identifier, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 {
+		return nil, errors.E("invalid table identifier", "table", name)
+	}
+	for _, part := range parts {
+		if !identifierRe.MatchString(part) {
+			return nil, errors.E("invalid table identifier", "table", name)
+		}
+	}
+	return pgx.Identifier(parts), nil
+}
@@ -0,0 +1,45 @@
+package pgbulk
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSQLTemplate(t *testing.T) {
+	t.Run("valid template", func(t *testing.T) {
+		table, columns, err := parseSQLTemplate("INSERT INTO users (name, email)")
+		assert.NoError(t, err)
+		assert.Equal(t, pgx.Identifier{"users"}, table)
+		assert.Equal(t, []string{"name", "email"}, columns)
+	})
+
+	t.Run("schema-qualified table", func(t *testing.T) {
+		table, columns, err := parseSQLTemplate("insert into public.users (id)")
+		assert.NoError(t, err)
+		assert.Equal(t, pgx.Identifier{"public", "users"}, table)
+		assert.Equal(t, []string{"id"}, columns)
+	})
+
+	rejected := []struct {
+		name     string
+		template string
+	}{
+		{"malformed", "INSERT users name"},
+		{"semicolon in table", "INSERT INTO users;DROP (name)"},
+		{"too many qualifiers", "INSERT INTO a.b.c (name)"},
+		{"space in column", "INSERT INTO users (first name)"},
+		{"semicolon in column", "INSERT INTO users (name; DROP TABLE users)"},
+		{"comment in column", "INSERT INTO users (name--, email)"},
+		{"block comment in column", "INSERT INTO users (name/**/)"},
+		{"quoted column", `INSERT INTO users ("name")`},
+		{"empty column", "INSERT INTO users (name,)"},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseSQLTemplate(tt.template)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -2,6 +2,8 @@ package pgbulk
 
 import (
 	"context"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -9,8 +11,15 @@ import (
 )
 
 // Update performs a bulk update using the provided SQL template, data, and ids.
-// Returns: (error, [][]interface{}), where second parameter is failed record ids
-func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]interface{}) ([][]interface{}, error) {
+// Returns: ([][]interface{}, error), where first parameter is failed record ids
+//
+// By default all statements run in one transaction and Update stops at the
+// first failure, rolling back the rest. With WithContinueOnError, every
+// statement is executed independently and the ids of all failed statements are
+// returned, together with the first error. WithIsolationLevel sets the
+// transaction's isolation level. WithWhere adds a per-row guard condition and
+// WithRowsAffected reports how many rows each statement changed.
+func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]interface{}, opts ...Option) ([][]interface{}, error) {
 	if len(data) != len(ids) {
 		return nil, errors.E("data and ids must have the same number of rows")
 	}
@@ -19,10 +28,23 @@ func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]in
 		return nil, nil
 	}
 
+	o := applyOptions(opts)
+	if o.where != "" && len(o.whereArgs) != len(data) {
+		return nil, errors.E("where args and data must have the same number of rows",
+			"where-args-rows", len(o.whereArgs), "data-rows", len(data))
+	}
+	counts := make([]int64, len(data))
+	if o.rowsAffected != nil {
+		defer func() { *o.rowsAffected = counts }()
+	}
+	if o.continueOnError {
+		return updateEach(conn, sqlTemplate, data, ids, o, counts)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	tx, err := conn.Begin(ctx)
+	tx, err := conn.BeginTx(ctx, o.txOptions())
 	if err != nil {
 		return nil, errors.WrapE(err, "start transaction")
 	}
@@ -30,8 +52,7 @@ func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]in
 
 	batch := &pgx.Batch{}
 	for i := 0; i < len(data); i++ {
-		params := append(data[i], ids[i]...)
-		batch.Queue(sqlTemplate, params...)
+		batch.Queue(updateStatement(sqlTemplate, o, i, data[i], ids[i]))
 	}
 
 	br := tx.SendBatch(ctx, batch)
@@ -39,24 +60,75 @@ func Update(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]in
 	failedIds := [][]interface{}{}
 
 	for i := 0; i < batch.Len(); i++ {
-		_, err := br.Exec()
+		tag, err := br.Exec()
 		if err != nil {
 			failedIds = append(failedIds, ids[i])
 			br.Close()
+			clear(counts)
 			return failedIds, errors.WrapE(err, "batch execution", "record-num", i)
 		}
+		counts[i] = tag.RowsAffected()
 	}
 
 	// Close batch operation
 	if err := br.Close(); err != nil {
+		clear(counts)
 		return failedIds, errors.WrapE(err, "close batch")
 
 	}
 
 	// Commit transaction
 	if err := tx.Commit(ctx); err != nil {
+		clear(counts)
 		return failedIds, errors.WrapE(err, "commit transaction")
 	}
 
 	return nil, nil
 }
+
+// updateEach executes each statement on its own, outside a transaction, and
+// collects the ids of all failed statements. It records the rows each
+// statement changed in counts.
+func updateEach(conn *pgx.Conn, sqlTemplate string, data [][]interface{}, ids [][]interface{}, o options, counts []int64) ([][]interface{}, error) {
+	var failedIds [][]interface{}
+	var firstErr error
+	for i := range data {
+		sql, params := updateStatement(sqlTemplate, o, i, data[i], ids[i])
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		tag, err := conn.Exec(ctx, sql, params...)
+		cancel()
+		counts[i] = tag.RowsAffected()
+		if err != nil {
+			failedIds = append(failedIds, ids[i])
+			if firstErr == nil {
+				firstErr = errors.WrapE(err, "statement execution", "record-num", i)
+			}
+		}
+	}
+	if firstErr != nil {
+		return failedIds, errors.WrapE(firstErr, "some updates failed",
+			"failed-count", len(failedIds), "total", len(data))
+	}
+	return nil, nil
+}
+
+// placeholderRe matches a positional parameter such as $1.
+var placeholderRe = regexp.MustCompile(`\$(\d+)`)
+
+// updateStatement returns the statement and parameters Update runs for row i:
+// the row's data followed by its id values and, with WithWhere, the guard
+// condition appended with its placeholders shifted past those parameters.
+func updateStatement(sqlTemplate string, o options, i int, row, id []interface{}) (string, []interface{}) {
+	params := appendArgs(nil, row)
+	params = append(params, id...)
+	if o.where == "" {
+		return sqlTemplate, params
+	}
+	offset := len(params)
+	condition := placeholderRe.ReplaceAllStringFunc(o.where, func(p string) string {
+		n, _ := strconv.Atoi(p[1:])
+		return "$" + strconv.Itoa(n+offset)
+	})
+	return sqlTemplate + " AND (" + condition + ")", appendArgs(params, o.whereArgs[i])
+}
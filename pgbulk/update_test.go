@@ -323,6 +323,57 @@ func TestUpdate(t *testing.T) {
 		verifyData(ctx, t, conn, expected)
 	})
 
+	t.Run("Where Guard", func(t *testing.T) {
+		conn := setupConn(ctx, t)
+		defer conn.Close(ctx)
+
+		setupTable(ctx, t, conn)
+		defer cleanupTable(ctx, t, conn)
+
+		// age acts as the row version: Bob's expected value is stale
+		sqlTemplate := "UPDATE test_table SET name = $1, age = age + 1 WHERE id = $2"
+		data := [][]interface{}{{"Alice Updated"}, {"Bob Updated"}}
+		ids := [][]interface{}{{1}, {2}}
+		versions := [][]interface{}{{25}, {29}}
+
+		for _, continueOnError := range []bool{false, true} {
+			var counts []int64
+			opts := []pgbulk.Option{pgbulk.WithWhere("age = $1", versions), pgbulk.WithRowsAffected(&counts)}
+			if continueOnError {
+				// Alice is now at 26, so only a matching guard updates her again
+				versions = [][]interface{}{{26}, {29}}
+				opts = append(opts, pgbulk.WithWhere("age = $1", versions), pgbulk.WithContinueOnError())
+			}
+			failedIds, err := pgbulk.Update(conn, sqlTemplate, data, ids, opts...)
+			if err != nil {
+				t.Fatalf("Update failed: %v", err)
+			}
+			if failedIds != nil {
+				t.Errorf("Expected no failed ids, got %v", failedIds)
+			}
+			if !reflect.DeepEqual(counts, []int64{1, 0}) {
+				t.Errorf("Expected rows affected [1 0], got %v", counts)
+			}
+		}
+
+		expected := []struct {
+			id   int
+			name string
+			age  int
+			dept string
+		}{
+			{1, "Alice Updated", 27, "HR"},
+			{2, "Bob", 30, "IT"},
+			{3, "Charlie", 28, "HR"},
+		}
+		verifyData(ctx, t, conn, expected)
+
+		_, err := pgbulk.Update(conn, sqlTemplate, data, ids, pgbulk.WithWhere("age = $1", versions[:1]))
+		if err == nil {
+			t.Error("Expected error for where args row count mismatch")
+		}
+	})
+
 	t.Run("Partial Failure Due to Constraint", func(t *testing.T) {
 		conn := setupConn(ctx, t)
 		defer conn.Close(ctx)
@@ -367,4 +418,46 @@ func TestUpdate(t *testing.T) {
 			t.Log("Skipping verifyData due to closed connection")
 		}
 	})
+	t.Run("Continue On Error", func(t *testing.T) {
+		conn := setupConn(ctx, t)
+		defer conn.Close(ctx)
+
+		setupTableWithConstraint(ctx, t, conn)
+		defer cleanupTable(ctx, t, conn)
+
+		sqlTemplate := "UPDATE test_table SET name = $1, age = $2 WHERE id = $3 AND dept = $4"
+		data := [][]interface{}{
+			{"Bob", 26},             // fails (name conflict)
+			{"Bob Updated", 31},     // succeeds
+			{"Bob Updated", 29},     // fails (conflicts with the row above)
+			{"Charlie Updated", 29}, // succeeds
+		}
+		ids := [][]interface{}{
+			{1, "HR"},
+			{2, "IT"},
+			{1, "HR"},
+			{3, "HR"},
+		}
+
+		failedIds, err := pgbulk.Update(conn, sqlTemplate, data, ids, pgbulk.WithContinueOnError())
+		if err == nil {
+			t.Error("Expected error due to unique constraint violations, got nil")
+		}
+		expectedFailedIds := [][]interface{}{{1, "HR"}, {1, "HR"}}
+		if !reflect.DeepEqual(failedIds, expectedFailedIds) {
+			t.Errorf("Expected failed ids %v, got %v", expectedFailedIds, failedIds)
+		}
+
+		expected := []struct {
+			id   int
+			name string
+			age  int
+			dept string
+		}{
+			{1, "Alice", 25, "HR"},
+			{2, "Bob Updated", 31, "IT"},
+			{3, "Charlie Updated", 29, "HR"},
+		}
+		verifyData(ctx, t, conn, expected)
+	})
 }
@@ -0,0 +1,160 @@
+package pgbulk
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/kaichao/gopkg/errors"
+)
+
+// ValidateData checks data against the column types of table before a bulk load,
+// so that a type mismatch is reported cheaply instead of aborting a COPY midway.
+// Column types are read from pg_catalog; the first offending value is reported
+// with its row and column in the error context. The table name may be
+// schema-qualified and is matched case-sensitively, as the other functions
+// quote it.
+//
+// Type-mapping rules (by PostgreSQL type):
+//   - int2, int4, int8:                 Go signed/unsigned integers
+//   - float4, float8, numeric:          Go integers and floats (numeric also accepts string)
+//   - text, varchar, bpchar, name:      string
+//   - bool:                             bool
+//   - date, timestamp, timestamptz:     time.Time
+//   - bytea:                            []byte
+//   - uuid:                             string, [16]byte
+//   - json, jsonb:                      any value (marshalled by the driver)
+//   - arrays (_int4, _text, ...):       slices and arrays
+//   - other types:                      not checked
+//
+// Pointers are checked by the value they point to; a nil pointer counts as NULL.
+// nil and Null are accepted for nullable columns only. Values implementing driver.Valuer
+// (e.g. sql.NullString) are accepted for any column, since their encoding is
+// decided at runtime.
+func ValidateData(conn *pgx.Conn, table string, columns []string, data [][]interface{}) error {
+	ident, err := parseTableName(table)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.Query(context.Background(), `
+		SELECT a.attname, t.typname, a.attnotnull
+		FROM pg_catalog.pg_attribute a
+		JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+		WHERE a.attrelid = to_regclass($1) AND a.attnum > 0 AND NOT a.attisdropped`, ident.Sanitize())
+	if err != nil {
+		return errors.WrapE(err, "query column types", "table", table)
+	}
+	type columnType struct {
+		typeName string
+		notNull  bool
+	}
+	types := make(map[string]columnType)
+	for rows.Next() {
+		var name string
+		var ct columnType
+		if err := rows.Scan(&name, &ct.typeName, &ct.notNull); err != nil {
+			rows.Close()
+			return errors.WrapE(err, "rows.Scan()")
+		}
+		types[name] = ct
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.WrapE(err, "rows.Next()")
+	}
+	if len(types) == 0 {
+		return errors.E("table not found", "table", table)
+	}
+
+	colTypes := make([]columnType, len(columns))
+	for j, col := range columns {
+		ct, ok := types[col]
+		if !ok {
+			return errors.E("column not found", "table", table, "column", col)
+		}
+		colTypes[j] = ct
+	}
+
+	for i, row := range data {
+		if len(row) != len(columns) {
+			return errors.E("row length does not match columns", "row", i,
+				"expected", len(columns), "actual", len(row))
+		}
+		for j, v := range row {
+			v = deref(v)
+			if isNull(v) {
+				if colTypes[j].notNull {
+					return errors.E("nil value for NOT NULL column", "row", i, "column", columns[j])
+				}
+				continue
+			}
+			if !assignable(colTypes[j].typeName, v) {
+				return errors.E("type mismatch", "row", i, "column", columns[j],
+					"pg-type", colTypes[j].typeName, "go-type", fmt.Sprintf("%T", v))
+			}
+		}
+	}
+	return nil
+}
+
+// deref follows pointers in v, as the driver does when encoding, and returns
+// the value pointed to, or nil for a nil pointer. Pointers implementing
+// driver.Valuer are kept as they are.
+func deref(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil
+		}
+		if _, ok := rv.Interface().(driver.Valuer); ok {
+			break
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return v
+	}
+	return rv.Interface()
+}
+
+// assignable reports whether v can be encoded into a column of PostgreSQL type typeName.
+func assignable(typeName string, v interface{}) bool {
+	if _, ok := v.(driver.Valuer); ok {
+		return true
+	}
+	kind := reflect.TypeOf(v).Kind()
+	isInt := kind >= reflect.Int && kind <= reflect.Uint64
+	isFloat := kind == reflect.Float32 || kind == reflect.Float64
+
+	if strings.HasPrefix(typeName, "_") {
+		return kind == reflect.Slice || kind == reflect.Array
+	}
+	switch typeName {
+	case "int2", "int4", "int8":
+		return isInt
+	case "float4", "float8":
+		return isInt || isFloat
+	case "numeric":
+		return isInt || isFloat || kind == reflect.String
+	case "text", "varchar", "bpchar", "name":
+		return kind == reflect.String
+	case "bool":
+		return kind == reflect.Bool
+	case "date", "timestamp", "timestamptz":
+		_, ok := v.(time.Time)
+		return ok
+	case "bytea":
+		_, ok := v.([]byte)
+		return ok
+	case "uuid":
+		_, ok := v.([16]byte)
+		return ok || kind == reflect.String
+	default:
+		return true
+	}
+}
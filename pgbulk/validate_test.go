@@ -0,0 +1,96 @@
+package pgbulk_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/kaichao/gopkg/errors"
+	"github.com/kaichao/gopkg/pgbulk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateData(t *testing.T) {
+	conn := getTestConn(t)
+
+	cleanup := setupTestTable(t, conn, "test_validate", `
+		CREATE TABLE test_validate (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL,
+			age INT,
+			score NUMERIC,
+			created TIMESTAMPTZ,
+			tags TEXT[]
+		)
+	`)
+	defer cleanup()
+
+	columns := []string{"name", "age", "score", "created", "tags"}
+
+	t.Run("valid data", func(t *testing.T) {
+		data := [][]interface{}{
+			{"Alice", 30, 1.5, time.Now(), []string{"a"}},
+			{"Bob", nil, "2.25", time.Now(), nil},
+			{"Carol", int64(5), 3, sql.NullTime{}, []string{}},
+		}
+		assert.NoError(t, pgbulk.ValidateData(conn, "test_validate", columns, data))
+	})
+
+	t.Run("type mismatch reports row and column", func(t *testing.T) {
+		data := [][]interface{}{
+			{"Alice", 30, 1.5, time.Now(), []string{"a"}},
+			{"Bob", "thirty", 1.5, time.Now(), []string{"b"}},
+		}
+		err := pgbulk.ValidateData(conn, "test_validate", columns, data)
+		assert.Error(t, err)
+		var te *errors.TracedError
+		if assert.True(t, errors.As(err, &te)) {
+			assert.Equal(t, 1, te.Context["row"])
+			assert.Equal(t, "age", te.Context["column"])
+		}
+	})
+
+	t.Run("nil in NOT NULL column", func(t *testing.T) {
+		data := [][]interface{}{{nil, 1, 1, time.Now(), nil}}
+		assert.Error(t, pgbulk.ValidateData(conn, "test_validate", columns, data))
+	})
+
+	t.Run("pointer fields", func(t *testing.T) {
+		name, age, score, now := "Alice", 30, 1.5, time.Now()
+		tags := []string{"a"}
+		data := [][]interface{}{
+			{&name, &age, &score, &now, &tags},
+			{&name, (*int)(nil), (*float64)(nil), (*time.Time)(nil), (*[]string)(nil)},
+		}
+		assert.NoError(t, pgbulk.ValidateData(conn, "test_validate", columns, data))
+
+		err := pgbulk.ValidateData(conn, "test_validate", columns,
+			[][]interface{}{{(*string)(nil), &age, &score, &now, &tags}})
+		assert.ErrorContains(t, err, "nil value for NOT NULL column")
+
+		err = pgbulk.ValidateData(conn, "test_validate", columns,
+			[][]interface{}{{&name, &name, &score, &now, &tags}})
+		assert.ErrorContains(t, err, "type mismatch")
+	})
+
+	t.Run("unknown column", func(t *testing.T) {
+		err := pgbulk.ValidateData(conn, "test_validate", []string{"missing"}, [][]interface{}{{1}})
+		assert.ErrorContains(t, err, "column not found")
+	})
+
+	t.Run("unknown table", func(t *testing.T) {
+		err := pgbulk.ValidateData(conn, "no_such_table", columns, nil)
+		assert.ErrorContains(t, err, "table not found")
+	})
+
+	t.Run("mixed-case table", func(t *testing.T) {
+		cleanup := setupTestTable(t, conn, `"TestValidate"`, `CREATE TABLE "TestValidate" (name TEXT)`)
+		defer cleanup()
+
+		for _, table := range []string{"TestValidate", "public.TestValidate"} {
+			assert.NoError(t, pgbulk.ValidateData(conn, table, []string{"name"}, [][]interface{}{{"Alice"}}), table)
+		}
+		err := pgbulk.ValidateData(conn, "testvalidate", []string{"name"}, nil)
+		assert.ErrorContains(t, err, "table not found")
+	})
+}